@@ -0,0 +1,272 @@
+// Command deepflow-query runs a panel query through the plugin's query
+// pipeline outside of Grafana and prints the resulting frames.
+//
+//	deepflow-query -settings ds.json -query query.json -from now-1h -to now
+//	deepflow-query -settings ds.json -query query.json -fixture testdata/cases/profiling.json -format json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+
+	"deepflow-grafana-backend-plugin/pkg/fixture"
+	"deepflow-grafana-backend-plugin/pkg/plugin"
+)
+
+func main() {
+	var (
+		settingsPath = flag.String("settings", "", "datasource settings JSON, the jsonData object or the datasource from /api/datasources/uid/<uid>")
+		queryPath    = flag.String("query", "-", "panel query JSON from the query inspector, a single query or the request with queries, - reads stdin")
+		fixturePath  = flag.String("fixture", "", "serve deepflow-server responses from a fixture file or a recorded directory instead of the configured server")
+		from         = flag.String("from", "", "start of the time range: now-1h, unix seconds or milliseconds, RFC3339 (default from the query, or now-1h)")
+		to           = flag.String("to", "", "end of the time range, same formats as -from (default from the query, or now)")
+		format       = flag.String("format", "table", "output format: table or json")
+		maxRows      = flag.Int("max-rows", 20, "rows printed per frame in table format")
+		verbose      = flag.Bool("v", false, "print plugin debug logs to stderr")
+	)
+	flag.Parse()
+
+	if *verbose {
+		log.DefaultLogger = log.NewWithLevel(log.Debug)
+	} else {
+		log.DefaultLogger = log.NewWithLevel(log.Warn)
+	}
+
+	if err := run(*settingsPath, *queryPath, *fixturePath, *from, *to, *format, *maxRows, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "deepflow-query:", err)
+		os.Exit(1)
+	}
+}
+
+func run(settingsPath, queryPath, fixturePath, from, to, format string, maxRows int, out io.Writer) error {
+	if settingsPath == "" {
+		return fmt.Errorf("-settings is required")
+	}
+	settings, err := loadSettings(settingsPath)
+	if err != nil {
+		return fmt.Errorf("load settings: %w", err)
+	}
+
+	if fixturePath != "" {
+		f, err := fixture.Load(fixturePath)
+		if err != nil {
+			return fmt.Errorf("load fixture: %w", err)
+		}
+		server := httptest.NewServer(f.Handler(func(r *http.Request, body []byte) {
+			fmt.Fprintf(os.Stderr, "deepflow-query: no fixture response for %s %s\n", r.URL.Path, body)
+		}))
+		defer server.Close()
+		if settings.JSONData, err = overrideUrls(settings.JSONData, server.URL); err != nil {
+			return err
+		}
+	}
+
+	req, err := loadQueries(queryPath, from, to)
+	if err != nil {
+		return fmt.Errorf("load query: %w", err)
+	}
+
+	ctx := context.Background()
+	inst, err := plugin.NewDatasource(ctx, settings)
+	if err != nil {
+		return err
+	}
+	handler := inst.(backend.QueryDataHandler)
+	req.PluginContext = backend.PluginContext{DataSourceInstanceSettings: &settings}
+	resp, err := handler.QueryData(ctx, req)
+	if err != nil {
+		return err
+	}
+	// 查询中 panic 被恢复时没有返回
+	if resp == nil {
+		return fmt.Errorf("query failed without a response, run with -v for the plugin logs")
+	}
+	return printResponse(out, resp, req.Queries, format, maxRows)
+}
+
+// loadSettings 读取数据源配置，支持 jsonData 对象或 Grafana API 返回的完整数据源
+func loadSettings(path string) (backend.DataSourceInstanceSettings, error) {
+	settings := backend.DataSourceInstanceSettings{Name: "deepflow-query"}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return settings, err
+	}
+	var ds struct {
+		JSONData       json.RawMessage   `json:"jsonData"`
+		SecureJSONData map[string]string `json:"secureJsonData"`
+	}
+	if err := json.Unmarshal(b, &ds); err != nil {
+		return settings, err
+	}
+	if len(ds.JSONData) > 0 {
+		settings.JSONData = ds.JSONData
+		settings.DecryptedSecureJSONData = ds.SecureJSONData
+	} else {
+		settings.JSONData = b
+	}
+	return settings, nil
+}
+
+func overrideUrls(jsonData json.RawMessage, serverUrl string) (json.RawMessage, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(jsonData, &m); err != nil {
+		return nil, fmt.Errorf("settings jsonData: %w", err)
+	}
+	m["requestUrl"] = serverUrl
+	m["traceUrl"] = serverUrl
+	return json.Marshal(m)
+}
+
+// loadQueries 读取面板查询，支持单个查询或查询检查器中的请求 ({"queries": [...], "from": "...", "to": "..."})
+func loadQueries(path, from, to string) (*backend.QueryDataRequest, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var request struct {
+		Queries []json.RawMessage `json:"queries"`
+		From    string            `json:"from"`
+		To      string            `json:"to"`
+	}
+	if err := json.Unmarshal(b, &request); err != nil {
+		return nil, err
+	}
+	if len(request.Queries) == 0 {
+		request.Queries = []json.RawMessage{b}
+	}
+	if from == "" {
+		from = request.From
+	}
+	if to == "" {
+		to = request.To
+	}
+	now := time.Now()
+	timeRange := backend.TimeRange{From: now.Add(-time.Hour), To: now}
+	if from != "" {
+		if timeRange.From, err = parseTime(from, now); err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+	}
+	if to != "" {
+		if timeRange.To, err = parseTime(to, now); err != nil {
+			return nil, fmt.Errorf("to: %w", err)
+		}
+	}
+
+	req := &backend.QueryDataRequest{}
+	for i, raw := range request.Queries {
+		var q struct {
+			RefID         string `json:"refId"`
+			MaxDataPoints int64  `json:"maxDataPoints"`
+			IntervalMs    int64  `json:"intervalMs"`
+		}
+		if err := json.Unmarshal(raw, &q); err != nil {
+			return nil, err
+		}
+		if q.RefID == "" {
+			q.RefID = string(rune('A' + i))
+		}
+		req.Queries = append(req.Queries, backend.DataQuery{
+			RefID:         q.RefID,
+			JSON:          raw,
+			TimeRange:     timeRange,
+			MaxDataPoints: q.MaxDataPoints,
+			Interval:      time.Duration(q.IntervalMs) * time.Millisecond,
+		})
+	}
+	return req, nil
+}
+
+// parseTime 支持 now、now-1h、秒或毫秒时间戳及 RFC3339
+func parseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "now" {
+		return now, nil
+	}
+	if strings.HasPrefix(s, "now-") {
+		d, err := parseDuration(strings.TrimPrefix(s, "now-"))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(-d), nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		// 13 位为毫秒
+		if n > 1e12 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseDuration 在 time.ParseDuration 的基础上支持 d (天)
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func printResponse(out io.Writer, resp *backend.QueryDataResponse, queries []backend.DataQuery, format string, maxRows int) error {
+	refIDs := make([]string, 0, len(queries))
+	for _, q := range queries {
+		refIDs = append(refIDs, q.RefID)
+	}
+	sort.Strings(refIDs)
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(resp)
+	case "table":
+		for _, refID := range refIDs {
+			res := resp.Responses[refID]
+			fmt.Fprintf(out, "== %s: %d frame(s)\n", refID, len(res.Frames))
+			if res.Error != nil {
+				fmt.Fprintf(out, "error (status %d): %s\n", res.Status, res.Error)
+				continue
+			}
+			for i, frame := range res.Frames {
+				table, err := frame.StringTable(-1, maxRows)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "-- frame %d %q\n%s\n", i, frame.Name, table)
+				if frame.Meta != nil {
+					for _, notice := range frame.Meta.Notices {
+						fmt.Fprintf(out, "notice (%s): %s\n", notice.Severity, notice.Text)
+					}
+				}
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, expected table or json", format)
+	}
+}
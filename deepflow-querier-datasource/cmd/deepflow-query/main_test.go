@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const fixtureCase = "../../pkg/plugin/testdata/cases/trafficQuery_timeSeries_noGroupBy.json"
+
+// writeCaseFiles 将 golden 用例中的配置及查询写为命令行读取的文件
+func writeCaseFiles(t *testing.T, path string) (string, string) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c struct {
+		Settings  map[string]interface{} `json:"settings"`
+		Query     map[string]interface{} `json:"query"`
+		QueryText map[string]interface{} `json:"queryText"`
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+	settings := map[string]interface{}{"requestUrl": "http://127.0.0.1:1", "disableCache": true}
+	for k, v := range c.Settings {
+		settings[k] = v
+	}
+	queryText, _ := json.Marshal(c.QueryText)
+	c.Query["queryText"] = string(queryText)
+	c.Query["refId"] = "A"
+
+	dir := t.TempDir()
+	settingsPath, queryPath := filepath.Join(dir, "ds.json"), filepath.Join(dir, "query.json")
+	for path, v := range map[string]interface{}{settingsPath: settings, queryPath: c.Query} {
+		b, _ := json.Marshal(v)
+		if err := os.WriteFile(path, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return settingsPath, queryPath
+}
+
+func TestRunFixture(t *testing.T) {
+	settingsPath, queryPath := writeCaseFiles(t, fixtureCase)
+
+	var out bytes.Buffer
+	if err := run(settingsPath, queryPath, fixtureCase, "1700000000", "1700003600", "table", 20, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"== A: 1 frame(s)", "Sum(byte)", "2560", "1024"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("table output has no %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := run(settingsPath, queryPath, fixtureCase, "1700000000", "1700003600", "json", 20, &out); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Results map[string]struct {
+			Frames []json.RawMessage `json:"frames"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		t.Fatalf("json output: %v\n%s", err, out.String())
+	}
+	if len(resp.Results["A"].Frames) != 1 {
+		t.Errorf("json output has %d frames, want 1", len(resp.Results["A"].Frames))
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	settingsPath, queryPath := writeCaseFiles(t, fixtureCase)
+	var out bytes.Buffer
+	if err := run("", queryPath, "", "", "", "table", 20, &out); err == nil {
+		t.Error("run without -settings succeeded")
+	}
+	if err := run(settingsPath, queryPath, "testdata/missing.json", "", "", "table", 20, &out); err == nil {
+		t.Error("run with a missing fixture succeeded")
+	}
+	if err := run(settingsPath, queryPath, fixtureCase, "1700000000", "1700003600", "yaml", 20, &out); err == nil {
+		t.Error("run with an unknown format succeeded")
+	}
+}
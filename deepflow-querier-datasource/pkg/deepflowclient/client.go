@@ -0,0 +1,273 @@
+// Package deepflowclient is a client for the query APIs of deepflow-server:
+// /v1/query/, /v1/profile/ProfileGrafana and /v1/stats/querier/L7FlowTracing.
+// It only depends on the standard library so that it can be used outside the
+// Grafana plugin.
+package deepflowclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	QueryPath   = "/v1/query/"
+	ProfilePath = "/v1/profile/ProfileGrafana"
+	TracePath   = "/v1/stats/querier/L7FlowTracing"
+
+	// Time macros in sql, replaced by QuerierRequest.From and QuerierRequest.To.
+	TimeFromMacro = "'${__from:date:seconds}'"
+	TimeToMacro   = "'${__to:date:seconds}'"
+
+	DefaultMaxRows  = 100000
+	DefaultMaxBytes = 256 << 20
+)
+
+// QuerierRequest is a sql query sent to /v1/query/ or /v1/profile/ProfileGrafana.
+type QuerierRequest struct {
+	Db               string
+	Sql              string
+	DataPrecision    string
+	ProfileEventType string
+	Debug            bool
+	From             int64
+	To               int64
+}
+
+// Form returns the form fields sent to deepflow-server, with the time macros
+// of Sql replaced.
+func (r QuerierRequest) Form() url.Values {
+	sql := strings.ReplaceAll(r.Sql, TimeFromMacro, strconv.FormatInt(r.From, 10))
+	sql = strings.ReplaceAll(sql, TimeToMacro, strconv.FormatInt(r.To, 10))
+
+	form := url.Values{}
+	form.Set("sql", sql)
+	if r.Db != "" {
+		form.Set("db", r.Db)
+	}
+	if r.DataPrecision != "" {
+		form.Set("data_precision", r.DataPrecision)
+	}
+	if r.ProfileEventType != "" {
+		form.Set("profile_event_type", r.ProfileEventType)
+	}
+	return form
+}
+
+// QuerierResponse is the response of /v1/query/ and /v1/profile/ProfileGrafana.
+// Values holds the rows by column in the order of Columns, it is nil when
+// deepflow-server returns null values.
+type QuerierResponse struct {
+	OptStatus   string
+	Description string
+	Columns     []string
+	Schemas     []interface{}
+	Values      *Values
+	Debug       interface{}
+	// Truncated is the reason the values were cut at the client limits, empty
+	// when the result is complete.
+	Truncated string
+	// Bytes is the size of the response body read by the client, or its
+	// Content-Length when the values were truncated.
+	Bytes int64
+}
+
+// TraceRequest is a request to /v1/stats/querier/L7FlowTracing.
+type TraceRequest struct {
+	Id           string
+	Debug        bool
+	From         int64
+	To           int64
+	MaxIteration int
+}
+
+// TraceResponse is the response of /v1/stats/querier/L7FlowTracing. Data is
+// an empty list when the trace is not found, otherwise an object with
+// services and tracing.
+type TraceResponse struct {
+	OptStatus   string      `json:"OPT_STATUS"`
+	Description string      `json:"DESCRIPTION"`
+	Data        interface{} `json:"DATA"`
+	Debug       interface{} `json:"debug"`
+}
+
+// Client calls the deepflow-server query APIs. It is safe for concurrent use.
+type Client struct {
+	httpClient *http.Client
+	querierUrl string
+	traceUrl   string
+	token      string
+	header     http.Header
+	maxRows    int
+	maxBytes   int64
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the http client, http.DefaultClient by default.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithToken sends the token as a bearer Authorization header to the querier.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithTraceURL sets the deepflow-app url used by Trace, the querier url by default.
+func WithTraceURL(traceUrl string) Option {
+	return func(c *Client) { c.traceUrl = traceUrl }
+}
+
+// WithHeader adds a header to every request.
+func WithHeader(key, value string) Option {
+	return func(c *Client) { c.header.Add(key, value) }
+}
+
+// WithLimits limits the rows and bytes read from a querier response, values
+// <= 0 keep the defaults.
+func WithLimits(maxRows int, maxBytes int64) Option {
+	return func(c *Client) {
+		if maxRows > 0 {
+			c.maxRows = maxRows
+		}
+		if maxBytes > 0 {
+			c.maxBytes = maxBytes
+		}
+	}
+}
+
+// New creates a client for the deepflow-server querier at querierUrl.
+func New(querierUrl string, opts ...Option) *Client {
+	c := &Client{
+		httpClient: http.DefaultClient,
+		querierUrl: strings.TrimSuffix(querierUrl, "/"),
+		header:     http.Header{},
+		maxRows:    DefaultMaxRows,
+		maxBytes:   DefaultMaxBytes,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.traceUrl == "" {
+		c.traceUrl = c.querierUrl
+	}
+	c.traceUrl = strings.TrimSuffix(c.traceUrl, "/")
+	return c
+}
+
+// QuerierURL returns the querier url of the client.
+func (c *Client) QuerierURL() string { return c.querierUrl }
+
+// TraceURL returns the deepflow-app url of the client.
+func (c *Client) TraceURL() string { return c.traceUrl }
+
+// Query runs a sql query with /v1/query/.
+func (c *Client) Query(ctx context.Context, req QuerierRequest) (*QuerierResponse, error) {
+	return c.querier(ctx, QueryPath, req)
+}
+
+// Profile runs a profile query with /v1/profile/ProfileGrafana.
+func (c *Client) Profile(ctx context.Context, req QuerierRequest) (*QuerierResponse, error) {
+	return c.querier(ctx, ProfilePath, req)
+}
+
+func (c *Client) querier(ctx context.Context, path string, req QuerierRequest) (*QuerierResponse, error) {
+	if req.Sql == "" {
+		return nil, fmt.Errorf("sql cannot be empty")
+	}
+	form := req.Form()
+	endpoint := c.querierUrl + path + "?debug=" + strconv.FormatBool(req.Debug)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("create request failed: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.do(httpReq, form.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	res, err := decodeQuerierResponse(resp.Body, c.maxRows, c.maxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w", path, err)
+	}
+	// A truncated response is not read to the end, its size is taken from
+	// Content-Length when the server sends one.
+	if res.Truncated != "" && resp.ContentLength > res.Bytes {
+		res.Bytes = resp.ContentLength
+	}
+	return res, nil
+}
+
+// Trace queries the spans of the trace of a l7_flow_log _id.
+func (c *Client) Trace(ctx context.Context, req TraceRequest) (*TraceResponse, error) {
+	maxIteration := req.MaxIteration
+	if maxIteration <= 0 {
+		maxIteration = 30
+	}
+	postData, err := json.Marshal(map[string]interface{}{
+		"_id":           req.Id,
+		"DATABASE":      "flow_log",
+		"TABLE":         "l7_flow_log",
+		"MAX_ITERATION": maxIteration,
+		"time_start":    req.From,
+		"time_end":      req.To,
+	})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := c.traceUrl + TracePath + "?debug=" + strconv.FormatBool(req.Debug)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(postData))
+	if err != nil {
+		return nil, fmt.Errorf("create request failed: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
+	httpReq.Header.Set("X-User-Id", "1")
+	httpReq.Header.Set("X-User-Type", "1")
+
+	resp, err := c.do(httpReq, string(postData))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res TraceResponse
+	dec := json.NewDecoder(&limitedReader{r: resp.Body, n: c.maxBytes})
+	dec.UseNumber()
+	if err := dec.Decode(&res); err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w", TracePath, err)
+	}
+	return &res, nil
+}
+
+// do sends the request and returns a *StatusError for non 200 responses.
+func (c *Client) do(req *http.Request, params string) (*http.Response, error) {
+	for k, v := range c.header {
+		for _, vv := range v {
+			req.Header.Add(k, vv)
+		}
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request %s: %w", req.URL.Path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Params: params, Body: string(body)}
+	}
+	return resp, nil
+}
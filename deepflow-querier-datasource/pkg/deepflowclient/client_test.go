@@ -0,0 +1,234 @@
+package deepflowclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type recordedRequest struct {
+	path   string
+	query  url.Values
+	header http.Header
+	form   url.Values
+	body   string
+}
+
+// newServer answers every request with response and records the last request.
+func newServer(t *testing.T, status int, response string) (*httptest.Server, *recordedRequest) {
+	t.Helper()
+	rec := &recordedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		*rec = recordedRequest{path: r.URL.Path, query: r.URL.Query(), header: r.Header.Clone(), body: string(b)}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			rec.form, _ = url.ParseQuery(string(b))
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server, rec
+}
+
+const tagValuesResponse = `{"OPT_STATUS":"SUCCESS","DESCRIPTION":"","result":{"columns":["value","display_name"],"values":[[20,"HTTP"],[0,"OK"]]}}`
+
+func assertForm(t *testing.T, rec *recordedRequest, want map[string]string) {
+	t.Helper()
+	for k, v := range want {
+		if got := rec.form.Get(k); got != v {
+			t.Errorf("form field %s = %q, want %q", k, got, v)
+		}
+	}
+	for k := range rec.form {
+		if _, ok := want[k]; !ok {
+			t.Errorf("unexpected form field %s = %q", k, rec.form.Get(k))
+		}
+	}
+}
+
+func TestClientQuery(t *testing.T) {
+	server, rec := newServer(t, http.StatusOK, tagValuesResponse)
+	client := New(server.URL+"/", WithToken("secret"), WithHeader("X-Org-Id", "1"))
+
+	res, err := client.Query(context.Background(), QuerierRequest{
+		Db:            "flow_metrics",
+		Sql:           "SELECT 1 FROM network WHERE time >= '${__from:date:seconds}' AND time <= '${__to:date:seconds}'",
+		DataPrecision: "1m",
+		Debug:         true,
+		From:          100,
+		To:            200,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OptStatus != "SUCCESS" || res.Values.Len() != 2 || res.Truncated != "" {
+		t.Errorf("unexpected response %+v", res)
+	}
+	if res.Bytes != int64(len(tagValuesResponse)) {
+		t.Errorf("bytes = %d, want %d", res.Bytes, len(tagValuesResponse))
+	}
+	if len(res.Columns) != 2 || res.Columns[1] != "display_name" {
+		t.Errorf("columns = %v", res.Columns)
+	}
+	if n, ok := res.Values.Columns[0].Value(0).(json.Number); !ok || n.String() != "20" {
+		t.Errorf("values[0][0] = %#v, want json.Number 20", res.Values.Columns[0].Value(0))
+	}
+	if name := res.Values.Column("display_name"); name == nil || name.Text(1) != "OK" {
+		t.Errorf("display_name column = %+v", name)
+	}
+
+	if rec.path != QueryPath {
+		t.Errorf("path = %s, want %s", rec.path, QueryPath)
+	}
+	if rec.query.Get("debug") != "true" {
+		t.Errorf("debug = %s, want true", rec.query.Get("debug"))
+	}
+	if got := rec.header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer secret")
+	}
+	if got := rec.header.Get("X-Org-Id"); got != "1" {
+		t.Errorf("X-Org-Id = %q, want 1", got)
+	}
+	assertForm(t, rec, map[string]string{
+		"sql":            "SELECT 1 FROM network WHERE time >= 100 AND time <= 200",
+		"db":             "flow_metrics",
+		"data_precision": "1m",
+	})
+}
+
+func TestClientProfile(t *testing.T) {
+	server, rec := newServer(t, http.StatusOK, tagValuesResponse)
+	client := New(server.URL)
+
+	_, err := client.Profile(context.Background(), QuerierRequest{
+		Sql:              "SELECT profile_location_str FROM in_process",
+		ProfileEventType: "on-cpu",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.path != ProfilePath {
+		t.Errorf("path = %s, want %s", rec.path, ProfilePath)
+	}
+	if _, ok := rec.header["Authorization"]; ok {
+		t.Errorf("unexpected Authorization header without token")
+	}
+	assertForm(t, rec, map[string]string{
+		"sql":                "SELECT profile_location_str FROM in_process",
+		"profile_event_type": "on-cpu",
+	})
+}
+
+func TestClientTrace(t *testing.T) {
+	server, rec := newServer(t, http.StatusOK, `{"OPT_STATUS":"SUCCESS","DATA":[]}`)
+	client := New("http://querier.invalid", WithTraceURL(server.URL), WithToken("secret"))
+
+	res, err := client.Trace(context.Background(), TraceRequest{Id: "123", From: 100, To: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.Data.([]interface{}); !ok {
+		t.Errorf("Data = %#v, want empty list", res.Data)
+	}
+	if rec.path != TracePath {
+		t.Errorf("path = %s, want %s", rec.path, TracePath)
+	}
+	if got := rec.header.Get("Content-Type"); got != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(rec.body), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"_id":           "123",
+		"DATABASE":      "flow_log",
+		"TABLE":         "l7_flow_log",
+		"MAX_ITERATION": float64(30),
+		"time_start":    float64(100),
+		"time_end":      float64(200),
+	}
+	for k, v := range want {
+		if body[k] != v {
+			t.Errorf("body %s = %v, want %v", k, body[k], v)
+		}
+	}
+}
+
+func TestClientStatusError(t *testing.T) {
+	server, _ := newServer(t, http.StatusUnauthorized, "invalid token")
+	client := New(server.URL)
+
+	_, err := client.Query(context.Background(), QuerierRequest{Sql: "show databases"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("err = %v, want *StatusError", err)
+	}
+	if statusErr.StatusCode != http.StatusUnauthorized || statusErr.Body != "invalid token" {
+		t.Errorf("unexpected %+v", statusErr)
+	}
+	if !IsAuthError(err) {
+		t.Errorf("IsAuthError(%v) = false", err)
+	}
+	if !strings.Contains(statusErr.Params, "show+databases") || strings.Contains(err.Error(), "show") {
+		t.Errorf("Params = %q, Error() = %q, want the SQL only in Params", statusErr.Params, err.Error())
+	}
+}
+
+func TestClientLimits(t *testing.T) {
+	rows := make([]string, 10)
+	for i := range rows {
+		rows[i] = fmt.Sprintf(`[%d,"v%d"]`, i, i)
+	}
+	response := `{"OPT_STATUS":"SUCCESS","result":{"columns":["a","b"],"values":[` + strings.Join(rows, ",") + `]}}`
+	server, _ := newServer(t, http.StatusOK, response)
+
+	res, err := New(server.URL, WithLimits(3, 0)).Query(context.Background(), QuerierRequest{Sql: "SELECT a, b FROM t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Values.Len() != 3 || res.Truncated == "" {
+		t.Errorf("got %d rows, truncated %q, want 3 rows truncated", res.Values.Len(), res.Truncated)
+	}
+	if res.Bytes != int64(len(response)) {
+		t.Errorf("Bytes = %d, want the Content-Length %d", res.Bytes, len(response))
+	}
+	if b := res.Values.Column("b"); b == nil || b.Text(2) != "v2" {
+		t.Errorf("column b is not named after truncation at 3 rows")
+	}
+
+	// columns 在 values 之后时也按列名返回截断的行
+	reordered := `{"OPT_STATUS":"SUCCESS","result":{"values":[` + strings.Join(rows, ",") + `],"columns":["a","b"]}}`
+	reorderedServer, _ := newServer(t, http.StatusOK, reordered)
+	res, err = New(reorderedServer.URL, WithLimits(3, 0)).Query(context.Background(), QuerierRequest{Sql: "SELECT a, b FROM t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := res.Values.Column("b"); res.Values.Len() != 3 || res.Truncated == "" || b == nil || b.Text(2) != "v2" {
+		t.Errorf("columns after values: got %d rows, truncated %q, want 3 rows with column b", res.Values.Len(), res.Truncated)
+	}
+
+	res, err = New(server.URL, WithLimits(0, 80)).Query(context.Background(), QuerierRequest{Sql: "SELECT a, b FROM t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Values.Len() == 0 || res.Values.Len() == 10 || res.Truncated == "" {
+		t.Errorf("got %d rows, truncated %q, want a truncated result", res.Values.Len(), res.Truncated)
+	}
+	if res.Values.Column("b") == nil {
+		t.Errorf("column b is not named after truncation at 80 bytes")
+	}
+
+	_, err = New(server.URL, WithLimits(0, 10)).Query(context.Background(), QuerierRequest{Sql: "SELECT a, b FROM t"})
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("err = %v, want ErrResponseTooLarge", err)
+	}
+}
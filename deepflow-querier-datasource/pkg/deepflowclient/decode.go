@@ -0,0 +1,183 @@
+package deepflowclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var errTruncated = errors.New("result truncated")
+
+// limitedReader returns ErrResponseTooLarge after n bytes.
+type limitedReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		l.exceeded = true
+		return 0, ErrResponseTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// decodeQuerierResponse decodes a querier response as a stream, values are
+// decoded row by row. Reading stops at maxRows rows or maxBytes bytes and the
+// rows decoded so far are returned with Truncated set.
+func decodeQuerierResponse(r io.Reader, maxRows int, maxBytes int64) (*QuerierResponse, error) {
+	res := &QuerierResponse{}
+	lr := &limitedReader{r: r, n: maxBytes}
+	dec := json.NewDecoder(lr)
+	dec.UseNumber()
+
+	err := decodeObject(dec, func(key string) error {
+		switch strings.ToLower(key) {
+		case "opt_status":
+			return dec.Decode(&res.OptStatus)
+		case "description":
+			return dec.Decode(&res.Description)
+		case "debug":
+			return dec.Decode(&res.Debug)
+		case "result":
+			return decodeResult(dec, res, maxRows)
+		default:
+			var skip interface{}
+			return dec.Decode(&skip)
+		}
+	})
+	// The rest of a truncated response is not read, Bytes is what was read
+	// so far and the caller closes the body.
+	res.Bytes = maxBytes - lr.n
+	if errors.Is(err, errTruncated) {
+		return res, res.Values.setNames(res.Columns)
+	}
+	if err == nil && res.Values != nil {
+		err = res.Values.setNames(res.Columns)
+	}
+	if err != nil && lr.exceeded {
+		// 一行都没有解析出来时截断没有意义，直接报错
+		if res.Values.Len() == 0 {
+			return res, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, maxBytes)
+		}
+		res.Truncated = fmt.Sprintf("response exceeds %d bytes", maxBytes)
+		// columns 在 values 之后时没有读到列名，行仍按位置返回
+		if res.Columns != nil {
+			return res, res.Values.setNames(res.Columns)
+		}
+		return res, nil
+	}
+	return res, err
+}
+
+func decodeResult(dec *json.Decoder, res *QuerierResponse, maxRows int) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("unexpected token %v, result should be an object", tok)
+	}
+	return decodeObjectBody(dec, func(key string) error {
+		switch key {
+		case "columns":
+			var columns []interface{}
+			if err := dec.Decode(&columns); err != nil {
+				return err
+			}
+			res.Columns = make([]string, len(columns))
+			for i, c := range columns {
+				res.Columns[i] = fmt.Sprint(c)
+			}
+			// 截断的 values 在 columns 之前，读到列名后不再读取
+			if res.Truncated != "" {
+				return errTruncated
+			}
+			return nil
+		case "schemas":
+			return dec.Decode(&res.Schemas)
+		case "values":
+		default:
+			var skip interface{}
+			return dec.Decode(&skip)
+		}
+
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok == nil {
+			return nil
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return fmt.Errorf("unexpected token %v, values should be an array", tok)
+		}
+		// 逐行解析后按列写入类型化的切片，不保留每行的 []interface{}
+		res.Values = NewValues(res.Columns)
+		var row []interface{}
+		for dec.More() {
+			if res.Values.Len() >= maxRows {
+				res.Truncated = fmt.Sprintf("result exceeds %d rows", maxRows)
+				if res.Columns != nil {
+					return errTruncated
+				}
+				// 还没有读到列名时跳过其余的行，继续读取 columns
+				var skip json.RawMessage
+				if err := dec.Decode(&skip); err != nil {
+					return err
+				}
+				continue
+			}
+			row = row[:0]
+			if err := dec.Decode(&row); err != nil {
+				return err
+			}
+			if err := res.Values.AppendRow(row); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	})
+}
+
+// decodeObject reads a JSON object, the value of every key is decoded by fn.
+func decodeObject(dec *json.Decoder, fn func(key string) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("unexpected token %v, response should be an object", tok)
+	}
+	return decodeObjectBody(dec, fn)
+}
+
+func decodeObjectBody(dec *json.Decoder, fn func(key string) error) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("unexpected token %v, expected object key", tok)
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
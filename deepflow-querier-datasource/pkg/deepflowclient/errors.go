@@ -0,0 +1,31 @@
+package deepflowclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrResponseTooLarge is returned when a response exceeds the byte limit
+// before a single row could be read.
+var ErrResponseTooLarge = errors.New("response size limit exceeded")
+
+// StatusError is returned when deepflow-server does not answer 200 OK.
+type StatusError struct {
+	StatusCode int
+	// Params are the encoded request parameters. They hold the raw SQL and
+	// are left out of Error so that callers can redact them before logging.
+	Params string
+	Body   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("the expected status code returns 200, the actual return is %d, and the data is %v", e.StatusCode, e.Body)
+}
+
+// IsAuthError reports whether err is a 401 or 403 response.
+func IsAuthError(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) &&
+		(statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden)
+}
@@ -0,0 +1,438 @@
+package deepflowclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxExactInt is the largest integer a float64 holds without losing precision.
+const maxExactInt = 1 << 53
+
+type columnKind uint8
+
+const (
+	// kindNull is a column without any non-null value yet.
+	kindNull columnKind = iota
+	kindInt
+	kindFloat
+	kindString
+	// kindMixed keeps one interface{} per value.
+	kindMixed
+)
+
+// Column is one column of a querier result. Values are kept in a slice of the
+// column type: int64 when every number is an integer, float64 for other
+// numbers and string for strings. A column mixing types, or holding arrays and
+// objects, falls back to one interface{} per value.
+type Column struct {
+	Name string
+
+	kind    columnKind
+	n       int
+	ints    []int64
+	floats  []float64
+	strings []string
+	values  []interface{}
+	// nulls is allocated at the first null value.
+	nulls []bool
+}
+
+// NewColumn creates an empty column.
+func NewColumn(name string) *Column {
+	return &Column{Name: name}
+}
+
+// Len returns the number of values.
+func (c *Column) Len() int { return c.n }
+
+// Append adds a value decoded with json.Decoder.UseNumber: nil, json.Number,
+// string, or any other JSON value.
+func (c *Column) Append(v interface{}) {
+	if v == nil {
+		c.appendNull()
+		return
+	}
+	if c.kind == kindNull {
+		c.setKind(v)
+	}
+	if !c.appendTyped(v) {
+		c.toMixed()
+		c.values = append(c.values, v)
+	}
+	if c.nulls != nil {
+		c.nulls = append(c.nulls, false)
+	}
+	c.n++
+}
+
+func (c *Column) appendNull() {
+	if c.nulls == nil {
+		c.nulls = make([]bool, c.n, c.n+1)
+	}
+	c.nulls = append(c.nulls, true)
+	switch c.kind {
+	case kindInt:
+		c.ints = append(c.ints, 0)
+	case kindFloat:
+		c.floats = append(c.floats, 0)
+	case kindString:
+		c.strings = append(c.strings, "")
+	case kindMixed:
+		c.values = append(c.values, nil)
+	}
+	c.n++
+}
+
+// setKind picks the storage from the first non-null value, the values before
+// it are nulls.
+func (c *Column) setKind(v interface{}) {
+	switch t := v.(type) {
+	case json.Number:
+		if _, err := t.Int64(); err == nil {
+			c.kind, c.ints = kindInt, make([]int64, c.n)
+		} else {
+			c.kind, c.floats = kindFloat, make([]float64, c.n)
+		}
+	case string:
+		c.kind, c.strings = kindString, make([]string, c.n)
+	default:
+		c.kind, c.values = kindMixed, make([]interface{}, c.n)
+	}
+}
+
+func (c *Column) appendTyped(v interface{}) bool {
+	switch c.kind {
+	case kindInt:
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		if i, err := n.Int64(); err == nil {
+			c.ints = append(c.ints, i)
+			return true
+		}
+		if !c.intsToFloats() {
+			return false
+		}
+		return c.appendTyped(v)
+	case kindFloat:
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		f, ok := exactFloat(n)
+		if ok {
+			c.floats = append(c.floats, f)
+		}
+		return ok
+	case kindString:
+		s, ok := v.(string)
+		if ok {
+			c.strings = append(c.strings, s)
+		}
+		return ok
+	}
+	return false
+}
+
+// exactFloat parses n, integers that a float64 cannot hold exactly are rejected.
+func exactFloat(n json.Number) (float64, bool) {
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return 0, false
+	}
+	if !strings.ContainsAny(string(n), ".eE") && math.Abs(f) > maxExactInt {
+		return 0, false
+	}
+	return f, true
+}
+
+func (c *Column) intsToFloats() bool {
+	floats := make([]float64, len(c.ints), cap(c.ints))
+	for i, v := range c.ints {
+		if v > maxExactInt || v < -maxExactInt {
+			return false
+		}
+		floats[i] = float64(v)
+	}
+	c.kind, c.floats, c.ints = kindFloat, floats, nil
+	return true
+}
+
+func (c *Column) toMixed() {
+	if c.kind == kindMixed {
+		return
+	}
+	values := make([]interface{}, c.n, c.n+1)
+	for i := range values {
+		values[i] = c.Value(i)
+	}
+	c.kind, c.values = kindMixed, values
+	c.ints, c.floats, c.strings = nil, nil, nil
+}
+
+// IsNull reports whether the i-th value is null.
+func (c *Column) IsNull(i int) bool {
+	switch {
+	case c.kind == kindNull:
+		return true
+	case c.nulls != nil && c.nulls[i]:
+		return true
+	case c.kind == kindMixed:
+		return c.values[i] == nil
+	}
+	return false
+}
+
+// Value returns the i-th value as decoded by json.Decoder.UseNumber: nil,
+// json.Number, string, or any other JSON value.
+func (c *Column) Value(i int) interface{} {
+	if c.IsNull(i) {
+		return nil
+	}
+	switch c.kind {
+	case kindInt:
+		return json.Number(strconv.FormatInt(c.ints[i], 10))
+	case kindFloat:
+		return json.Number(formatFloat(c.floats[i]))
+	case kindString:
+		return c.strings[i]
+	}
+	return c.values[i]
+}
+
+// Float returns the i-th value if it is a number.
+func (c *Column) Float(i int) (float64, bool) {
+	if c.IsNull(i) {
+		return 0, false
+	}
+	switch c.kind {
+	case kindInt:
+		return float64(c.ints[i]), true
+	case kindFloat:
+		return c.floats[i], true
+	case kindMixed:
+		if n, ok := c.values[i].(json.Number); ok {
+			f, err := n.Float64()
+			return f, err == nil
+		}
+	}
+	return 0, false
+}
+
+// Text returns the i-th value as a string: numbers as in the response, null as
+// "null", arrays and objects as JSON.
+func (c *Column) Text(i int) string {
+	if c.IsNull(i) {
+		return "null"
+	}
+	switch c.kind {
+	case kindInt:
+		return strconv.FormatInt(c.ints[i], 10)
+	case kindFloat:
+		return formatFloat(c.floats[i])
+	case kindString:
+		return c.strings[i]
+	}
+	switch v := c.values[i].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	b, _ := json.Marshal(c.values[i])
+	return string(b)
+}
+
+// IsNumber reports whether every non-null value of the column is a number.
+func (c *Column) IsNumber() bool {
+	return c.kind == kindInt || c.kind == kindFloat
+}
+
+// formatFloat formats f like encoding/json.
+func formatFloat(f float64) string {
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	s := strconv.FormatFloat(f, format, -1, 64)
+	if format == 'e' {
+		// e-09 -> e-9
+		if n := len(s); n >= 4 && s[n-4] == 'e' && s[n-3] == '-' && s[n-2] == '0' {
+			s = s[:n-2] + s[n-1:]
+		}
+	}
+	return s
+}
+
+// Values are the rows of a querier result stored by column, see Column.
+type Values struct {
+	Columns []*Column
+	rows    int
+}
+
+// NewValues creates empty values with the given column names. Without names
+// the columns are created from the first row.
+func NewValues(names []string) *Values {
+	v := &Values{Columns: make([]*Column, len(names))}
+	for i, name := range names {
+		v.Columns[i] = NewColumn(name)
+	}
+	return v
+}
+
+// Len returns the number of rows, 0 for nil values.
+func (v *Values) Len() int {
+	if v == nil {
+		return 0
+	}
+	return v.rows
+}
+
+// AppendRow adds a row, its length must match the columns.
+func (v *Values) AppendRow(row []interface{}) error {
+	if len(v.Columns) == 0 && v.rows == 0 {
+		v.Columns = make([]*Column, len(row))
+		for i := range v.Columns {
+			v.Columns[i] = NewColumn("")
+		}
+	}
+	if len(row) != len(v.Columns) {
+		return fmt.Errorf("row %d has %d values, expected %d columns", v.rows, len(row), len(v.Columns))
+	}
+	for i, value := range row {
+		v.Columns[i].Append(value)
+	}
+	v.rows++
+	return nil
+}
+
+// Row returns the i-th row.
+func (v *Values) Row(i int) []interface{} {
+	row := make([]interface{}, len(v.Columns))
+	for j, c := range v.Columns {
+		row[j] = c.Value(i)
+	}
+	return row
+}
+
+// Column returns the first column named name, nil if there is none.
+func (v *Values) Column(name string) *Column {
+	if v == nil {
+		return nil
+	}
+	for _, c := range v.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Filter returns the rows for which keep returns true.
+func (v *Values) Filter(keep func(row int) bool) *Values {
+	result := &Values{Columns: make([]*Column, len(v.Columns))}
+	for j, c := range v.Columns {
+		result.Columns[j] = NewColumn(c.Name)
+	}
+	for i := 0; i < v.rows; i++ {
+		if keep(i) {
+			result.appendFrom(v, i)
+		}
+	}
+	return result
+}
+
+// Concat appends the rows of o, both must have the same columns.
+func (v *Values) Concat(o *Values) error {
+	if o.Len() == 0 {
+		return nil
+	}
+	if len(o.Columns) != len(v.Columns) {
+		return fmt.Errorf("cannot concat %d columns to %d columns", len(o.Columns), len(v.Columns))
+	}
+	for i := 0; i < o.rows; i++ {
+		v.appendFrom(o, i)
+	}
+	return nil
+}
+
+func (v *Values) appendFrom(o *Values, row int) {
+	for j, c := range o.Columns {
+		v.Columns[j].Append(c.Value(row))
+	}
+	v.rows++
+}
+
+// setNames names the columns created from the first row.
+func (v *Values) setNames(names []string) error {
+	if v.rows > 0 && len(names) != len(v.Columns) {
+		return fmt.Errorf("values have %d columns, expected %d", len(v.Columns), len(names))
+	}
+	if len(v.Columns) != len(names) {
+		*v = *NewValues(names)
+	}
+	for i, name := range names {
+		v.Columns[i].Name = name
+	}
+	return nil
+}
+
+// MarshalJSON encodes the rows as the values of a querier response.
+func (v *Values) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	enc := json.NewEncoder(&buf)
+	for i := 0; i < v.rows; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := enc.Encode(v.Row(i)); err != nil {
+			return nil, err
+		}
+		// Encode 以换行结尾
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes rows encoded by MarshalJSON, the columns are unnamed
+// until the names are set by QuerierResponse.UnmarshalJSON.
+func (v *Values) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var rows [][]interface{}
+	if err := dec.Decode(&rows); err != nil {
+		return err
+	}
+	*v = Values{}
+	for _, row := range rows {
+		if err := v.AppendRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// querierResponseJSON is the JSON encoding of a QuerierResponse, used to cache
+// results.
+type querierResponseJSON QuerierResponse
+
+// UnmarshalJSON decodes a QuerierResponse encoded with json.Marshal.
+func (r *QuerierResponse) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode((*querierResponseJSON)(r)); err != nil {
+		return err
+	}
+	if r.Values != nil {
+		return r.Values.setNames(r.Columns)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package deepflowclient
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeValues(t *testing.T, response string) *QuerierResponse {
+	t.Helper()
+	res, err := decodeQuerierResponse(strings.NewReader(response), DefaultMaxRows, DefaultMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestValuesColumnTypes(t *testing.T) {
+	res := decodeValues(t, `{"result":{"columns":["int","float","string","mixed","null","big"],"values":[
+		[1, 1, "a", 1, null, 7234567890123456789],
+		[null, 2.5, null, "b", null, 18446744073709551615],
+		[3, null, "c", [1, 2], null, 1]
+	]}}`)
+	v := res.Values
+	if v.Len() != 3 || len(v.Columns) != 6 {
+		t.Fatalf("got %d rows, %d columns", v.Len(), len(v.Columns))
+	}
+	want := map[string]columnKind{"int": kindInt, "float": kindFloat, "string": kindString, "mixed": kindMixed, "null": kindNull, "big": kindMixed}
+	for name, kind := range want {
+		if got := v.Column(name).kind; got != kind {
+			t.Errorf("column %s: kind = %d, want %d", name, got, kind)
+		}
+	}
+
+	rows := [][]interface{}{
+		{json.Number("1"), json.Number("1"), "a", json.Number("1"), nil, json.Number("7234567890123456789")},
+		{nil, json.Number("2.5"), nil, "b", nil, json.Number("18446744073709551615")},
+		{json.Number("3"), nil, "c", []interface{}{json.Number("1"), json.Number("2")}, nil, json.Number("1")},
+	}
+	for i, row := range rows {
+		if got := v.Row(i); !reflect.DeepEqual(got, row) {
+			t.Errorf("row %d = %#v, want %#v", i, got, row)
+		}
+	}
+
+	if f, ok := v.Column("float").Float(0); !ok || f != 1 {
+		t.Errorf("Float = %v, %v, want 1", f, ok)
+	}
+	if _, ok := v.Column("int").Float(1); ok {
+		t.Error("Float of a null value is ok")
+	}
+	if got := v.Column("mixed").Text(2); got != "[1,2]" {
+		t.Errorf("Text = %q, want [1,2]", got)
+	}
+	if got := v.Column("null").Text(0); got != "null" {
+		t.Errorf("Text = %q, want null", got)
+	}
+	// 超过 float64 精度的整数保持原样
+	if got := v.Column("big").Text(1); got != "18446744073709551615" {
+		t.Errorf("Text = %q", got)
+	}
+}
+
+func TestValuesJSON(t *testing.T) {
+	res := decodeValues(t, `{"OPT_STATUS":"SUCCESS","result":{"values":[[1,"a",0.000001],[2,null,1e-7]],"columns":["a","b","c"]}}`)
+	if res.Values.Column("b") == nil {
+		t.Fatal("columns after values are not named")
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded QuerierResponse
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.OptStatus != "SUCCESS" || decoded.Values.Len() != 2 {
+		t.Fatalf("decoded %+v", decoded)
+	}
+	for i := 0; i < 2; i++ {
+		if got, want := decoded.Values.Row(i), res.Values.Row(i); !reflect.DeepEqual(got, want) {
+			t.Errorf("row %d = %#v, want %#v", i, got, want)
+		}
+	}
+	if got := decoded.Values.Column("c").Text(1); got != "1e-7" {
+		t.Errorf("Text = %q, want 1e-7", got)
+	}
+
+	var null QuerierResponse
+	if err := json.Unmarshal([]byte(`{"Columns":["a"],"Values":null}`), &null); err != nil || null.Values != nil {
+		t.Errorf("null values decoded as %+v, %v", null.Values, err)
+	}
+}
+
+func TestValuesRowLength(t *testing.T) {
+	_, err := decodeQuerierResponse(strings.NewReader(`{"result":{"columns":["a","b"],"values":[[1,2],[3]]}}`), DefaultMaxRows, DefaultMaxBytes)
+	if err == nil || !strings.Contains(err.Error(), "row 1 has 1 values, expected 2 columns") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestValuesFilterConcat(t *testing.T) {
+	v := decodeValues(t, `{"result":{"columns":["t","v"],"values":[[60,1],[120,2],[180,3]]}}`).Values
+	kept := v.Filter(func(i int) bool { return i != 1 })
+	if kept.Len() != 2 || kept.Column("t").Text(1) != "180" {
+		t.Fatalf("filtered rows: %d", kept.Len())
+	}
+	other := decodeValues(t, `{"result":{"columns":["t","v"],"values":[[240,4.5]]}}`).Values
+	if err := kept.Concat(other); err != nil {
+		t.Fatal(err)
+	}
+	if kept.Len() != 3 || kept.Column("v").Text(2) != "4.5" || kept.Column("v").Text(0) != "1" {
+		t.Errorf("concat rows: %v", kept.Row(2))
+	}
+	if err := kept.Concat(decodeValues(t, `{"result":{"columns":["t"],"values":[[1]]}}`).Values); err == nil {
+		t.Error("concat of different columns succeeded")
+	}
+}
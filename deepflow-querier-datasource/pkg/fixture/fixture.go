@@ -0,0 +1,120 @@
+// Package fixture serves canned deepflow-server responses, used by the golden
+// tests and by cmd/deepflow-query to run panel queries without a cluster.
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// sqlTimeBoundRe matches the time bounds substituted into the sql, time >= 1700000000.
+	sqlTimeBoundRe = regexp.MustCompile("(?i)(`?\\btime`?\\s*(?:>=|<=|>|<|=)\\s*)'?\\d+'?")
+	// traceTimeBoundRe matches the time range of a tracing request body.
+	traceTimeBoundRe = regexp.MustCompile(`("time_(?:start|end)"\s*:\s*)\d+`)
+)
+
+// NormalizeTimeBounds replaces the absolute time bounds of a sql or a tracing
+// request body with ?, so a request matches a recording of another time range.
+func NormalizeTimeBounds(s string) string {
+	s = sqlTimeBoundRe.ReplaceAllString(s, "${1}?")
+	return traceTimeBoundRe.ReplaceAllString(s, "${1}?")
+}
+
+// Route is a canned response. A request matches when its path is Path and its
+// sql (or raw body for JSON requests) contains Match. Routes are tried in order,
+// first as they are and then with the time bounds of both sides normalized.
+type Route struct {
+	Path   string          `json:"path"`
+	Match  string          `json:"match,omitempty"`
+	Status int             `json:"status,omitempty"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// Fixture is a list of canned responses, the responses field of a golden case.
+type Fixture struct {
+	Responses []Route `json:"responses"`
+}
+
+// Load reads a fixture file, or all *.json files of a directory in name order.
+func Load(path string) (*Fixture, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+	f := &Fixture{}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var part Fixture
+		if err := json.Unmarshal(b, &part); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		f.Responses = append(f.Responses, part.Responses...)
+	}
+	return f, nil
+}
+
+// Match returns the first route matching the request path and body.
+func (f *Fixture) Match(path string, body []byte, contentType string) (Route, bool) {
+	subject := string(body)
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(subject); err == nil {
+			subject = form.Get("sql")
+		}
+	}
+	for _, route := range f.Responses {
+		if route.Path == path && strings.Contains(subject, route.Match) {
+			return route, true
+		}
+	}
+	// 面板时间范围与录制时不同，sql 中的时间条件不同
+	normalized := NormalizeTimeBounds(subject)
+	for _, route := range f.Responses {
+		if route.Path == path && strings.Contains(normalized, NormalizeTimeBounds(route.Match)) {
+			return route, true
+		}
+	}
+	return Route{}, false
+}
+
+// Handler serves the fixture. Unmatched requests get 404 and are passed to
+// onMiss when it is not nil.
+func (f *Fixture) Handler(onMiss func(r *http.Request, body []byte)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		route, ok := f.Match(r.URL.Path, body, r.Header.Get("Content-Type"))
+		if !ok {
+			if onMiss != nil {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				onMiss(r, body)
+			}
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if route.Status != 0 {
+			w.WriteHeader(route.Status)
+		}
+		w.Write(route.Body)
+	})
+}
@@ -0,0 +1,196 @@
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are never written to a recording.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "X-Api-Key", "X-Grafana-Id"}
+
+// Recording is one request and response pair. Its Responses can be loaded
+// with Load, so a directory of recordings is a fixture.
+type Recording struct {
+	Request   RecordedRequest `json:"request"`
+	Responses []Route         `json:"responses"`
+}
+
+// RecordedRequest is the request of a recording, with credentials redacted.
+type RecordedRequest struct {
+	Time   time.Time   `json:"time"`
+	Method string      `json:"method"`
+	Url    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Form   url.Values  `json:"form,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Recorder writes every request and response pair to a directory.
+type Recorder struct {
+	dir      string
+	maxBytes int64
+	seq      atomic.Int64
+}
+
+// NewRecorder creates dir if needed. Responses larger than maxBytes are not
+// recorded, 0 means no limit.
+func NewRecorder(dir string, maxBytes int64) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &Recorder{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// RoundTripper records the requests sent through next. Response bodies up to
+// maxBytes are read into memory, it is meant for debugging only.
+func (r *Recorder) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var reqBody []byte
+		if req.Body != nil {
+			b, err := io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			reqBody = b
+			req.Body = io.NopCloser(bytes.NewReader(b))
+		}
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		body := io.Reader(resp.Body)
+		if r.maxBytes > 0 {
+			body = io.LimitReader(resp.Body, r.maxBytes+1)
+		}
+		respBody, err := io.ReadAll(body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if r.maxBytes > 0 && int64(len(respBody)) > r.maxBytes {
+			// 超过上限的返回不录制，剩余部分交给客户端按上限截断
+			fmt.Fprintf(os.Stderr, "fixture: response of %s exceeds %d bytes, not recorded\n", req.URL.Path, r.maxBytes)
+			resp.Body = readCloser{io.MultiReader(bytes.NewReader(respBody), resp.Body), resp.Body}
+			return resp, nil
+		}
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+		if err := r.write(req, reqBody, resp.StatusCode, respBody); err != nil {
+			// 录制失败不影响查询
+			fmt.Fprintln(os.Stderr, "fixture: failed to record request:", err)
+		}
+		return resp, nil
+	})
+}
+
+func (r *Recorder) write(req *http.Request, reqBody []byte, status int, respBody []byte) error {
+	header := req.Header.Clone()
+	for _, h := range sensitiveHeaders {
+		if header.Get(h) != "" {
+			header.Set(h, redacted)
+		}
+	}
+	u := *req.URL
+	u.User = nil
+	rec := Recording{
+		Request: RecordedRequest{
+			Time:   time.Now(),
+			Method: req.Method,
+			Url:    u.String(),
+			Header: header,
+		},
+	}
+	route := Route{Path: req.URL.Path, Status: status}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, _ := url.ParseQuery(string(reqBody))
+		rec.Request.Form = form
+		route.Match = form.Get("sql")
+	} else {
+		rec.Request.Body = string(reqBody)
+		route.Match = string(reqBody)
+	}
+	if json.Valid(respBody) {
+		route.Body = respBody
+	} else {
+		route.Body, _ = json.Marshal(string(respBody))
+	}
+	if status == http.StatusOK {
+		route.Status = 0
+	}
+	rec.Responses = []Route{route}
+
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%04d-%s.json", time.Now().Format("20060102T150405"), r.seq.Add(1), endpointName(req.URL.Path))
+	return os.WriteFile(filepath.Join(r.dir, name), b, 0o640)
+}
+
+func endpointName(path string) string {
+	name := strings.Trim(strings.ReplaceAll(path, "/", "_"), "_")
+	if name == "" {
+		return "root"
+	}
+	return name
+}
+
+// RoundTripper serves the fixture instead of sending requests, unmatched
+// requests get a 404 response.
+func (f *Fixture) RoundTripper() http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var body []byte
+		if req.Body != nil {
+			b, err := io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			body = b
+		}
+		status := http.StatusNotFound
+		respBody := []byte(fmt.Sprintf(`{"OPT_STATUS":"NOT_FOUND","DESCRIPTION":"no recorded response for %s"}`, req.URL.Path))
+		if route, ok := f.Match(req.URL.Path, body, req.Header.Get("Content-Type")); ok {
+			status = http.StatusOK
+			if route.Status != 0 {
+				status = route.Status
+			}
+			respBody = route.Body
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": []string{"application/json"}},
+			Body:          io.NopCloser(bytes.NewReader(respBody)),
+			ContentLength: int64(len(respBody)),
+			Request:       req,
+		}, nil
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
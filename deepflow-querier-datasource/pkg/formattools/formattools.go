@@ -1,12 +1,10 @@
 package formattools
 
 import (
-	"deepflow-grafana-backend-plugin/pkg/newtypes"
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
 	"encoding/json"
 	"fmt"
-	"reflect"
 	"regexp"
-	"sort"
 )
 
 func getAutoGroupKeyPrefix(ss map[string]interface{}, role string) string {
@@ -115,45 +113,6 @@ func AddResourceFieldsInData(ss map[string]interface{}, role string) {
 
 }
 
-// 按照时间字段排序
-func FieldSort(slice []map[string]interface{}, fieldName string) (data []map[string]interface{}, err error) {
-	//
-	mapData := make(map[int64]map[string]interface{})
-	//
-	var keySplice []int64
-
-	for _, value := range slice {
-		tv := value[fieldName]
-		type_res := reflect.TypeOf(tv)
-		if type_res.Kind() == reflect.String {
-			tv, err := tv.(json.Number).Float64()
-			if err != nil {
-				return nil, fmt.Errorf(fmt.Sprintf("时间: columns: %v, value: %v 断言float64失败,类型%T", fieldName, tv, tv))
-			}
-			key := int64(tv)
-			mapData[key] = value
-			keySplice = append(keySplice, key)
-		} else {
-			return nil, fmt.Errorf(fmt.Sprintf("时间：columns: %v, value: %v 断言失败,类型%T", fieldName, tv, tv))
-		}
-
-	}
-
-	int64AsIntValues := make([]int, len(keySplice))
-
-	for i, val := range keySplice {
-		int64AsIntValues[i] = int(val)
-	}
-	sort.Ints(int64AsIntValues)
-
-	for _, v := range int64AsIntValues {
-		data = append(data, mapData[int64(v)])
-	}
-
-	return data, nil
-
-}
-
 // alias 替换
 func GetMetricFieldNameByAlias(alias string, item map[string]interface{}) string {
 	regexp1 := regexp.MustCompile(`\$\{.*?\}`)
@@ -187,29 +146,25 @@ func ValueToString(val interface{}) interface{} {
 	}
 }
 
-// tag翻译
-func TagTranslate(tag newtypes.ApiMetrics) (res map[interface{}]map[string]interface{}, err error) {
+// tag翻译，第一列为 tag 的值，第二列为显示名称
+func TagTranslate(tag *deepflowclient.QuerierResponse) (res map[interface{}]map[string]interface{}, err error) {
 	TagRes := make(map[interface{}]map[string]interface{})
 
-	if res, ok := tag.Result["values"]; !ok {
-		return TagRes, fmt.Errorf("接口返回数据格缺失字段: values")
-	} else {
-		//查询为空
-		if res == nil {
-			return TagRes, nil
-		}
+	values := tag.Values
+	//查询为空
+	if values.Len() == 0 {
+		return TagRes, nil
+	}
+	if len(values.Columns) < 2 {
+		return TagRes, fmt.Errorf("接口返回数据格缺失字段: display_name")
 	}
 
-	values := tag.Result["values"].([]interface{})
-
-	for _, t := range values {
-		v := t.([]interface{})
-		tagValue := v[0]
-		tagDisplayname := v[1]
+	for i := 0; i < values.Len(); i++ {
+		tagValue := values.Columns[0].Value(i)
 
 		TagRes[tagValue] = make(map[string]interface{})
 
-		TagRes[tagValue]["display_name"] = tagDisplayname
+		TagRes[tagValue]["display_name"] = values.Columns[1].Value(i)
 	}
 	return TagRes, nil
 }
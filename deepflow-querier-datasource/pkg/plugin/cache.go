@@ -0,0 +1,246 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
+	"deepflow-grafana-backend-plugin/pkg/querycache"
+)
+
+const (
+	defaultCacheTTL       = 60 * time.Second
+	defaultCacheMaxMemory = 64 // MB
+	defaultCacheAlignment = 300
+)
+
+// 单个查询的缓存控制，取值与 Cache-Control 一致
+//   - no-cache: 不读缓存，结果仍写入缓存
+//   - no-store: 不读也不写缓存
+type cacheControl struct {
+	noCache bool
+	noStore bool
+}
+
+type cacheControlKey struct{}
+
+func parseCacheControl(s string) cacheControl {
+	cc := cacheControl{}
+	for _, directive := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-cache":
+			cc.noCache = true
+		case "no-store":
+			cc.noCache = true
+			cc.noStore = true
+		}
+	}
+	return cc
+}
+
+func withCacheControl(ctx context.Context, cc cacheControl) context.Context {
+	return context.WithValue(ctx, cacheControlKey{}, cc)
+}
+
+func cacheControlFromContext(ctx context.Context) cacheControl {
+	cc, _ := ctx.Value(cacheControlKey{}).(cacheControl)
+	return cc
+}
+
+// queryCache 查询结果缓存，时间范围按 alignment 对齐
+type queryCache struct {
+	backend   querycache.Cache
+	ttl       time.Duration
+	alignment int64
+}
+
+func newQueryCache(s DatasourceSettings) (*queryCache, error) {
+	if s.DisableCache {
+		return nil, nil
+	}
+	ttl := time.Duration(s.CacheTTL) * time.Second
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	maxMemory := int64(s.CacheMaxMemory)
+	if maxMemory <= 0 {
+		maxMemory = defaultCacheMaxMemory
+	}
+	alignment := int64(s.CacheAlignment)
+	if alignment <= 0 {
+		alignment = defaultCacheAlignment
+	}
+	backend, err := querycache.New(s.CacheBackend, querycache.Options{TTL: ttl, MaxMemory: maxMemory << 20})
+	if err != nil {
+		return nil, err
+	}
+	return &queryCache{backend: backend, ttl: ttl, alignment: alignment}, nil
+}
+
+func (c *queryCache) get(key string) (*QuerierResponse, bool) {
+	b, ok := c.backend.Get(key)
+	if !ok {
+		return nil, false
+	}
+	body := &QuerierResponse{}
+	if err := json.Unmarshal(b, body); err != nil {
+		log.DefaultLogger.Warn("__________failed to decode cached querier result", "error", err.Error())
+		return nil, false
+	}
+	return body, true
+}
+
+func (c *queryCache) set(key string, body *QuerierResponse, ttl time.Duration) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		log.DefaultLogger.Warn("__________failed to encode querier result for cache", "error", err.Error())
+		return
+	}
+	c.backend.Set(key, b, ttl)
+}
+
+// 带缓存的querier接口查询，appType 为 profiling 时请求 profile 接口
+// 对按 time(time, N) 分组且无 LIMIT 的时序查询，历史部分按对齐后的时间范围缓存复用，只重新查询最近的边缘部分
+func (d *Datasource) querier(ctx context.Context, client *querierClient, appType string, req QuerierRequest) (res *QuerierResponse, err error) {
+	send := client.Query
+	if appType == "profiling" {
+		send = client.Profile
+	}
+	calls := querierCallsFromContext(ctx)
+	request := func(ctx context.Context, r QuerierRequest) (*QuerierResponse, error) {
+		start := time.Now()
+		res, err := send(ctx, r)
+		calls.request(r, time.Since(start))
+		return res, err
+	}
+	requestRange := func(from, to int64) (*QuerierResponse, error) {
+		r := req
+		r.From, r.To = from, to
+		return request(ctx, r)
+	}
+
+	cc := cacheControlFromContext(ctx)
+	if d.cache == nil || req.Debug || cc.noStore || req.Sql == "" {
+		return request(ctx, req)
+	}
+
+	cacheKey := func(from, to int64) string {
+		return querycache.Key(appType, client.QuerierURL(), client.token, req.Db, req.DataPrecision, req.ProfileEventType,
+			querycache.NormalizeSQL(req.Sql), strconv.FormatInt(from, 10), strconv.FormatInt(to, 10))
+	}
+	fetch := func(key string, from, to int64, ttl time.Duration) (*QuerierResponse, error) {
+		if !cc.noCache {
+			body, ok := d.cache.get(key)
+			observeCache(ok)
+			r := req
+			r.From, r.To = from, to
+			calls.lookup(r, ok)
+			if ok {
+				return body, nil
+			}
+		}
+		body, err := requestRange(from, to)
+		if err != nil {
+			return body, err
+		}
+		d.cache.set(key, body, ttl)
+		return body, nil
+	}
+
+	// 与时间无关的查询，如 show tag X values
+	if !strings.Contains(req.Sql, timeFromPlaceholder) && !strings.Contains(req.Sql, timeToPlaceholder) {
+		return fetch(cacheKey(0, 0), req.From, req.To, d.cache.ttl)
+	}
+
+	step := d.cache.alignment
+	interval, timeColumn, splittable := querycache.SplitInterval(req.Sql)
+	if !splittable || appType == "profiling" {
+		// 按请求的时间范围缓存，对齐后的范围与实际查询的范围不一致时结果不能复用
+		return fetch(cacheKey(req.From, req.To), req.From, req.To, d.cache.ttl)
+	}
+
+	// 对齐到 interval 的整数倍，保证边界落在桶的起点
+	if step%interval != 0 {
+		step = (step/interval + 1) * interval
+	}
+	from := querycache.Align(req.From, interval)
+	edge := querycache.Align(req.To, step)
+	if edge <= from {
+		return request(ctx, req)
+	}
+
+	// 历史部分从 step 对齐的起点查询，from 在同一个 step 内的请求共用缓存，之后去掉 from 之前的桶；
+	// 历史部分在 edge 移动前不会变化，至少缓存一个 step
+	historyFrom := querycache.Align(req.From, step)
+	historyTTL := d.cache.ttl
+	if stepTTL := time.Duration(step) * time.Second; stepTTL > historyTTL {
+		historyTTL = stepTTL
+	}
+	history, err := fetch(cacheKey(historyFrom, edge), historyFrom, edge-1, historyTTL)
+	if err != nil {
+		return history, err
+	}
+	history, ok := dropBucketsBefore(history, timeColumn, from)
+	if !ok {
+		log.DefaultLogger.Warn("__________cannot split querier result by time, column not found", "column", timeColumn)
+		return request(ctx, req)
+	}
+	recent, err := requestRange(edge, req.To)
+	if err != nil {
+		return recent, err
+	}
+	return mergeQuerierResults(history, recent)
+}
+
+// dropBucketsBefore 去掉时间桶早于 from 的行，时间列不存在或不是数字时返回 false
+func dropBucketsBefore(res *QuerierResponse, column string, from int64) (*QuerierResponse, bool) {
+	if res.Values.Len() == 0 {
+		return res, true
+	}
+	times := res.Values.Column(column)
+	if times == nil || !times.IsNumber() {
+		return res, false
+	}
+	filtered := *res
+	filtered.Values = res.Values.Filter(func(i int) bool {
+		t, ok := times.Float(i)
+		return ok && int64(t) >= from
+	})
+	return &filtered, true
+}
+
+// 合并按时间拆分的两次查询结果，两次结果的列不同时返回错误
+func mergeQuerierResults(history, recent *QuerierResponse) (*QuerierResponse, error) {
+	merged := *recent
+	if merged.Truncated == "" {
+		merged.Truncated = history.Truncated
+	}
+	merged.Bytes += history.Bytes
+	if merged.Columns == nil {
+		merged.Columns = history.Columns
+	}
+	if merged.Schemas == nil {
+		merged.Schemas = history.Schemas
+	}
+	if history.Values == nil {
+		return &merged, nil
+	}
+	if recent.Values == nil {
+		merged.Values = history.Values
+		return &merged, nil
+	}
+	// 历史部分可能来自缓存，拼接到新的 Values 中
+	values := deepflowclient.NewValues(merged.Columns)
+	for _, v := range []*deepflowclient.Values{history.Values, recent.Values} {
+		if err := values.Concat(v); err != nil {
+			return nil, err
+		}
+	}
+	merged.Values = values
+	return &merged, nil
+}
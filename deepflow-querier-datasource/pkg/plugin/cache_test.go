@@ -0,0 +1,217 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"deepflow-grafana-backend-plugin/pkg/querycache"
+)
+
+var sqlRangeRe = regexp.MustCompile(`time >= (\d+) AND time <= (\d+)`)
+
+// newBucketServer 按 sql 中的时间范围返回每个 60 秒时间桶一行，值为桶的起点
+func newBucketServer(t *testing.T) *recordingServer {
+	return newRecordingServer(t, func(w http.ResponseWriter, r recordedRequest) {
+		m := sqlRangeRe.FindStringSubmatch(r.form.Get("sql"))
+		if m == nil {
+			http.Error(w, "no time range", http.StatusBadRequest)
+			return
+		}
+		from, _ := strconv.ParseInt(m[1], 10, 64)
+		to, _ := strconv.ParseInt(m[2], 10, 64)
+		rows := []string{}
+		for bucket := querycache.Align(from, 60); bucket <= to; bucket += 60 {
+			rows = append(rows, fmt.Sprintf("[%d,%d]", bucket, bucket))
+		}
+		fmt.Fprintf(w, `{"OPT_STATUS":"SUCCESS","result":{"columns":["time_60","b"],"values":[%s]}}`, strings.Join(rows, ","))
+	})
+}
+
+func bucketsOf(t *testing.T, res *QuerierResponse) []int64 {
+	t.Helper()
+	column := res.Values.Column("time_60")
+	buckets := make([]int64, res.Values.Len())
+	for i := range buckets {
+		f, ok := column.Float(i)
+		if !ok {
+			t.Fatalf("row %d: time_60 = %v", i, column.Value(i))
+		}
+		buckets[i] = int64(f)
+	}
+	return buckets
+}
+
+func bucketRange(from, to int64) []int64 {
+	buckets := []int64{}
+	for b := querycache.Align(from, 60); b <= to; b += 60 {
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+func rangeSQL(sql string) string {
+	return sql + " WHERE time >= " + timeFromPlaceholder + " AND time <= " + timeToPlaceholder
+}
+
+// TestQuerierCacheKey 不能拆分的查询按请求的时间范围缓存，对齐后相同但实际范围不同的请求不共用结果
+func TestQuerierCacheKey(t *testing.T) {
+	server := newBucketServer(t)
+	d := newTestDatasource(t, map[string]interface{}{"requestUrl": server.URL, "cacheAlignment": 300})
+	client := d.newQuerierClient(server.URL, server.URL, "")
+	req := QuerierRequest{Db: "flow_metrics", Sql: rangeSQL("SELECT Sum(byte) AS `b` FROM network"), From: 1000, To: 2000}
+
+	for _, r := range []struct {
+		from     int64
+		requests int
+	}{{1000, 1}, {1000, 1}, {1010, 2}} {
+		req.From = r.from
+		if _, err := d.querier(context.Background(), client, "trafficQuery", req); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(server.recorded()); n != r.requests {
+			t.Errorf("from %d: server received %d requests, want %d", r.from, n, r.requests)
+		}
+	}
+}
+
+// TestQuerierCacheSplit 时序查询的历史部分从 cacheAlignment 对齐的起点查询并共用，结果与直接查询一致
+func TestQuerierCacheSplit(t *testing.T) {
+	server := newBucketServer(t)
+	d := newTestDatasource(t, map[string]interface{}{"requestUrl": server.URL, "cacheAlignment": 300})
+	client := d.newQuerierClient(server.URL, server.URL, "")
+	sql := rangeSQL("SELECT time(time, 60) AS `time_60`, Sum(byte) AS `b` FROM network") + " GROUP BY `time_60`"
+
+	cases := []struct {
+		from, to int64
+		// 每次查询后服务端收到的 sql 中的时间范围
+		requested []string
+	}{
+		{from: 1000, to: 2000, requested: []string{"900-1799", "1800-2000"}},
+		// 起点在同一个 300 秒窗口内，只查询最近的部分
+		{from: 1100, to: 2010, requested: []string{"1800-2010"}},
+		{from: 1199, to: 2099, requested: []string{"1800-2099"}},
+		// edge 移动后重新查询历史部分
+		{from: 1000, to: 2200, requested: []string{"900-2099", "2100-2200"}},
+		// 不足一个 cacheAlignment 时直接查询
+		{from: 2110, to: 2200, requested: []string{"2110-2200"}},
+	}
+	seen := 0
+	for _, c := range cases {
+		res, err := d.querier(context.Background(), client, "trafficQuery", QuerierRequest{Db: "flow_metrics", Sql: sql, From: c.from, To: c.to})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := bucketsOf(t, res), bucketRange(c.from, c.to); !reflect.DeepEqual(got, want) {
+			t.Errorf("%d-%d: buckets = %v, want %v", c.from, c.to, got, want)
+		}
+		recorded := server.recorded()
+		requested := []string{}
+		for _, r := range recorded[seen:] {
+			m := sqlRangeRe.FindStringSubmatch(r.form.Get("sql"))
+			requested = append(requested, m[1]+"-"+m[2])
+		}
+		seen = len(recorded)
+		if !reflect.DeepEqual(requested, c.requested) {
+			t.Errorf("%d-%d: requested %v, want %v", c.from, c.to, requested, c.requested)
+		}
+	}
+}
+
+// TestInspectorCacheSplit query inspector 展示实际查询的每个时间范围及缓存命中情况，延迟不包括读取缓存
+func TestInspectorCacheSplit(t *testing.T) {
+	server := newBucketServer(t)
+	d := newTestDatasource(t, map[string]interface{}{"requestUrl": server.URL, "cacheAlignment": 300})
+	client := d.newQuerierClient(server.URL, server.URL, "")
+	sql := rangeSQL("SELECT time(time, 60) AS `time_60`, Sum(byte) AS `b` FROM network") + " GROUP BY `time_60`"
+	req := QuerierRequest{Db: "flow_metrics", Sql: sql, From: 1000, To: 2000}
+
+	stat := func(meta *data.FrameMeta, name string) float64 {
+		for _, s := range meta.Stats {
+			if s.DisplayName == name {
+				return s.Value
+			}
+		}
+		t.Fatalf("no %s stat", name)
+		return 0
+	}
+	_, inspector, err := d.inspectedQuerier(context.Background(), client, "trafficQuery", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := inspector.meta()
+	want := "-- db: flow_metrics, data precision: default\n" + strings.ReplaceAll(sql, timeFromPlaceholder+" AND time <= "+timeToPlaceholder, "900 AND time <= 1799") +
+		"\n\n-- db: flow_metrics, data precision: default\n" + strings.ReplaceAll(sql, timeFromPlaceholder+" AND time <= "+timeToPlaceholder, "1800 AND time <= 2000")
+	if meta.ExecutedQueryString != want {
+		t.Errorf("ExecutedQueryString = %q, want %q", meta.ExecutedQueryString, want)
+	}
+	if hits, misses := stat(meta, "Cache hits"), stat(meta, "Cache misses"); hits != 0 || misses != 1 {
+		t.Errorf("cache hits = %v, misses = %v, want 0 and 1", hits, misses)
+	}
+
+	_, inspector, err = d.inspectedQuerier(context.Background(), client, "trafficQuery", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta = inspector.meta()
+	if !strings.HasPrefix(meta.ExecutedQueryString, "-- db: flow_metrics, data precision: default, from cache\n") {
+		t.Errorf("ExecutedQueryString = %q, want the history from cache", meta.ExecutedQueryString)
+	}
+	if hits, misses := stat(meta, "Cache hits"), stat(meta, "Cache misses"); hits != 1 || misses != 0 {
+		t.Errorf("cache hits = %v, misses = %v, want 1 and 0", hits, misses)
+	}
+	if len(inspector.calls.calls) != 2 || inspector.calls.calls[1].cached {
+		t.Errorf("calls = %+v, want the history from cache and the recent part requested", inspector.calls.calls)
+	}
+}
+
+func TestMergeQuerierResults(t *testing.T) {
+	decode := func(s string) *QuerierResponse {
+		res := &QuerierResponse{}
+		if err := json.Unmarshal([]byte(s), res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	history := decode(`{"OptStatus":"SUCCESS","Columns":["t","v"],"Values":[[60,1],[120,null]],"Truncated":"rows","Bytes":10}`)
+	recent := decode(`{"OptStatus":"SUCCESS","Columns":["t","v"],"Values":[[180,2.5]],"Bytes":5}`)
+	empty := decode(`{"OptStatus":"SUCCESS","Columns":["t","v"],"Values":null,"Bytes":1}`)
+
+	merged, err := mergeQuerierResults(history, recent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Truncated != "rows" || merged.Bytes != 15 || merged.Values.Len() != 3 {
+		t.Errorf("merged = %+v, want 3 rows truncated by rows, 15 bytes", merged)
+	}
+	v := merged.Values.Column("v")
+	if !v.IsNull(1) || v.Text(0) != "1" || v.Text(2) != "2.5" {
+		t.Errorf("v = %q %q %q", v.Text(0), v.Text(1), v.Text(2))
+	}
+	// 合并不修改缓存中解码的历史部分
+	if history.Values.Len() != 2 {
+		t.Errorf("history has %d rows after the merge", history.Values.Len())
+	}
+
+	if merged, _ := mergeQuerierResults(history, empty); merged.Values.Len() != 2 || merged.Truncated != "rows" {
+		t.Errorf("history + null = %d rows, truncated %q", merged.Values.Len(), merged.Truncated)
+	}
+	if merged, _ := mergeQuerierResults(empty, recent); merged.Values.Len() != 1 {
+		t.Errorf("null + recent = %d rows", merged.Values.Len())
+	}
+	if merged, _ := mergeQuerierResults(empty, empty); merged.Values != nil {
+		t.Errorf("null + null = %v, want null values", merged.Values)
+	}
+	other := decode(`{"Columns":["t"],"Values":[[240]]}`)
+	if _, err := mergeQuerierResults(history, other); err == nil {
+		t.Error("results with different columns are merged")
+	}
+}
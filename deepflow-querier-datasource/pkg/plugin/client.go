@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
+)
+
+type (
+	QuerierRequest  = deepflowclient.QuerierRequest
+	QuerierResponse = deepflowclient.QuerierResponse
+	TraceRequest    = deepflowclient.TraceRequest
+)
+
+const (
+	timeFromPlaceholder = deepflowclient.TimeFromMacro
+	timeToPlaceholder   = deepflowclient.TimeToMacro
+)
+
+// querierClient 请求 deepflow-server 的querier、profile 及tracing接口，token 与地址在创建时确定
+type querierClient struct {
+	*deepflowclient.Client
+	token string
+	logs  *logPolicy
+}
+
+func (d *Datasource) newQuerierClient(requestUrl, traceUrl, token string) *querierClient {
+	return &querierClient{
+		Client: deepflowclient.New(requestUrl,
+			deepflowclient.WithHTTPClient(d.httpClient),
+			deepflowclient.WithTraceURL(traceUrl),
+			deepflowclient.WithToken(token),
+			deepflowclient.WithLimits(d.limits.maxRows, d.limits.maxBytes),
+		),
+		token: token,
+		logs:  d.logs,
+	}
+}
+
+// Query 请求 /v1/query/
+func (c *querierClient) Query(ctx context.Context, req QuerierRequest) (*QuerierResponse, error) {
+	//请求querier接口
+	c.logRequest("__________request querier interface", req)
+	defer observeQuerierTime(ctx, time.Now())
+	ctx, span := c.startQuerierSpan(ctx, "deepflow.querier", req)
+	res, err := c.Client.Query(withIdempotent(ctx), req)
+	endQuerierSpan(span, res, err)
+	logTruncated(res, req)
+	return res, err
+}
+
+// Profile 请求 /v1/profile/ProfileGrafana
+func (c *querierClient) Profile(ctx context.Context, req QuerierRequest) (*QuerierResponse, error) {
+	c.logRequest("__________request profile interface", req)
+	defer observeQuerierTime(ctx, time.Now())
+	ctx, span := c.startQuerierSpan(ctx, "deepflow.profile", req)
+	res, err := c.Client.Profile(withIdempotent(ctx), req)
+	endQuerierSpan(span, res, err)
+	logTruncated(res, req)
+	return res, err
+}
+
+// Trace 请求 /v1/stats/querier/L7FlowTracing
+func (c *querierClient) Trace(ctx context.Context, req TraceRequest) (*deepflowclient.TraceResponse, error) {
+	//请求tracing接口
+	log.DefaultLogger.Debug("__________request tracing interface", "_id", req.Id, "time_start", req.From, "time_end", req.To)
+	defer observeQuerierTime(ctx, time.Now())
+	ctx, span := startSpan(ctx, "deepflow.trace", attribute.String("_id", req.Id),
+		attribute.Int64("time_start", req.From), attribute.Int64("time_end", req.To))
+	res, err := c.Client.Trace(withIdempotent(ctx), req)
+	endSpan(span, err)
+	return res, err
+}
+
+// startQuerierSpan span 的 sql 属性与日志一样脱敏并截断
+func (c *querierClient) startQuerierSpan(ctx context.Context, name string, req QuerierRequest) (context.Context, trace.Span) {
+	return startSpan(ctx, name,
+		attribute.String("db", req.Db),
+		attribute.String("sql", c.logs.sql(req.Sql)),
+		attribute.Int64("time_start", req.From),
+		attribute.Int64("time_end", req.To))
+}
+
+func endQuerierSpan(span trace.Span, res *deepflowclient.QuerierResponse, err error) {
+	if res != nil {
+		span.SetAttributes(attribute.Int("rows", res.Values.Len()))
+		if res.Truncated != "" {
+			span.SetAttributes(attribute.String("truncated", res.Truncated))
+		}
+	}
+	endSpan(span, err)
+}
+
+// logRequest 请求的 sql 按日志策略脱敏并截断，Debug 级别输出
+func (c *querierClient) logRequest(msg string, req QuerierRequest) {
+	if !debugEnabled() {
+		return
+	}
+	log.DefaultLogger.Debug(msg, "db", req.Db, "data_precision", req.DataPrecision,
+		"time_start", req.From, "time_end", req.To, "sql", c.logs.sql(req.Sql))
+}
+
+// observeQuerierTime 累计等待 deepflow-server 的时间，包括读取及解析响应
+func observeQuerierTime(ctx context.Context, start time.Time) {
+	queryStatsFromContext(ctx).addQuerierTime(time.Since(start))
+}
+
+// logTruncated 返回超过行数或字节数上限被截断时输出警告
+func logTruncated(res *QuerierResponse, req QuerierRequest) {
+	if res != nil && res.Truncated != "" {
+		log.DefaultLogger.Warn("__________querier result truncated", "reason", res.Truncated, "db", req.Db)
+	}
+}
@@ -0,0 +1,344 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	defaultClusterName = "default"
+	// 合并结果中标识集群的列名及 label 名
+	clusterColumn = "cluster"
+)
+
+// ClusterSettings 数据源配置中的其他 DeepFlow 集群，TraceUrl、Token 为空时使用数据源的配置
+type ClusterSettings struct {
+	Name       string `json:"name"`
+	RequestUrl string `json:"requestUrl"`
+	TraceUrl   string `json:"traceUrl"`
+	Token      string `json:"token"`
+}
+
+// cluster 一个 deepflow-server 的querier及tracing地址
+type cluster struct {
+	name       string
+	requestUrl string
+	traceUrl   string
+	token      string
+}
+
+// newClusters 数据源自身的 requestUrl/traceUrl 为第一个集群，其后为 clusters 中配置的集群
+func newClusters(s DatasourceSettings) ([]cluster, error) {
+	first := cluster{name: s.ClusterName, requestUrl: s.RequestUrl, traceUrl: s.TraceUrl, token: s.Token}
+	if first.name == "" {
+		first.name = defaultClusterName
+	}
+	clusters := []cluster{first}
+	names := map[string]bool{first.name: true}
+	for i, c := range s.Clusters {
+		if c.Name == "" || c.RequestUrl == "" {
+			return nil, fmt.Errorf("clusters[%d]: name and requestUrl are required", i)
+		}
+		if c.Name == "*" || strings.Contains(c.Name, ",") {
+			return nil, fmt.Errorf("clusters[%d]: invalid name %q", i, c.Name)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("clusters[%d]: duplicate name %q", i, c.Name)
+		}
+		names[c.Name] = true
+		next := cluster{name: c.Name, requestUrl: c.RequestUrl, traceUrl: c.TraceUrl, token: c.Token}
+		if next.traceUrl == "" {
+			next.traceUrl = s.TraceUrl
+		}
+		if next.token == "" {
+			next.token = s.Token
+		}
+		clusters = append(clusters, next)
+	}
+	return clusters, nil
+}
+
+// clusterNames queryText 中的 clusters，可以是名称数组或逗号分隔的字符串
+func clusterNames(v interface{}) []string {
+	var names []string
+	switch v := v.(type) {
+	case string:
+		names = strings.Split(v, ",")
+	case []interface{}:
+		for _, n := range v {
+			if s, ok := n.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+	result := make([]string, 0, len(names))
+	for _, n := range names {
+		if n = strings.TrimSpace(n); n != "" {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// clusterQuery 查询的集群，targets 为 nil 时只查询默认集群且结果不做处理
+type clusterQuery struct {
+	targets  []cluster
+	appType  string
+	formatAs string
+}
+
+// targetClusters 根据 queryText 中的 clusters 确定查询的集群，"*" 表示所有集群
+// 未指定 clusters 时 appTracingFlame 在所有集群中查找调用链
+func (d *Datasource) targetClusters(queryJSON json.RawMessage) (clusterQuery, error) {
+	var qj struct {
+		QueryText string `json:"queryText"`
+	}
+	var queryText struct {
+		AppType  string      `json:"appType"`
+		FormatAs string      `json:"formatAs"`
+		Clusters interface{} `json:"clusters"`
+	}
+	// 解析失败时由 queryCluster 返回具体的错误
+	if json.Unmarshal(queryJSON, &qj) != nil || json.Unmarshal([]byte(qj.QueryText), &queryText) != nil {
+		return clusterQuery{}, nil
+	}
+	q := clusterQuery{appType: queryText.AppType, formatAs: queryText.FormatAs}
+	names := clusterNames(queryText.Clusters)
+	if len(names) == 0 {
+		if q.appType == "appTracingFlame" && len(d.clusters) > 1 {
+			q.targets = d.clusters
+		}
+		return q, nil
+	}
+
+	byName := make(map[string]cluster, len(d.clusters))
+	for _, c := range d.clusters {
+		byName[c.name] = c
+	}
+	seen := map[string]bool{}
+	for _, n := range names {
+		if n == "*" {
+			q.targets = d.clusters
+			break
+		}
+		c, ok := byName[n]
+		if !ok {
+			return q, fmt.Errorf("unknown cluster %q", n)
+		}
+		if !seen[n] {
+			seen[n] = true
+			q.targets = append(q.targets, c)
+		}
+	}
+	if q.appType == "profiling" && len(q.targets) > 1 {
+		return q, fmt.Errorf("profiling queries can only target one cluster")
+	}
+	return q, nil
+}
+
+type clusterResult struct {
+	cluster  cluster
+	response backend.DataResponse
+	err      error
+}
+
+// queryClusters 并发查询多个集群，部分集群失败时在结果中给出提示，全部失败时返回错误
+func (d *Datasource) queryClusters(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, q clusterQuery) (backend.DataResponse, error) {
+	results := make([]clusterResult, len(q.targets))
+	var wg sync.WaitGroup
+	for i, c := range q.targets {
+		wg.Add(1)
+		go func(i int, c cluster) {
+			defer wg.Done()
+			// 协程中的 panic 无法被 QueryData 恢复，转换为该集群的错误
+			defer func() {
+				if r := recover(); r != nil {
+					log.DefaultLogger.Error("__________Recover from cluster query", "cluster", c.name, "error", r, "stack", string(debug.Stack()))
+					results[i] = clusterResult{cluster: c, err: fmt.Errorf("internal error: %v", r)}
+				}
+			}()
+			res, err := d.queryCluster(ctx, pCtx, query, c)
+			results[i] = clusterResult{cluster: c, response: res, err: err}
+		}(i, c)
+	}
+	wg.Wait()
+
+	succeeded := make([]clusterResult, 0, len(results))
+	failures := make([]string, 0, len(results))
+	for _, r := range results {
+		if r.err != nil {
+			log.DefaultLogger.Warn("__________cluster query failed", append([]interface{}{"cluster", r.cluster.name}, d.logs.errorFields(r.err)...)...)
+			failures = append(failures, fmt.Sprintf("cluster %s: %s", r.cluster.name, r.err.Error()))
+			continue
+		}
+		succeeded = append(succeeded, r)
+	}
+
+	// 调用链只存在于一个集群中，按配置顺序取第一个有数据的结果
+	if q.appType == "appTracingFlame" {
+		for _, r := range succeeded {
+			if len(r.response.Frames) > 0 {
+				return r.response, nil
+			}
+		}
+		if len(failures) > 0 {
+			return backend.DataResponse{}, fmt.Errorf("%s", strings.Join(failures, "; "))
+		}
+		return backend.DataResponse{}, nil
+	}
+	if len(succeeded) == 0 {
+		return backend.DataResponse{}, fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	if q.appType == "profiling" {
+		return succeeded[0].response, nil
+	}
+
+	frames := mergeClusterFrames(succeeded, q.formatAs)
+	if len(failures) > 0 {
+		notices := make([]data.Notice, len(failures))
+		for i, f := range failures {
+			notices[i] = data.Notice{Severity: data.NoticeSeverityWarning, Text: f}
+		}
+		if len(frames) == 0 {
+			frames = data.Frames{data.NewFrame("response")}
+		}
+		// 同一集群的 frame 共用 Meta，复制后再添加提示
+		meta := data.FrameMeta{}
+		if frames[0].Meta != nil {
+			meta = *frames[0].Meta
+		}
+		meta.Notices = append(append([]data.Notice(nil), meta.Notices...), notices...)
+		frames[0].Meta = &meta
+	}
+	return backend.DataResponse{Frames: frames}, nil
+}
+
+// mergeClusterFrames 表格加 cluster 列后拼接，日志在 labels 中加 cluster 后拼接，
+// 时序、热力图及直方图在数值字段的 labels 中加 cluster，每个集群的 frame 单独返回
+func mergeClusterFrames(results []clusterResult, formatAs string) data.Frames {
+	frames := data.Frames{}
+	names := []string{}
+	var empty *data.Frame
+	for _, r := range results {
+		for _, frame := range r.response.Frames {
+			// 没有数据的集群只返回一个没有字段的 frame，不参与合并
+			if len(frame.Fields) == 0 {
+				if empty == nil {
+					empty = frame
+				}
+				continue
+			}
+			switch {
+			case formatAs == "table" || formatAs == "":
+				addClusterColumn(frame, r.cluster.name)
+			case formatAs == "logs" && frame.Meta != nil && frame.Meta.Type == data.FrameTypeLogLines:
+				addClusterLogLabel(frame, r.cluster.name)
+			default:
+				addClusterLabel(frame, r.cluster.name)
+			}
+			frames = append(frames, frame)
+			names = append(names, r.cluster.name)
+		}
+	}
+	if len(frames) == 0 && empty != nil {
+		return data.Frames{empty}
+	}
+	if formatAs == "table" || formatAs == "" || formatAs == "logs" {
+		if merged := concatFrames(frames, names); merged != nil {
+			return data.Frames{merged}
+		}
+	}
+	return frames
+}
+
+// addClusterColumn 在第一列加入集群名称
+func addClusterColumn(frame *data.Frame, name string) {
+	values := make([]string, frame.Rows())
+	for i := range values {
+		values[i] = name
+	}
+	frame.Fields = append([]*data.Field{data.NewField(clusterColumn, nil, values)}, frame.Fields...)
+}
+
+// addClusterLogLabel 日志 frame 的 labels 中加入集群名称
+func addClusterLogLabel(frame *data.Frame, name string) {
+	field, _ := frame.FieldByName("labels")
+	if field == nil {
+		return
+	}
+	for i := 0; i < field.Len(); i++ {
+		raw, ok := field.At(i).(json.RawMessage)
+		if !ok {
+			continue
+		}
+		labels := map[string]string{}
+		if json.Unmarshal(raw, &labels) != nil {
+			continue
+		}
+		labels[clusterColumn] = name
+		b, _ := json.Marshal(labels)
+		field.Set(i, json.RawMessage(b))
+	}
+}
+
+// addClusterLabel 数值字段的 labels 中加入集群名称，使不同集群的同名序列可以区分
+func addClusterLabel(frame *data.Frame, name string) {
+	for _, field := range frame.Fields {
+		if !field.Type().Numeric() {
+			continue
+		}
+		if field.Labels == nil {
+			field.Labels = data.Labels{}
+		}
+		field.Labels[clusterColumn] = name
+	}
+}
+
+// concatFrames 每个集群最多一个 frame 且字段相同时拼接为一个 frame，各集群的提示加上集群名称后合并
+func concatFrames(frames data.Frames, names []string) *data.Frame {
+	if len(frames) == 0 {
+		return nil
+	}
+	first := frames[0]
+	for i, frame := range frames[1:] {
+		if names[i+1] == names[i] || len(frame.Fields) != len(first.Fields) {
+			return nil
+		}
+		for j, field := range frame.Fields {
+			if field.Name != first.Fields[j].Name || field.Type() != first.Fields[j].Type() {
+				return nil
+			}
+		}
+	}
+	merged := copyFrameSchema(first)
+	var notices []data.Notice
+	for i, frame := range frames {
+		for row := 0; row < frame.Rows(); row++ {
+			vals := make([]interface{}, len(frame.Fields))
+			for j, field := range frame.Fields {
+				vals[j] = field.CopyAt(row)
+			}
+			merged.AppendRow(vals...)
+		}
+		if frame.Meta != nil {
+			for _, n := range frame.Meta.Notices {
+				n.Text = fmt.Sprintf("cluster %s: %s", names[i], n.Text)
+				notices = append(notices, n)
+			}
+		}
+	}
+	if merged.Meta != nil {
+		meta := *merged.Meta
+		meta.Notices = notices
+		merged.Meta = &meta
+	}
+	return merged
+}
@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func queryGoldenCase(t *testing.T, d *Datasource, c goldenCase) backend.DataResponse {
+	t.Helper()
+	queryText, _ := json.Marshal(c.QueryText)
+	c.Query["queryText"] = string(queryText)
+	queryJSON, _ := json.Marshal(c.Query)
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			RefID:     "A",
+			JSON:      queryJSON,
+			TimeRange: backend.TimeRange{From: time.Unix(c.From, 0), To: time.Unix(c.To, 0)},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Responses["A"]
+}
+
+// TestClusterFanOut 表格结果按集群拼接并加入 cluster 列，失败的集群只给出提示
+func TestClusterFanOut(t *testing.T) {
+	c := loadGoldenCase(t, "testdata/cases/trafficQuery_table.json")
+	failing := loadGoldenCase(t, "testdata/cases/trafficQuery_querierError.json")
+	a := newFakeDeepflowServer(t, c.Responses)
+	b := newFakeDeepflowServer(t, c.Responses)
+	broken := newFakeDeepflowServer(t, failing.Responses)
+	d := newTestDatasource(t, map[string]interface{}{
+		"requestUrl":       a.URL,
+		"traceUrl":         a.URL,
+		"disableCache":     true,
+		"retryMaxAttempts": 1,
+		"clusterName":      "a",
+		"clusters": []map[string]interface{}{
+			{"name": "b", "requestUrl": b.URL},
+			{"name": "broken", "requestUrl": broken.URL},
+		},
+	})
+	c.QueryText["clusters"] = []interface{}{"*"}
+
+	res := queryGoldenCase(t, d, c)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if len(res.Frames) != 1 {
+		t.Fatalf("got %d frames, want 1", len(res.Frames))
+	}
+	frame := res.Frames[0]
+	field, _ := frame.FieldByName(clusterColumn)
+	if field == nil {
+		t.Fatal("missing cluster column")
+	}
+	half := frame.Rows() / 2
+	if half == 0 || field.At(0) != "a" || field.At(half) != "b" {
+		t.Errorf("cluster column = %v, want rows of a followed by rows of b", field)
+	}
+	notices := frame.Meta.Notices
+	if len(notices) != 1 || notices[0].Severity != data.NoticeSeverityWarning || !strings.HasPrefix(notices[0].Text, "cluster broken: ") {
+		t.Errorf("notices = %+v, want a warning for cluster broken", notices)
+	}
+
+	// 只查询默认集群时结果不变
+	delete(c.QueryText, "clusters")
+	res = queryGoldenCase(t, d, c)
+	if field, _ := res.Frames[0].FieldByName(clusterColumn); field != nil {
+		t.Error("unexpected cluster column for the default cluster")
+	}
+
+	c.QueryText["clusters"] = "broken"
+	if res = queryGoldenCase(t, d, c); res.Error == nil || !strings.HasPrefix(res.Error.Error(), "cluster broken: ") {
+		t.Errorf("error = %v, want the error of cluster broken", res.Error)
+	}
+
+	c.QueryText["clusters"] = "b, missing"
+	if res = queryGoldenCase(t, d, c); res.Error == nil || res.Error.Error() != `unknown cluster "missing"` {
+		t.Errorf("error = %v, want unknown cluster", res.Error)
+	}
+}
+
+// TestClusterTraceLookup 调用链在所有集群中查找
+func TestClusterTraceLookup(t *testing.T) {
+	notFound := loadGoldenCase(t, "testdata/cases/appTracingFlame_notFound.json")
+	found := loadGoldenCase(t, "testdata/cases/appTracingFlame.json")
+	a := newFakeDeepflowServer(t, notFound.Responses)
+	b := newFakeDeepflowServer(t, found.Responses)
+	d := newTestDatasource(t, map[string]interface{}{
+		"requestUrl":   a.URL,
+		"traceUrl":     a.URL,
+		"disableCache": true,
+		"clusters":     []map[string]interface{}{{"name": "b", "requestUrl": b.URL, "traceUrl": b.URL}},
+	})
+
+	res := queryGoldenCase(t, d, found)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if len(res.Frames) != 1 || res.Frames[0].Rows() != 1 {
+		t.Fatalf("got %d frames, want the trace of cluster b", len(res.Frames))
+	}
+}
@@ -1,14 +1,12 @@
 package plugin
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
@@ -17,9 +15,11 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
 	"deepflow-grafana-backend-plugin/pkg/formattools"
-	"deepflow-grafana-backend-plugin/pkg/newtypes"
 )
 
 // Make sure Datasource implements required interfaces. This is important to do
@@ -37,21 +37,65 @@ var (
 
 // NewDatasource creates a new datasource instance.
 func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+	// TLS 客户端认证、自定义 CA 及跳过校验由 SDK 从 jsonData/secureJsonData 中解析
 	opts, err := settings.HTTPClientOptions(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("http client options error : %w", err)
 	}
-	httpclient.DefaultTimeoutOptions.Timeout = 300 * time.Second
-	opts.Timeouts = &httpclient.DefaultTimeoutOptions
+
+	dsSettings, err := loadSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	// 超时为每个数据源独立配置，不能修改 SDK 的全局默认值
+	opts.Timeouts = newTimeoutOptions(dsSettings)
+
+	// 重试与熔断，指标记录每次重试，SDK 默认的 tracing 中间件之外补充 W3C traceparent
+	if len(opts.Middlewares) == 0 {
+		opts.Middlewares = httpclient.DefaultMiddlewares()
+	}
+	opts.Middlewares = append(opts.Middlewares, retryMiddleware(newRetryOptions(dsSettings)), metricsMiddleware(),
+		traceContextMiddleware())
+	recordReplay, err := recordReplayMiddlewares(dsSettings)
+	if err != nil {
+		return nil, err
+	}
+	opts.Middlewares = append(opts.Middlewares, recordReplay...)
 
 	cl, err := httpclient.New(opts)
 	if err != nil {
 		return nil, fmt.Errorf("httpclient new error: %w", err)
 	}
+	logsFormat, err := newLogsFormat(dsSettings)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := newClusters(dsSettings)
+	if err != nil {
+		return nil, err
+	}
+	guardrails, err := newGuardrails(dsSettings)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := newQueryCache(dsSettings)
+	if err != nil {
+		return nil, fmt.Errorf("query cache error: %w", err)
+	}
 	return &Datasource{
 		CallResourceHandler: newResourceHandler(),
 		settings:            settings,
 		httpClient:          cl,
+		cache:               cache,
+		limits:              newResultLimits(dsSettings),
+		logs:                newLogPolicy(dsSettings),
+		links:               newTracingLinks(dsSettings),
+		logsFormat:          logsFormat,
+		enums:               newEnumTranslator(dsSettings),
+		clusters:            clusters,
+		guardrails:          guardrails,
+		throttle:            newQueryThrottle(dsSettings),
 	}, nil
 }
 
@@ -63,6 +107,33 @@ type Datasource struct {
 	settings backend.DataSourceInstanceSettings
 
 	httpClient *http.Client
+
+	// 查询结果缓存，禁用时为 nil
+	cache *queryCache
+
+	// 返回数据上限
+	limits resultLimits
+
+	// 日志脱敏、截断及采样
+	logs *logPolicy
+
+	// appTracing 结果跳转火焰图的链接
+	links tracingLinks
+
+	// formatAs 为 logs 时的日志 frame 配置
+	logsFormat logsFormat
+
+	// 枚举 tag 翻译
+	enums *enumTranslator
+
+	// 查询的 deepflow-server，第一个为数据源自身的 requestUrl/traceUrl
+	clusters []cluster
+
+	// 查询保护
+	guardrails guardrails
+
+	// 查询速率及并发上限
+	throttle *queryThrottle
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
@@ -88,24 +159,43 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	}()
 
 	// 记录日志
-	// 所有查询请求数据
-	log.DefaultLogger.Info("__________all submitted queries", "data", req)
+	// 所有查询请求数据，req 中包含 token 及转发的请求头，只输出脱敏后的查询
+	refIds := make([]string, len(req.Queries))
+	for i, q := range req.Queries {
+		refIds[i] = q.RefID
+	}
+	log.DefaultLogger.Info("__________all submitted queries", "count", len(req.Queries), "refIds", refIds)
+	if debugEnabled() {
+		log.DefaultLogger.Debug("__________all submitted queries", "headers", d.logs.redactHeaders(req.Headers))
+	}
+
+	ctx, span := startSpan(ctx, "deepflow.QueryData", attribute.Int("queries", len(req.Queries)))
+	defer span.End()
 
 	// create response struct
 	response := backend.NewQueryDataResponse()
 
 	// loop over queries and execute them individually.
 	for _, q := range req.Queries {
-		res, err := d.query(ctx, req.PluginContext, q)
+		queryCtx, stats := withQueryStats(ctx)
+		queryCtx, querySpan := startSpan(queryCtx, "deepflow.query", attribute.String("ref_id", q.RefID))
+		start := time.Now()
+		res, err := d.throttledQuery(queryCtx, req.PluginContext, q)
+		observeQuery(stats, time.Since(start), res, err)
+		endQuerySpan(querySpan, res, err)
 		if err != nil {
 			// return nil, fmt.Errorf("查询错误: %w", err)
 			// 子查询错误
-			log.DefaultLogger.Error("__________subquery error: " + err.Error())
-
-			response.Responses[q.RefID] = backend.ErrDataResponse(
-				backend.StatusBadRequest,
-				err.Error(),
-			)
+			log.DefaultLogger.Error("__________subquery error", d.logs.errorFields(err)...)
+
+			status := backend.StatusBadRequest
+			switch {
+			case errors.Is(err, ErrTooManyQueries):
+				status = backend.StatusTooManyRequests
+			case errors.Is(err, context.DeadlineExceeded):
+				status = backend.StatusTimeout
+			}
+			response.Responses[q.RefID] = backend.ErrDataResponse(status, err.Error())
 		} else {
 			// save the response in a hashmap
 			// based on with RefID as identifier
@@ -118,8 +208,26 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 }
 
 func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) (backend.DataResponse, error) {
+	if err := d.guardrails.check(query.JSON, query.TimeRange); err != nil {
+		return backend.DataResponse{}, err
+	}
+	q, err := d.targetClusters(query.JSON)
+	if err != nil {
+		return backend.DataResponse{}, err
+	}
+	if q.targets == nil {
+		return d.queryCluster(ctx, pCtx, query, d.clusters[0])
+	}
+	return d.queryClusters(ctx, pCtx, query, q)
+}
+
+// queryCluster 查询一个集群
+func (d *Datasource) queryCluster(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, target cluster) (backend.DataResponse, error) {
 	// 子查询
-	log.DefaultLogger.Info("__________subquery", "data", query)
+	if d.logs.sampled() {
+		log.DefaultLogger.Info("__________subquery", "refId", query.RefID, "cluster", target.name, "from", query.TimeRange.From, "to", query.TimeRange.To,
+			"maxDataPoints", query.MaxDataPoints, "data", d.logs.payload(query.JSON))
+	}
 
 	response := backend.DataResponse{}
 
@@ -131,7 +239,7 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 
 	if response.Error != nil {
 		// settings.JSONData解码失败
-		log.DefaultLogger.Error("__________settings.JSONData decoding failed", "error", response.Error, "data", string(d.settings.JSONData))
+		log.DefaultLogger.Error("__________settings.JSONData decoding failed", "error", response.Error, "data", d.logs.payload(d.settings.JSONData))
 		return response, fmt.Errorf("settings.JSONData decoding failed: %w", response.Error)
 	}
 
@@ -150,7 +258,7 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 
 	if response.Error != nil {
 		// query.JSON解码失败
-		log.DefaultLogger.Error("__________query.JSON decoding failed", "error", response.Error, "data", string(query.JSON))
+		log.DefaultLogger.Error("__________query.JSON decoding failed", "error", response.Error, "data", d.logs.payload(query.JSON))
 		return response, fmt.Errorf("query.JSON decoding failed: %w", response.Error)
 	}
 
@@ -172,17 +280,6 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		return response, err
 	}
 
-	// 从数据源配置中获取
-	//requestUrl
-	requestUrl := dsj["requestUrl"].(string)
-	//traceUrl
-	traceUrl := dsj["traceUrl"].(string)
-	//token
-	token := ""
-	if _, ok := dsj["token"]; ok {
-		token = dsj["token"].(string)
-	}
-
 	// 从qj获取
 	// 是否 panel 发起
 	var isQuery bool
@@ -213,10 +310,12 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 
 	//app类型
 	appType := queryText["appType"].(string)
+	queryStatsFromContext(ctx).setAppType(appType)
 	// 获取db
 	db := queryText["db"].(string)
 	// 获取sources
 	sources := queryText["sources"].(string)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("app_type", appType), attribute.String("db", db))
 
 	//开启debug
 	debug := false
@@ -224,30 +323,31 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		debug = qj["debug"].(bool)
 	}
 
+	// querier 客户端
+	client := d.newQuerierClient(target.requestUrl, target.traceUrl, target.token)
+
+	// 缓存控制
+	if v, ok := queryText["cacheControl"].(string); ok {
+		ctx = withCacheControl(ctx, parseCacheControl(v))
+	}
+
 	if appType == "profiling" {
 		// 请求数据
-		tracingsqlRes, err := d.querier(ctx, appType, debug, "", sql, "", token, profile_event_type, requestUrl, fromTimeInt64, toTimeInt64)
+		tracingsqlRes, inspector, err := d.inspectedQuerier(ctx, client, appType, QuerierRequest{
+			Sql:              sql,
+			ProfileEventType: profile_event_type,
+			Debug:            debug,
+			From:             fromTimeInt64,
+			To:               toTimeInt64,
+		})
 
 		if err != nil {
 			return response, err
 		}
+		frameSpan := startFrameSpan(ctx, appType, querierRows(tracingsqlRes))
+		defer frameSpan.End()
 
-		// 获取列
-		var columns []interface{}
-
-		if _, ok := tracingsqlRes.Result["columns"]; !ok {
-			// 缺失columns
-			return response, fmt.Errorf("the columns field is missing in the returned data grid")
-		}
-		columns = tracingsqlRes.Result["columns"].([]interface{})
-
-		// 获取值
-		if _, ok := tracingsqlRes.Result["values"]; !ok {
-			//缺失values
-			return response, fmt.Errorf("the values field is missing in the returned data grid")
-		}
-
-		if tracingsqlRes.Result["values"] == nil {
+		if tracingsqlRes.Values == nil {
 			// return response, fmt.Errorf("the values is null")
 			//数据
 			frame := data.NewFrame("response")
@@ -275,68 +375,53 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 			// frame.Fields = append(frame.Fields,
 			// 	data.NewField("self", nil, []float64{0}),
 			// )
+			frame.Meta = inspector.meta()
+			frame.Meta.Notices = append(frame.Meta.Notices, noDataNotice)
 			response.Frames = append(response.Frames, frame)
 			return response, nil
 		}
-		//
+		// 按列转换
 		dataAll := make(map[string]interface{})
 
-		values := tracingsqlRes.Result["values"].([]interface{})
-
-		for i := 0; i < len(values); i++ {
-			subValue := values[i].([]interface{})
-			if len(subValue) != len(columns) {
-				// value的子值和字段长度不一致
-				return response, fmt.Errorf(fmt.Sprintf("sub-value: %v and columns: %v have different lengths", subValue, columns))
-			}
-			for j := 0; j < len(columns); j++ {
-				column := columns[j].(string)
-				switch column {
-				case "level", "total_value", "self_value":
-					var filed_name string
-					if column == "level" {
-						filed_name = "level"
-					} else if column == "total_value" {
-						filed_name = "value"
-					} else if column == "self_value" {
-						filed_name = "self"
-					}
-					slice, ok := dataAll[filed_name].([]float64)
+		values := tracingsqlRes.Values
+		for _, column := range values.Columns {
+			switch column.Name {
+			case "level", "total_value", "self_value":
+				var filed_name string
+				if column.Name == "level" {
+					filed_name = "level"
+				} else if column.Name == "total_value" {
+					filed_name = "value"
+				} else if column.Name == "self_value" {
+					filed_name = "self"
+				}
+				slice := make([]float64, values.Len())
+				for i := range slice {
+					floatValue, ok := column.Float(i)
 					if !ok {
-						slice = []float64{}
+						return response, fmt.Errorf("unexpected type for %v, assertion failed, type %T", column.Value(i), column.Value(i))
 					}
-					if _, ok := subValue[j].(json.Number); ok {
-						floatValue, err := subValue[j].(json.Number).Float64()
-						if err != nil {
-							return response, fmt.Errorf("unexpected type for %v, assertion failed for float64, type %T", subValue[j], subValue[j])
-
-						}
-						if column != "level" {
-							floatValue *= 1000
-						}
-						dataAll[filed_name] = append(slice, floatValue)
-					} else {
-						return response, fmt.Errorf("unexpected type for %v, assertion failed, type %T", subValue[j], subValue[j])
+					if column.Name != "level" {
+						floatValue *= 1000
 					}
-				case "function":
-					filed_name := "label"
-					// 断言为 []string
-					slice, ok := dataAll[filed_name].([]string)
-					if !ok {
-						slice = []string{}
-					}
-					// 追加值并进行类型转换
-					stringValue, ok := subValue[j].(string)
+					slice[i] = floatValue
+				}
+				dataAll[filed_name] = slice
+			case "function":
+				slice := make([]string, values.Len())
+				for i := range slice {
+					stringValue, ok := column.Value(i).(string)
 					if !ok {
-						return response, fmt.Errorf("unexpected type for %v, expected string", subValue[j])
+						return response, fmt.Errorf("unexpected type for %v, expected string", column.Value(i))
 					}
-					dataAll[filed_name] = append(slice, stringValue)
+					slice[i] = stringValue
 				}
+				dataAll["label"] = slice
 			}
 		}
 		//记录日志
 		//column和value 匹配后数据
-		log.DefaultLogger.Info("__________The data after matching columns and value", columns, dataAll)
+		d.logs.dumpRows(debug, "__________The data after matching columns and value", dataAll)
 
 		//数据
 		frame := data.NewFrame("response")
@@ -367,6 +452,10 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		// frame.Fields = append(frame.Fields,
 		// 	data.NewField("self", nil, dataAll["self"]),
 		// )
+		frame.Meta = inspector.meta()
+		if tracingsqlRes.Truncated != "" {
+			frame.AppendNotices(truncatedNotice(tracingsqlRes.Truncated))
+		}
 		response.Frames = append(response.Frames, frame)
 		return response, nil
 	}
@@ -382,22 +471,30 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		tracingIdValue := qj["_id"].(string)
 
 		// 获取tracing数据
-		traceRes, err := d.trace(ctx, debug, traceUrl, tracingIdValue, fromTimeInt64, toTimeInt64)
+		traceRes, err := client.Trace(ctx, TraceRequest{
+			Id:    tracingIdValue,
+			Debug: debug,
+			From:  fromTimeInt64,
+			To:    toTimeInt64,
+		})
 		if err != nil {
 			return response, err
 		}
 
-		if _, ok := traceRes["DATA"]; !ok {
+		if traceRes.Data == nil {
 			// 缺失data字段
 			return response, fmt.Errorf("the trace query returns a format error, the DATA field is missing")
 		}
 
 		// 空数据
-		if _, ok := traceRes["DATA"].([]interface{}); ok {
+		if _, ok := traceRes.Data.([]interface{}); ok {
 			return response, nil
 		}
 		//存在数据
-		traceResData := traceRes["DATA"].(map[string]interface{})
+		traceResData, ok := traceRes.Data.(map[string]interface{})
+		if !ok {
+			return response, fmt.Errorf("the trace query returns a format error, DATA should be an object")
+		}
 
 		if _, ok := traceResData["services"]; !ok {
 			// 缺失services
@@ -413,9 +510,19 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 
 		// 获取tag 翻译
 		tagTranslate := make(map[string]interface{})
+		tagValuesRequest := func(tag string) QuerierRequest {
+			return QuerierRequest{
+				Db:            "flow_log",
+				Sql:           "show tag " + tag + " values from l7_flow_log",
+				DataPrecision: sources,
+				Debug:         debug,
+				From:          fromTimeInt64,
+				To:            toTimeInt64,
+			}
+		}
 
 		//获取l7_protocol 翻译
-		l7_protocol, err := d.querier(ctx, appType, debug, "flow_log", "show tag l7_protocol values from l7_flow_log", sources, "", token, requestUrl, fromTimeInt64, toTimeInt64)
+		l7_protocol, err := d.querier(ctx, client, appType, tagValuesRequest("l7_protocol"))
 
 		if err != nil {
 			return response, err
@@ -430,7 +537,7 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		tagTranslate["l7_protocol"] = tag17Protocol
 
 		//获取response_status翻译
-		response_status, err := d.querier(ctx, appType, debug, "flow_log", "show tag response_status values from l7_flow_log", sources, "", token, requestUrl, fromTimeInt64, toTimeInt64)
+		response_status, err := d.querier(ctx, client, appType, tagValuesRequest("response_status"))
 		if err != nil {
 			return response, err
 		}
@@ -442,7 +549,7 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		tagTranslate["response_status"] = tagResponseStatus
 
 		// 获取tap_side翻译
-		tap_side, err := d.querier(ctx, appType, debug, "flow_log", "show tag tap_side values from l7_flow_log", sources, token, "", requestUrl, fromTimeInt64, toTimeInt64)
+		tap_side, err := d.querier(ctx, client, appType, tagValuesRequest("tap_side"))
 		if err != nil {
 			return response, err
 		}
@@ -453,7 +560,7 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		}
 		tagTranslate["tap_side"] = tagTapSide
 
-		log.DefaultLogger.Info("__________tag: ", fmt.Sprintf("%v", tagTranslate))
+		d.logs.dumpRows(debug, "__________tag translation", tagTranslate)
 
 		// tracings 追加翻译
 		//生成where
@@ -492,54 +599,26 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		tracingWhereNew := strings.TrimSuffix(tracingWhere, " or ")
 		tracingsql := sql + tracingWhereNew + " order by `start_time`"
 		// 请求数据
-		tracingsqlRes, err := d.querier(ctx, appType, debug, "flow_log", tracingsql, sources, token, "", requestUrl, fromTimeInt64, toTimeInt64)
+		tracingsqlRes, inspector, err := d.inspectedQuerier(ctx, client, appType, QuerierRequest{
+			Db:            "flow_log",
+			Sql:           tracingsql,
+			DataPrecision: sources,
+			Debug:         debug,
+			From:          fromTimeInt64,
+			To:            toTimeInt64,
+		})
 
 		if err != nil {
 			return response, err
 		}
+		frameSpan := startFrameSpan(ctx, appType, querierRows(tracingsqlRes))
+		defer frameSpan.End()
 
-		// 获取列
-		var columns []interface{}
-		if _, ok := tracingsqlRes.Result["columns"]; !ok {
-			// 缺失columns
-			return response, fmt.Errorf("the columns field is missing in the returned data grid")
-		}
-		columns = tracingsqlRes.Result["columns"].([]interface{})
-
-		// 获取值
-		if _, ok := tracingsqlRes.Result["values"]; !ok {
-			//缺失values
-			return response, fmt.Errorf("the values field is missing in the returned data grid")
-		}
-		//
-		var dataListsAll []map[string]interface{}
-
-		if tracingsqlRes.Result["values"] == nil {
-			dataLists := make([]map[string]interface{}, 0)
-			dataListsAll = dataLists
-		} else {
-			values := tracingsqlRes.Result["values"].([]interface{})
-
-			//column为key，格式化数据
-			dataLists := make([]map[string]interface{}, len(values))
-
-			for i := 0; i < len(values); i++ {
-				subValue := values[i].([]interface{})
-				if len(subValue) != len(columns) {
-					// value的子值和字段长度不一致
-					return response, fmt.Errorf(fmt.Sprintf("sub-value: %v and columns: %v have different lengths", subValue, columns))
-				}
-				kv := make(map[string]interface{})
-				for j := 0; j < len(columns); j++ {
-					kv[columns[j].(string)] = subValue[j]
-				}
-				dataLists[i] = kv
-			}
-			dataListsAll = dataLists
-		}
+		//column为key，格式化数据
+		dataListsAll := rowsOf(tracingsqlRes)
 		//记录日志
 		//column和value 匹配后数据
-		log.DefaultLogger.Info("__________The data after matching columns and value", dataListsAll)
+		d.logs.dumpRows(debug, "__________The data after matching columns and value", dataListsAll)
 
 		//数据
 		frame := data.NewFrame("response")
@@ -596,6 +675,7 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		var FrameMeta data.FrameMeta
 		FrameMeta.Custom = frameMetasAll
 
+		inspector.apply(&FrameMeta)
 		frame.Meta = &FrameMeta
 		response.Frames = append(response.Frames, frame)
 		return response, nil
@@ -607,6 +687,25 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		return response, err
 	}
 
+	// 时序函数，只作用于分组后的时序数据
+	seriesFunctions, _ := queryText["seriesFunctions"].(string)
+	seriesFuncs, err := parseSeriesFuncs(seriesFunctions)
+	if err != nil {
+		return response, err
+	}
+
+	// 补点策略，只作用于分组后的时序数据
+	fill, err := parseGapFill(queryText)
+	if err != nil {
+		return response, err
+	}
+
+	// 按 MaxDataPoints 降采样的方式，只作用于分组后的时序数据
+	downsample, err := parseDownsample(queryText)
+	if err != nil {
+		return response, err
+	}
+
 	// 从qj获取
 	//metaExtra
 	metaExtra := qj["metaExtra"].(map[string]interface{})
@@ -653,97 +752,98 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		}
 	}
 
-	//
-
-	// 请求querier
-	body, err := d.querier(ctx, appType, debug, db, sql, sources, token, "", requestUrl, fromTimeInt64, toTimeInt64)
+	querierRequest := QuerierRequest{
+		Db:            db,
+		Sql:           sql,
+		DataPrecision: sources,
+		Debug:         debug,
+		From:          fromTimeInt64,
+		To:            toTimeInt64,
+	}
 
+	// 预估扫描行数，估算失败只给出提示
+	estimateWarning, err := d.checkEstimatedRows(ctx, client, appType, querierRequest)
 	if err != nil {
 		return response, err
 	}
-
-	// 获取列
-	var columns []interface{}
-	if _, ok := body.Result["columns"]; !ok {
-		//缺失columns
-		return response, fmt.Errorf("the columns field is missing in the returned data grid")
-	}
-	columns = body.Result["columns"].([]interface{})
-
-	// 获取值
-	if res, ok := body.Result["values"]; !ok {
-		//缺失values
-		return response, fmt.Errorf("the values field is missing in the returned data grid")
-	} else {
-		//查询为空
-		if res == nil {
-			return response, nil
-		}
+	if estimateWarning != "" {
+		log.DefaultLogger.Warn("__________" + estimateWarning)
 	}
 
-	// schemas := body.Result["schemas"].([]interface{})
-	// //column为key，格式化数据
-	// valueByschemas := make([]map[string]interface{}, len(schemas))
-
-	values := body.Result["values"].([]interface{})
+	// 请求querier
+	body, inspector, err := d.inspectedQuerier(ctx, client, appType, querierRequest)
 
-	//column为key，格式化数据
-	valueBycolumns := make([]map[string]interface{}, len(values))
+	if err != nil {
+		return response, err
+	}
+	frameSpan := startFrameSpan(ctx, appType, querierRows(body))
+	defer frameSpan.End()
 
-	for i := 0; i < len(values); i++ {
-		subValue := values[i].([]interface{})
-		if len(subValue) != len(columns) {
-			//value子值和columns长度不一致
-			return response, fmt.Errorf(fmt.Sprintf("subvalue: %v and columns: %v lengths are inconsistent", subValue, columns))
+	// exemplars：trafficQuery 时序查询中每个时间桶最慢的异常请求或最慢的请求，查询失败只给出提示
+	exemplars, _ := queryText["exemplars"].(bool)
+	addExemplars := func(response backend.DataResponse) backend.DataResponse {
+		if !exemplars || appType != "trafficQuery" || formatAs != "timeSeries" {
+			return response
 		}
-		kv := make(map[string]interface{})
-		for j := 0; j < len(columns); j++ {
-			kvName := columns[j].(string)
-			kvValue := subValue[j]
-			if kvName == "toString(_id)" {
-				kvName = "_id"
-				kvValue = "id-" + formattools.ValueToString(kvValue).(string)
+		frame, err := d.queryExemplars(ctx, client, QuerierRequest{
+			Sql:           sql,
+			DataPrecision: sources,
+			Debug:         debug,
+			From:          fromTimeInt64,
+			To:            toTimeInt64,
+		}, query.TimeRange, response.Frames)
+		if err != nil {
+			log.DefaultLogger.Warn("__________exemplar query failed", d.logs.errorFields(err)...)
+			if len(response.Frames) > 0 {
+				response.Frames[0].AppendNotices(data.Notice{Severity: data.NoticeSeverityWarning, Text: "exemplars: " + err.Error()})
 			}
-
-			kv[kvName] = kvValue
+			return response
 		}
-		valueBycolumns[i] = kv
+		response.Frames = append(response.Frames, frame)
+		return response
 	}
-	//记录日志
-	//columns和value 匹配后数据
-	log.DefaultLogger.Info("__________the data after matching columns and value", valueBycolumns)
 
-	if len(valueBycolumns) <= 0 {
+	//查询为空
+	if body.Values.Len() == 0 {
+		response.Frames = inspector.emptyResponse()
 		return response, nil
 	}
 
-	//特殊处理
-	for _, v := range valueBycolumns {
-		if _, ok := v["client_node_type"]; ok {
-			formattools.AddResourceFieldsInData(v, "client")
-		}
-		if _, ok := v["server_node_type"]; ok {
-			formattools.AddResourceFieldsInData(v, "server")
+	// 枚举 tag 翻译，查询 tag 元数据失败时只给出提示
+	translateOn := d.enums.enabled
+	if v, ok := queryText["translateEnums"].(bool); ok {
+		translateOn = v
+	}
+	var translations map[string]map[string]string
+	var translateNotice *data.Notice
+	if translateOn {
+		translations, err = d.enums.translations(ctx, client, db, queryTable(queryText, sql), sources, body.Columns)
+		if err != nil {
+			log.DefaultLogger.Warn("__________enum translation failed", d.logs.errorFields(err)...)
+			translateNotice = &data.Notice{Severity: data.NoticeSeverityWarning, Text: "enum translation: " + err.Error()}
 		}
 	}
 
+	//按列转换，特殊处理的字段也按列计算
+	table := newResultTable(body.Values)
+	if table.column("client_node_type") != nil {
+		table.addResourceColumns("client")
+	}
+	if table.column("server_node_type") != nil {
+		table.addResourceColumns("server")
+	}
+	table.translateEnums(translations)
+
 	// 获取第一个值
-	firstResponse := valueBycolumns[0]
+	firstResponse := table.row(0)
 	// columns  排序
-	firstResponseSort := make([]string, len(firstResponse))
-	i := 0
-	for k := range firstResponse {
-		firstResponseSort[i] = k
-		i++
-	}
-	sort.Strings(firstResponseSort)
+	firstResponseSort := table.names()
 	//key 分类
-	metricKeys := make([]string, 0, len(firstResponse))
-	timeKeys := make([]string, 0, len(firstResponse))
-	tagKeys := make([]string, 0, len(firstResponse))
+	metricKeys := make([]string, 0, len(firstResponseSort))
+	timeKeys := make([]string, 0, len(firstResponseSort))
+	tagKeys := make([]string, 0, len(firstResponseSort))
 
-	j := 0
-	for k, v := range firstResponse {
+	for _, k := range firstResponseSort {
 		isMetric := false
 		for _, v := range returnMetricNames {
 			if k == v {
@@ -754,14 +854,13 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		if isMetric {
 			metricKeys = append(metricKeys, k)
 		} else if isTime := strings.Contains(k, "time"); isTime {
-			if _, ok := v.(json.Number); ok {
+			if _, ok := firstResponse.value(k).(json.Number); ok {
 				timeKeys = append(timeKeys, k)
 			}
 
 		} else {
 			tagKeys = append(tagKeys, k)
 		}
-		j++
 	}
 	//处理Custom
 	type FrameMetas struct {
@@ -793,9 +892,20 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 	// 定义元数据
 	var FrameMeta data.FrameMeta
 	FrameMeta.Custom = frameMetasAll
+	if body.Truncated != "" {
+		FrameMeta.Notices = append(FrameMeta.Notices, truncatedNotice(body.Truncated))
+	}
+	if translateNotice != nil {
+		FrameMeta.Notices = append(FrameMeta.Notices, *translateNotice)
+	}
+	if estimateWarning != "" {
+		FrameMeta.Notices = append(FrameMeta.Notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: estimateWarning})
+	}
 
 	//元数据
-	log.DefaultLogger.Info("__________FrameMeta.Custom", FrameMeta)
+	if debugEnabled() {
+		log.DefaultLogger.Debug("__________FrameMeta.Custom", "data", d.logs.payload(FrameMeta.Custom))
+	}
 
 	//返回
 	usingGroupBy := false
@@ -803,91 +913,139 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		usingGroupBy = true
 	}
 	//排序后的第一个值
-	log.DefaultLogger.Info("__________returns the first value after sorting", firstResponseSort)
+	d.logs.dumpRows(debug, "__________returns the first value after sorting", firstResponseSort)
+
+	// 日志、热力图及直方图格式
+	if formatAs == "logs" || formatAs == "heatmap" || formatAs == "histogram" {
+		var frames data.Frames
+		if formatAs == "logs" {
+			template, _ := queryText["logsTemplate"].(string)
+			frame, err := d.logsFormat.logsFrame(table, template)
+			if err != nil {
+				return response, err
+			}
+			frames = data.Frames{frame}
+		} else {
+			bucketTag, _ := queryText["bucketTag"].(string)
+			frames, err = bucketFrames(formatAs, table, bucketTag, timeKeys, tagKeys, metricKeys)
+			if err != nil {
+				return response, err
+			}
+		}
+		for _, frame := range frames {
+			frame.Meta.Custom = FrameMeta.Custom
+			frame.Meta.Notices = FrameMeta.Notices
+			inspector.apply(frame.Meta)
+		}
+		response.Frames = append(response.Frames, frames...)
+		return response, nil
+	}
 
 	//无需分组，直接一个frame返回
 	if !usingGroupBy {
 		//返回数据无需分组处理
-		log.DefaultLogger.Info("__________Return data without group processing")
+		log.DefaultLogger.Debug("__________Return data without group processing")
 
 		//返回
 		frame := data.NewFrame("response")
 
 		frame.Meta = &FrameMeta
 
-		// 按照排序后添加字段
-		for _, columnsSort := range firstResponseSort {
-			columnsType, _ := formatParams(isQuery, "field", timeKeys, returnMetrics, true, returnMetricNames, columnsSort, firstResponse[columnsSort])
-
-			// log.DefaultLogger.Info(fmt.Sprintf("%v,%v,%T", columnsSort, columnsType, columnsType))
-
-			frame.Fields = append(frame.Fields,
-				data.NewField(columnsSort, nil, columnsType),
-			)
-
+		// 时序数据按时间排序，其余按返回的顺序
+		var rows []int
+		if formatAs == "timeSeries" && len(timeKeys) > 0 {
+			times, err := rowTimes(table, timeKeys[0])
+			if err != nil {
+				return response, err
+			}
+			rows = make([]int, table.rows)
+			for i := range rows {
+				rows[i] = i
+			}
+			sort.SliceStable(rows, func(a, b int) bool { return times[rows[a]] < times[rows[b]] })
 		}
-		// 添加数据value
-		for _, subValueBycolumns := range valueBycolumns {
-			vals := make([]interface{}, len(firstResponseSort))
-			for i, columnsSort := range firstResponseSort {
-				//转换类型后的value
 
-				columnsValue, err := formatParams(isQuery, "value", timeKeys, returnMetrics, true, returnMetricNames, columnsSort, subValueBycolumns[columnsSort])
-
-				// log.DefaultLogger.Info(fmt.Sprintf("------%v,%v, %v, %v,%T, %v,%T--------", k, i, columnsSort, columnsValue, columnsValue, subValueBycolumns[columnsSort], subValueBycolumns[columnsSort]))
-
-				//value 类型错误
-				if err != nil {
-					return response, fmt.Errorf(err.Error())
-				}
-
-				vals[i] = columnsValue
+		// 按照排序后添加字段，每列按类型整列转换
+		for _, columnsSort := range firstResponseSort {
+			columnsType, _ := formatParams(isQuery, "field", timeKeys, returnMetrics, true, returnMetricNames, columnsSort, firstResponse.value(columnsSort))
+			field, err := columnField(columnsSort, columnsType, table.column(columnsSort), rows)
+			if err != nil {
+				return response, err
 			}
-			frame.AppendRow(vals...)
+			frame.Fields = append(frame.Fields, field)
+		}
+		if appType == "appTracing" {
+			d.addTracingLinks(frame, fromTime, toTime)
 		}
+		if formatAs == "timeSeries" {
+			frames, notice := downsampleFrames(data.Frames{frame}, downsample, query.MaxDataPoints)
+			if notice != nil {
+				FrameMeta.Notices = append(FrameMeta.Notices, *notice)
+			}
+			frame = frames[0]
+		}
+		inspector.apply(&FrameMeta)
 
 		response.Frames = append(response.Frames, frame)
-		return response, nil
+		return addExemplars(response), nil
 	}
 	//返回时间序列数据 & 分组依据
-	log.DefaultLogger.Info("__________Return time series data & group by")
+	log.DefaultLogger.Debug("__________Return time series data & group by")
 
-	//按照tag分组
-	dataAfterGroupBy := map[string][]map[string]interface{}{}
-	for _, item := range valueBycolumns {
-		key := ""
-		for _, v := range tagKeys {
-			if vv, ok := item[v]; ok {
+	//记录日志
+	//columns和value 匹配后数据
+	d.logs.dumpRows(debug, "__________the data after matching columns and value", table)
 
-				key = key + formattools.ValueToString(vv).(string) + ", "
-			}
+	//按照tag分组，每组为行号
+	tagColumns := make([]*deepflowclient.Column, len(tagKeys))
+	for i, v := range tagKeys {
+		tagColumns[i] = table.column(v)
+	}
+	dataAfterGroupBy := map[string][]int{}
+	for i := 0; i < table.rows; i++ {
+		key := ""
+		for _, c := range tagColumns {
+			key = key + c.Text(i) + ", "
 		}
 		preKey := strings.TrimSuffix(key, ", ")
 
-		dataAfterGroupBy[preKey] = append(dataAfterGroupBy[preKey], item)
+		dataAfterGroupBy[preKey] = append(dataAfterGroupBy[preKey], i)
 	}
 
-	// 分组返回
-	for _, item := range dataAfterGroupBy {
-
-		timeTypeKey := ""
-		if len(timeKeys) > 0 {
-			//只取第一个？
-			timeTypeKey = timeKeys[0]
+	//timeKeys有值，组内按照time排序
+	var times []int64
+	if len(timeKeys) > 0 {
+		//只取第一个？
+		if times, err = rowTimes(table, timeKeys[0]); err != nil {
+			return response, err
 		}
-		//默认不排序
-		sortItem := item
+	}
 
-		//timeKeys有值，按照time排序
-		if timeTypeKey != "" && len(item) > 0 {
-			sortItem, err = formattools.FieldSort(item, timeTypeKey)
-			if err != nil {
-				return response, fmt.Errorf(err.Error())
+	// 每个 frame 按 firstResponseSort 的顺序添加字段，metric 字段的下标相同，时序函数只作用于这些字段
+	metricIndexes := []int{}
+	for i, columnsSort := range firstResponseSort {
+		for _, v := range returnMetricNames {
+			if columnsSort == v {
+				metricIndexes = append(metricIndexes, i)
+				break
 			}
 		}
+	}
+
+	// 分组返回，按分组的 key 排序保证 frame 顺序稳定
+	groupKeys := make([]string, 0, len(dataAfterGroupBy))
+	for k := range dataAfterGroupBy {
+		groupKeys = append(groupKeys, k)
+	}
+	sort.Strings(groupKeys)
+	for _, groupKey := range groupKeys {
+		rows := dataAfterGroupBy[groupKey]
+		if times != nil {
+			sort.SliceStable(rows, func(a, b int) bool { return times[rows[a]] < times[rows[b]] })
+		}
 
 		// 别名替换
-		aliasName := formattools.GetMetricFieldNameByAlias(alias, sortItem[0])
+		aliasName := formattools.GetMetricFieldNameByAlias(alias, table.rowMap(rows[0]))
 
 		//key拼接
 		keyPrefix := "*"
@@ -895,33 +1053,23 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 			keyPrefix = aliasName
 		} else {
 			key := ""
-			for _, v := range tagKeys {
+			for i, v := range tagKeys {
 				if !strings.Contains(v, "_id") {
-					if len(sortItem) > 0 {
-						if keyValue, ok := sortItem[0][v]; ok {
-							keys := formattools.ValueToString(keyValue)
-							key = key + keys.(string) + ", "
-						}
-
-					}
+					key = key + tagColumns[i].Text(rows[0]) + ", "
 				}
 			}
 			if len(key) > 0 {
 				keyPrefix = strings.TrimSuffix(key, ", ")
 			}
 		}
-		// log.DefaultLogger.Info("sortItem[0]", "数据", sortItem[0])
-		// log.DefaultLogger.Info("tagKeys", "数据", tagKeys)
-		// log.DefaultLogger.Info("keyrepfix", "数据", keyPrefix)
 
 		// frame := data.NewFrame(keyPrefix)
 		frame := data.NewFrame("")
 		frame.Meta = &FrameMeta
 		frameName := ""
-		// log.DefaultLogger.Info("____________field")
-		// 按照排序后添加字段
+		// 按照排序后添加字段，每列按类型转换组内的行
 		for _, columnsSort := range firstResponseSort {
-			columnsType, _ := formatParams(isQuery, "field", timeKeys, returnMetrics, false, returnMetricNames, columnsSort, firstResponse[columnsSort])
+			columnsType, _ := formatParams(isQuery, "field", timeKeys, returnMetrics, false, returnMetricNames, columnsSort, firstResponse.value(columnsSort))
 			//
 			NewFieldName := columnsSort
 			//
@@ -941,117 +1089,45 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 				frameName += NewFieldName + ", "
 			}
 
-			// log.DefaultLogger.Info("columnsSort", "数据", columnsSort)
-			// log.DefaultLogger.Info("returnMetricNames", "数据", returnMetricNames)
-			// log.DefaultLogger.Info("isMetricName", "数据", isMetricName)
-			// log.DefaultLogger.Info("queryShowMetrics", "数据", queryShowMetrics)
-
-			frame.Fields = append(frame.Fields,
-				data.NewField(NewFieldName, nil, columnsType),
-			)
+			field, err := columnField(NewFieldName, columnsType, table.column(columnsSort), rows)
+			if err != nil {
+				return response, err
+			}
+			frame.Fields = append(frame.Fields, field)
 		}
 
 		// frame.Name = frameName
 
-		//没有数据,跳过
-		if len(item) <= 0 {
-			response.Frames = append(response.Frames, frame)
-			continue
-		}
-
-		// log.DefaultLogger.Info("____________value")
-		// 添加数据value
-		for _, subValueBycolumns := range sortItem {
-			vals := make([]interface{}, len(firstResponseSort))
-			for i, columnsSort := range firstResponseSort {
-				//转换类型后的value
-				columnsValue, err := formatParams(isQuery, "value", timeKeys, returnMetrics, false, returnMetricNames, columnsSort, subValueBycolumns[columnsSort])
-				//value 类型错误
-				if err != nil {
-					return response, fmt.Errorf(err.Error())
-				}
-
-				vals[i] = columnsValue
-			}
-			frame.AppendRow(vals...)
-		}
-
-		// log.DefaultLogger.Info("frame", "数据", &frame.Fields[0])
 		response.Frames = append(response.Frames, frame)
 	}
-
-	return response, nil
-}
-
-// 三方trace接口查询
-func (d *Datasource) trace(ctx context.Context, debug bool, traceUrl, tracingIdValue string, fromTime, toTime int64) (res map[string]interface{}, err error) {
-
-	var body map[string]interface{}
-
-	postData := make(map[string]interface{})
-
-	postData["_id"] = tracingIdValue
-
-	postData["DATABASE"] = "flow_log"
-	postData["TABLE"] = "l7_flow_log"
-	postData["MAX_ITERATION"] = 30
-	postData["time_end"] = toTime
-	postData["time_start"] = fromTime
-
-	postDataMap, _ := json.Marshal(postData)
-	StrPostData := string(postDataMap)
-
-	//请求tracing接口
-	log.DefaultLogger.Info("__________request tracing interface", "data", StrPostData)
-
-	//请求url
-	tracedebugStr := strconv.FormatBool(debug)
-	traceingUrl := traceUrl + "/v1/stats/querier/L7FlowTracing?debug=" + tracedebugStr
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, traceingUrl, strings.NewReader(StrPostData))
-
-	if err != nil {
-		return body, fmt.Errorf("create request failed: %w", err)
+	frames, notice := fill.apply(response.Frames, sql, fromTime, toTime, d.limits.maxRows)
+	if notice != nil {
+		FrameMeta.Notices = append(FrameMeta.Notices, *notice)
 	}
-
-	req.Header.Add("Content-Type", "application/json; charset=utf-8")
-	req.Header.Add("X-User-Id", "1")
-	req.Header.Add("X-User-Type", "1")
-
-	//发起请求
-	resp, err := d.httpClient.Do(req)
-	if err != nil {
-		return body, fmt.Errorf("failed to request interface: %w", err)
+	frames = applySeriesFuncs(frames, seriesFuncs, metricIndexes)
+	frames, notice = downsampleFrames(frames, downsample, query.MaxDataPoints)
+	if notice != nil {
+		FrameMeta.Notices = append(FrameMeta.Notices, *notice)
 	}
-	//
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.DefaultLogger.Error("__________failed to close the interface response", "error", err.Error())
-		}
-	}()
-	defer resp.Body.Close()
+	response.Frames = frames
+	inspector.apply(&FrameMeta)
 
-	// Make sure the response was successful
-	if resp.StatusCode != http.StatusOK {
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(resp.Body)
-		newStr := buf.String()
-		return body, fmt.Errorf("the expected status code returns 200, the actual return is %d, the parameter data is %v, and the return data is %v", resp.StatusCode, StrPostData, newStr)
-	}
-
-	// 接口返回格式
-	apiRes := json.NewDecoder(resp.Body)
-	apiRes.UseNumber()
-	err = apiRes.Decode(&body)
+	return addExemplars(response), nil
+}
 
-	if err != nil {
-		return body, fmt.Errorf("interface returned data format failed: %w", err)
+// rowTimes 每行时间列的值 (秒)
+func rowTimes(table *resultTable, timeKey string) ([]int64, error) {
+	timeColumn := table.column(timeKey)
+	times := make([]int64, table.rows)
+	for i := range times {
+		tv, ok := timeColumn.Float(i)
+		if !ok {
+			v := timeColumn.Value(i)
+			return nil, fmt.Errorf("time: columns: %v, value: %v, assertion failed, type %T", timeColumn.Name, v, v)
+		}
+		times[i] = int64(tv)
 	}
-
-	// 记录日志
-	// log.DefaultLogger.Info("格式化后接口返回", "数据", body)
-
-	return body, nil
+	return times, nil
 }
 
 // 返回格式处理，columns&value字段类型
@@ -1271,162 +1347,3 @@ func (d *Datasource) verifyParams(qj, queryText map[string]interface{}) (err err
 
 	return nil
 }
-
-// 三方querier接口查询
-func (d *Datasource) querier(ctx context.Context, appType string, debug bool, db, sql, sources, token, profileEventType, requestUrl string, fromTimeInt64, toTimeInt64 int64) (res newtypes.ApiMetrics, err error) {
-
-	var body newtypes.ApiMetrics
-
-	postData := make(map[string]string)
-
-	if sql == "" {
-		return body, fmt.Errorf("sql cannot be empty")
-	}
-
-	if timeFrom := strings.Contains(sql, "'${__from:date:seconds}'"); timeFrom {
-		fromTimeString := strconv.FormatInt(fromTimeInt64, 10)
-		sql = strings.ReplaceAll(sql, "'${__from:date:seconds}'", fromTimeString)
-	}
-
-	if timeTo := strings.Contains(sql, "'${__to:date:seconds}'"); timeTo {
-		toTimeString := strconv.FormatInt(toTimeInt64, 10)
-		sql = strings.ReplaceAll(sql, "'${__to:date:seconds}'", toTimeString)
-	}
-
-	data := url.Values{}
-	data.Set("sql", sql)
-
-	postData["sql"] = sql
-
-	if db != "" {
-		postData["db"] = db
-		data.Set("db", db)
-	}
-
-	if sources != "" {
-		postData["data_precision"] = sources
-		data.Set("data_precision", sources)
-	}
-
-	if profileEventType != "" {
-		postData["profile_event_type"] = profileEventType
-		data.Set("profile_event_type", profileEventType)
-	}
-
-	// postDataMap, _ := json.Marshal(postData)
-	// StrPostData := string(postDataMap)
-	//请求querier接口
-	log.DefaultLogger.Info("__________request querier interface", "data", data)
-
-	//请求url
-	debugStr := strconv.FormatBool(debug)
-
-	var querier string
-	if appType == "profiling" {
-		querier = requestUrl + "/v1/profile/ProfileGrafana?debug=" + debugStr
-	} else {
-		querier = requestUrl + "/v1/query/?debug=" + debugStr
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, querier, bytes.NewReader([]byte(data.Encode())))
-
-	if err != nil {
-		return body, fmt.Errorf("create request failed: %w", err)
-	}
-
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-
-	// 如果有token
-	if token != "" {
-		req.Header.Add("Authorization", "Bearer "+token)
-	}
-
-	//发起请求
-	resp, err := d.httpClient.Do(req)
-	if err != nil {
-		return body, fmt.Errorf("failed to request interface: %w", err)
-	}
-	//
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.DefaultLogger.Error("__________failed to close the interface response", "error", err.Error())
-		}
-	}()
-	defer resp.Body.Close()
-
-	// Make sure the response was successful
-	if resp.StatusCode != http.StatusOK {
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(resp.Body)
-		newStr := buf.String()
-		return body, fmt.Errorf("the expected status code returns 200, the actual return is %d, the parameter data is %v, and the data is %v", resp.StatusCode, data, newStr)
-	}
-
-	// 接口返回格式
-	apiRes := json.NewDecoder(resp.Body)
-	apiRes.UseNumber()
-	err = apiRes.Decode(&body)
-
-	if err != nil {
-		return body, fmt.Errorf("failed to format interface return data: %w", err)
-	}
-
-	// 记录日志
-	// log.DefaultLogger.Info("格式化后接口返回", "数据", body)
-
-	return body, nil
-}
-
-// CheckHealth performs a request to the specified data source and returns an error if the HTTP handler did not return
-// a 200 OK response.
-func (d *Datasource) CheckHealth(ctx context.Context, _ *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	// r, err := http.NewRequestWithContext(ctx, http.MethodGet, d.settings.URL, nil)
-	// r, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://172.30.128.1:10001/metrics", nil)
-	// if err != nil {
-	// 	return newHealthCheckErrorf("could not create request"), nil
-	// }
-	// resp, err := d.httpClient.Do(r)
-	// if err != nil {
-	// 	return newHealthCheckErrorf("request error"), nil
-	// }
-	// defer func() {
-	// 	if err := resp.Body.Close(); err != nil {
-	// 		log.DefaultLogger.Error("check health: failed to close response body", "err", err.Error())
-	// 	}
-	// }()
-	// if resp.StatusCode != http.StatusOK {
-	// 	return newHealthCheckErrorf("got response code %d", resp.StatusCode), nil
-	// }
-	var vv interface{}
-	json.Unmarshal(d.settings.JSONData, &vv)
-
-	dsj := vv.(map[string]interface{})
-
-	if _, ok := dsj["requestUrl"]; !ok {
-		return newHealthCheckErrorf("Missing configuration: requestUrl"), nil
-	}
-	//requestUrl
-	requestUrl := dsj["requestUrl"].(string)
-
-	//token
-	token := ""
-	if _, ok := dsj["token"]; ok {
-		token = dsj["token"].(string)
-	}
-
-	_, err := d.querier(ctx, "", false, "", "show databases", "", "", token, requestUrl, 0, 0)
-
-	if err != nil {
-		return newHealthCheckErrorf(err.Error()), nil
-	}
-	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusOk,
-		Message: "The data source test is OK",
-	}, nil
-}
-
-// newHealthCheckErrorf returns a new *backend.CheckHealthResult with its status set to backend.HealthStatusError
-// and the specified message, which is formatted with Sprintf.
-func newHealthCheckErrorf(format string, args ...interface{}) *backend.CheckHealthResult {
-	return &backend.CheckHealthResult{Status: backend.HealthStatusError, Message: fmt.Sprintf(format, args...)}
-}
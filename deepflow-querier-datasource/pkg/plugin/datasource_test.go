@@ -0,0 +1,155 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
+)
+
+// recordedRequest 测试服务端收到的请求
+type recordedRequest struct {
+	path   string
+	query  url.Values
+	header http.Header
+	form   url.Values
+	body   string
+}
+
+type recordingServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []recordedRequest
+}
+
+func newRecordingServer(t *testing.T, handler func(w http.ResponseWriter, r recordedRequest)) *recordingServer {
+	t.Helper()
+	s := &recordingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		rec := recordedRequest{path: r.URL.Path, query: r.URL.Query(), header: r.Header.Clone(), body: string(b)}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			rec.form, _ = url.ParseQuery(string(b))
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, rec)
+		s.mu.Unlock()
+		handler(w, rec)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *recordingServer) recorded() []recordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]recordedRequest(nil), s.requests...)
+}
+
+func newTestDatasource(t *testing.T, jsonData map[string]interface{}) *Datasource {
+	t.Helper()
+	b, err := json.Marshal(jsonData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{JSONData: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inst.(*Datasource)
+}
+
+func tagValuesResponse(w http.ResponseWriter) {
+	w.Write([]byte(`{"OPT_STATUS":"SUCCESS","DESCRIPTION":"","result":{"columns":["value","display_name"],"values":[[20,"HTTP"],[0,"OK"]]}}`))
+}
+
+func TestCheckHealthSendsToken(t *testing.T) {
+	server := newRecordingServer(t, func(w http.ResponseWriter, _ recordedRequest) {
+		w.Write([]byte(`{"OPT_STATUS":"SUCCESS","result":{"columns":["name"],"values":[["flow_log"],["l7_flow_log"]]}}`))
+	})
+	d := newTestDatasource(t, map[string]interface{}{"requestUrl": server.URL, "token": "secret", "disableCache": true})
+
+	res, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != backend.HealthStatusOk {
+		t.Errorf("status = %v, message = %s", res.Status, res.Message)
+	}
+
+	for _, rec := range server.recorded() {
+		if rec.path != deepflowclient.QueryPath {
+			continue
+		}
+		if got := rec.header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("%s: Authorization = %q, want %q", rec.form.Get("sql"), got, "Bearer secret")
+		}
+		if rec.form.Has("profile_event_type") {
+			t.Errorf("%s: unexpected profile_event_type %q", rec.form.Get("sql"), rec.form.Get("profile_event_type"))
+		}
+	}
+}
+
+func TestAppTracingFlameSendsToken(t *testing.T) {
+	server := newRecordingServer(t, func(w http.ResponseWriter, r recordedRequest) {
+		if r.path == deepflowclient.TracePath {
+			w.Write([]byte(`{"DATA":{"services":[],"tracing":[{"_ids":["1"],"l7_protocol":20,"response_status":0,"tap_side":"c"}]}}`))
+			return
+		}
+		tagValuesResponse(w)
+	})
+	d := newTestDatasource(t, map[string]interface{}{
+		"requestUrl":   server.URL,
+		"traceUrl":     server.URL,
+		"token":        "secret",
+		"disableCache": true,
+	})
+
+	queryText, _ := json.Marshal(map[string]interface{}{"appType": "appTracingFlame", "db": "flow_log", "sources": ""})
+	queryJSON, _ := json.Marshal(map[string]interface{}{
+		"queryText":     string(queryText),
+		"sql":           "SELECT request_type FROM l7_flow_log",
+		"returnTags":    []interface{}{},
+		"returnMetrics": []interface{}{},
+		"_id":           "1",
+	})
+	_, err := d.query(context.Background(), backend.PluginContext{}, backend.DataQuery{
+		RefID:     "A",
+		JSON:      queryJSON,
+		TimeRange: backend.TimeRange{From: time.Unix(100, 0), To: time.Unix(200, 0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := 0
+	for _, rec := range server.recorded() {
+		if rec.path != deepflowclient.QueryPath {
+			continue
+		}
+		queries++
+		if got := rec.header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("%s: Authorization = %q, want %q", rec.form.Get("sql"), got, "Bearer secret")
+		}
+		if rec.form.Has("profile_event_type") {
+			t.Errorf("%s: unexpected profile_event_type %q", rec.form.Get("sql"), rec.form.Get("profile_event_type"))
+		}
+		if rec.form.Get("db") != "flow_log" {
+			t.Errorf("%s: db = %q, want flow_log", rec.form.Get("sql"), rec.form.Get("db"))
+		}
+	}
+	// l7_protocol、response_status、tap_side 翻译及 span 查询
+	if queries != 4 {
+		t.Errorf("got %d querier requests, want 4", queries)
+	}
+}
@@ -0,0 +1,196 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 降采样方式，lttb 保留曲线形状，avg/min/max 按连续的点分桶聚合
+var downsampleModes = map[string]bool{"lttb": true, "avg": true, "min": true, "max": true, "none": true}
+
+// parseDownsample 解析 queryText 中的 downsample，默认 none，不改变已有的面板及告警
+func parseDownsample(queryText map[string]interface{}) (string, error) {
+	mode, _ := queryText["downsample"].(string)
+	if mode == "" {
+		return "none", nil
+	}
+	if !downsampleModes[mode] {
+		return "", fmt.Errorf("invalid downsample %q, use lttb, avg, min, max or none", mode)
+	}
+	return mode, nil
+}
+
+// downsampleFrames 点数超过 maxDataPoints 的序列降采样到 maxDataPoints 个点，有序列被降采样时返回提示
+func downsampleFrames(frames data.Frames, mode string, maxDataPoints int64) (data.Frames, *data.Notice) {
+	if mode == "none" || maxDataPoints <= 2 {
+		return frames, nil
+	}
+	points := 0
+	for i, frame := range frames {
+		if int64(frame.Rows()) <= maxDataPoints {
+			continue
+		}
+		points = max(points, frame.Rows())
+		if mode == "lttb" {
+			frames[i] = lttbFrame(frame, int(maxDataPoints))
+		} else {
+			frames[i] = reduceFrame(frame, mode, int(maxDataPoints))
+		}
+	}
+	if points == 0 {
+		return frames, nil
+	}
+	return frames, &data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("downsampled from %d to %d points per series (%s), set a larger interval to see all points", points, maxDataPoints, mode),
+	}
+}
+
+// 序列中用于选点的字段：时间字段及第一个 metric 字段
+func seriesFieldIndexes(frame *data.Frame) (int, int) {
+	timeIndex, valueIndex := -1, -1
+	for i, field := range frame.Fields {
+		switch field.Type() {
+		case data.FieldTypeTime:
+			if timeIndex < 0 {
+				timeIndex = i
+			}
+		case data.FieldTypeNullableFloat64:
+			if valueIndex < 0 {
+				valueIndex = i
+			}
+		}
+	}
+	return timeIndex, valueIndex
+}
+
+func copyFrameSchema(frame *data.Frame) *data.Frame {
+	out := frame.EmptyCopy()
+	out.Meta = frame.Meta
+	for i, field := range frame.Fields {
+		out.Fields[i].Config = field.Config
+	}
+	return out
+}
+
+// lttbFrame Largest-Triangle-Three-Buckets，按第一个 metric 字段选点，保留首尾两点，其余字段取选中行的值
+// 空值不参与选点，整个桶都为空时保留桶的第一个点使图中的断点仍然可见
+func lttbFrame(frame *data.Frame, threshold int) *data.Frame {
+	timeIndex, valueIndex := seriesFieldIndexes(frame)
+	if timeIndex < 0 || valueIndex < 0 {
+		return frame
+	}
+	n := frame.Rows()
+	x := func(i int) float64 { return float64(frame.Fields[timeIndex].At(i).(time.Time).UnixNano()) }
+	y := func(i int) *float64 { return frame.Fields[valueIndex].At(i).(*float64) }
+
+	selected := make([]int, 0, threshold)
+	selected = append(selected, 0)
+	bucketSize := float64(n-2) / float64(threshold-2)
+	a := 0
+	for b := 0; b < threshold-2; b++ {
+		start := int(float64(b)*bucketSize) + 1
+		end := int(float64(b+1)*bucketSize) + 1
+		// 下一个桶的平均点，最后一个桶使用最后一个点
+		nextStart, nextEnd := end, min(int(float64(b+2)*bucketSize)+1, n)
+		if b == threshold-3 {
+			nextStart, nextEnd = n-1, n
+		}
+		avgX, avgY, count := 0.0, 0.0, 0
+		for i := nextStart; i < nextEnd; i++ {
+			if v := y(i); v != nil {
+				avgX += x(i)
+				avgY += *v
+				count++
+			}
+		}
+		chosen, maxArea := start, -1.0
+		if count > 0 && y(a) != nil {
+			avgX /= float64(count)
+			avgY /= float64(count)
+			ax, ay := x(a), *y(a)
+			for i := start; i < end; i++ {
+				v := y(i)
+				if v == nil {
+					continue
+				}
+				area := math.Abs((ax-avgX)*(*v-ay) - (ax-x(i))*(avgY-ay))
+				if area > maxArea {
+					chosen, maxArea = i, area
+				}
+			}
+		} else {
+			// 无法计算面积时取桶内第一个非空点
+			for i := start; i < end; i++ {
+				if y(i) != nil {
+					chosen = i
+					break
+				}
+			}
+		}
+		selected = append(selected, chosen)
+		a = chosen
+	}
+	selected = append(selected, n-1)
+
+	out := copyFrameSchema(frame)
+	for _, row := range selected {
+		vals := make([]interface{}, len(frame.Fields))
+		for i, field := range frame.Fields {
+			vals[i] = field.CopyAt(row)
+		}
+		out.AppendRow(vals...)
+	}
+	return out
+}
+
+// reduceFrame 将连续的点均分为 threshold 个桶，时间取桶的第一个点，metric 字段按 mode 聚合，空值不参与计算
+func reduceFrame(frame *data.Frame, mode string, threshold int) *data.Frame {
+	n := frame.Rows()
+	out := copyFrameSchema(frame)
+	for b := 0; b < threshold; b++ {
+		start := b * n / threshold
+		end := (b + 1) * n / threshold
+		if start >= end {
+			continue
+		}
+		vals := make([]interface{}, len(frame.Fields))
+		for i, field := range frame.Fields {
+			if field.Type() != data.FieldTypeNullableFloat64 {
+				vals[i] = field.CopyAt(start)
+				continue
+			}
+			var result *float64
+			count := 0
+			for j := start; j < end; j++ {
+				v := field.At(j).(*float64)
+				if v == nil {
+					continue
+				}
+				count++
+				if result == nil {
+					r := *v
+					result = &r
+					continue
+				}
+				switch mode {
+				case "avg":
+					*result += *v
+				case "min":
+					*result = math.Min(*result, *v)
+				case "max":
+					*result = math.Max(*result, *v)
+				}
+			}
+			if mode == "avg" && result != nil {
+				*result /= float64(count)
+			}
+			vals[i] = result
+		}
+		out.AppendRow(vals...)
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestDownsampleReducers(t *testing.T) {
+	tests := []struct {
+		mode string
+		want []*float64
+	}{
+		{"avg", floats(2.0, 5.0, nil)},
+		{"min", floats(1.0, 4.0, nil)},
+		{"max", floats(3.0, 6.0, nil)},
+	}
+	for _, tt := range tests {
+		frame := seriesFrame(floats(1.0, 3.0, 4.0, 6.0, nil, nil))
+		frames, notice := downsampleFrames([]*data.Frame{frame}, tt.mode, 3)
+		if notice == nil {
+			t.Errorf("%s: expected a downsampling notice", tt.mode)
+		}
+		got := fieldValues(frames[0].Fields[1])
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: got %d points, want %d", tt.mode, len(got), len(tt.want))
+		}
+		for i := range tt.want {
+			if deref(got[i]) != deref(tt.want[i]) {
+				t.Errorf("%s: value %d = %v, want %v", tt.mode, i, deref(got[i]), deref(tt.want[i]))
+			}
+		}
+		// 时间取每个桶的第一个点
+		if ts := frames[0].Fields[0].At(1).(time.Time); !ts.Equal(frame.Fields[0].At(2).(time.Time)) {
+			t.Errorf("%s: bucket time = %v", tt.mode, ts)
+		}
+	}
+}
+
+func TestDownsampleKeepsShortSeries(t *testing.T) {
+	frame := seriesFrame(floats(1.0, 2.0, 3.0))
+	frames, notice := downsampleFrames([]*data.Frame{frame}, "lttb", 3)
+	if notice != nil || frames[0] != frame {
+		t.Errorf("series within maxDataPoints should not be downsampled")
+	}
+}
+
+func TestParseDownsample(t *testing.T) {
+	if mode, err := parseDownsample(map[string]interface{}{}); err != nil || mode != "none" {
+		t.Errorf("default = %q, %v, want none", mode, err)
+	}
+	if mode, err := parseDownsample(map[string]interface{}{"downsample": "avg"}); err != nil || mode != "avg" {
+		t.Errorf("avg = %q, %v", mode, err)
+	}
+	if _, err := parseDownsample(map[string]interface{}{"downsample": "p99"}); err == nil {
+		t.Error("expected an error for p99")
+	}
+}
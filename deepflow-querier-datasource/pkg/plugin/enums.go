@@ -0,0 +1,208 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"deepflow-grafana-backend-plugin/pkg/querycache"
+)
+
+const defaultEnumCacheTTL = 10 * time.Minute
+
+var fromTableRe = regexp.MustCompile("(?i)\\bFROM\\s+`?([\\w.]+)`?")
+
+// 可以翻译的枚举 tag 类型
+var enumTagTypes = map[string]bool{"int_enum": true, "string_enum": true}
+
+// enumColumnName 翻译后的列名，与 SQL 中使用 Enum() 时 querier 返回的列名一致
+func enumColumnName(column string) string {
+	return "Enum(" + column + ")"
+}
+
+// enumTranslator 根据 tag 元数据翻译枚举 tag，show tags 及 show tag X values 的结果按 ttl 缓存
+type enumTranslator struct {
+	enabled bool
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]enumCacheEntry
+}
+
+type enumCacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newEnumTranslator(s DatasourceSettings) *enumTranslator {
+	ttl := time.Duration(s.EnumCacheTTL) * time.Second
+	if ttl <= 0 {
+		ttl = defaultEnumCacheTTL
+	}
+	return &enumTranslator{enabled: s.TranslateEnums, ttl: ttl, entries: map[string]enumCacheEntry{}}
+}
+
+// cached 读取缓存，未命中或过期时调用 load 并写入缓存，失败不缓存
+func (t *enumTranslator) cached(key string, load func() (interface{}, error)) (interface{}, error) {
+	t.mu.Lock()
+	e, ok := t.entries[key]
+	t.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.value, nil
+	}
+	v, err := load()
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	// 顺便清理过期项，避免已删除的表及 tag 一直占用内存
+	for k, e := range t.entries {
+		if now.After(e.expires) {
+			delete(t.entries, k)
+		}
+	}
+	t.entries[key] = enumCacheEntry{value: v, expires: now.Add(t.ttl)}
+	return v, nil
+}
+
+// queryTable 查询的表，优先使用 queryText 中的 from，否则从 sql 中解析
+func queryTable(queryText map[string]interface{}, sql string) string {
+	if from, ok := queryText["from"].(string); ok && from != "" {
+		return from
+	}
+	if m := fromTableRe.FindStringSubmatch(sql); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// rowsOf 按列名取 show 语句结果中的值
+func rowsOf(res *QuerierResponse) []map[string]interface{} {
+	rows := make([]map[string]interface{}, res.Values.Len())
+	for i := range rows {
+		rows[i] = make(map[string]interface{}, len(res.Values.Columns))
+		for _, c := range res.Values.Columns {
+			rows[i][c.Name] = c.Value(i)
+		}
+	}
+	return rows
+}
+
+// enumTags 表中的枚举 tag，列名 (包括 _0/_1 等客户端、服务端列名) -> tag 名
+func (t *enumTranslator) enumTags(ctx context.Context, client *querierClient, db, table, sources string) (map[string]string, error) {
+	key := querycache.Key("tags", client.QuerierURL(), client.token, db, table, sources)
+	v, err := t.cached(key, func() (interface{}, error) {
+		res, err := client.Query(ctx, QuerierRequest{Db: db, Sql: "show tags from " + table, DataPrecision: sources})
+		if err != nil {
+			return nil, err
+		}
+		tags := map[string]string{}
+		for _, row := range rowsOf(res) {
+			tagType, _ := row["type"].(string)
+			name, _ := row["name"].(string)
+			if !enumTagTypes[tagType] || name == "" {
+				continue
+			}
+			tags[name] = name
+			for _, k := range []string{"client_name", "server_name"} {
+				if n, ok := row[k].(string); ok && n != "" {
+					tags[n] = name
+				}
+			}
+		}
+		return tags, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]string), nil
+}
+
+// tagNames 表中所有可用于 WHERE 的 tag 列名，区分客户端、服务端的 tag 只有 _0/_1 等列名
+func (t *enumTranslator) tagNames(ctx context.Context, client *querierClient, db, table, sources string) (map[string]bool, error) {
+	key := querycache.Key("tag names", client.QuerierURL(), client.token, db, table, sources)
+	v, err := t.cached(key, func() (interface{}, error) {
+		res, err := client.Query(ctx, QuerierRequest{Db: db, Sql: "show tags from " + table, DataPrecision: sources})
+		if err != nil {
+			return nil, err
+		}
+		names := map[string]bool{}
+		for _, row := range rowsOf(res) {
+			name, _ := row["name"].(string)
+			clientName, _ := row["client_name"].(string)
+			serverName, _ := row["server_name"].(string)
+			for _, n := range []string{clientName, serverName} {
+				if n != "" {
+					names[n] = true
+				}
+			}
+			if name != "" && clientName == "" && serverName == "" {
+				names[name] = true
+			}
+		}
+		return names, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]bool), nil
+}
+
+// enumValues 枚举 tag 的值 -> 显示名称
+func (t *enumTranslator) enumValues(ctx context.Context, client *querierClient, db, table, sources, tag string) (map[string]string, error) {
+	key := querycache.Key("tag values", client.QuerierURL(), client.token, db, table, sources, tag)
+	v, err := t.cached(key, func() (interface{}, error) {
+		res, err := client.Query(ctx, QuerierRequest{Db: db, Sql: "show tag " + tag + " values from " + table, DataPrecision: sources})
+		if err != nil {
+			return nil, err
+		}
+		values := map[string]string{}
+		for _, row := range rowsOf(res) {
+			value, ok := row["value"]
+			if !ok || value == nil {
+				continue
+			}
+			if name, ok := row["display_name"]; ok && name != nil {
+				values[logValue(value)] = logValue(name)
+			}
+		}
+		return values, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]string), nil
+}
+
+// translations 结果中需要翻译的列，列名 -> 值 -> 显示名称，已经使用 Enum() 的列不再翻译
+func (t *enumTranslator) translations(ctx context.Context, client *querierClient, db, table, sources string, columns []string) (map[string]map[string]string, error) {
+	if table == "" {
+		return nil, fmt.Errorf("unknown table")
+	}
+	tags, err := t.enumTags(ctx, client, db, table, sources)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		existing[c] = true
+	}
+	result := map[string]map[string]string{}
+	for column := range existing {
+		tag, ok := tags[column]
+		if !ok || existing[enumColumnName(column)] || strings.HasPrefix(column, "Enum(") {
+			continue
+		}
+		values, err := t.enumValues(ctx, client, db, table, sources, tag)
+		if err != nil {
+			return nil, err
+		}
+		result[column] = values
+	}
+	return result, nil
+}
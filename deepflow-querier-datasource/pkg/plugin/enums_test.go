@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// TestEnumTranslationCached 枚举 tag 元数据只在第一次查询时请求
+func TestEnumTranslationCached(t *testing.T) {
+	c := loadGoldenCase(t, "testdata/cases/appTracing_table_enums.json")
+	server := newFakeDeepflowServer(t, c.Responses)
+	d := newTestDatasource(t, map[string]interface{}{"requestUrl": server.URL, "traceUrl": server.URL, "disableCache": true, "translateEnums": true})
+
+	queryText, _ := json.Marshal(c.QueryText)
+	c.Query["queryText"] = string(queryText)
+	queryJSON, _ := json.Marshal(c.Query)
+	query := backend.DataQuery{
+		RefID:     "A",
+		JSON:      queryJSON,
+		TimeRange: backend.TimeRange{From: time.Unix(c.From, 0), To: time.Unix(c.To, 0)},
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{query}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := resp.Responses["A"]; res.Error != nil {
+			t.Fatal(res.Error)
+		}
+	}
+
+	shows := 0
+	for _, r := range server.recorded() {
+		if strings.HasPrefix(r.form.Get("sql"), "show ") {
+			shows++
+		}
+	}
+	// show tags 及三个枚举 tag 的 show tag X values
+	if shows != 4 {
+		t.Errorf("got %d show requests, want 4", shows)
+	}
+}
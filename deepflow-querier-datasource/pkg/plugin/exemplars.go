@@ -0,0 +1,333 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
+	"deepflow-grafana-backend-plugin/pkg/formattools"
+)
+
+const (
+	// 查询 l7_flow_log 的采样行数上限
+	exemplarSampleLimit = 1000
+	// response_status 的取值个数，按时间桶及 response_status 分组时每个时间桶的最多行数
+	exemplarStatusValues = 5
+)
+
+var (
+	timeIntervalRe = regexp.MustCompile(`(?i)\btime\(\s*time\s*,\s*(\d+)\s*\)`)
+	whereClauseRe  = regexp.MustCompile(`(?is)\bWHERE\b(.*?)(?:\bGROUP\s+BY\b|\bORDER\s+BY\b|\bLIMIT\b|\bSLIMIT\b|$)`)
+	// 条件中与值比较的 tag，值中的字符串先去掉
+	conditionTagRe = regexp.MustCompile("(?i)`?([\\w.]+)`?\\s*(?:=|!=|<>|>=|<=|>|<|\\bNOT\\s+LIKE\\b|\\bLIKE\\b|\\bNOT\\s+IN\\b|\\bIN\\b|\\bNOT\\s+REGEXP\\b|\\bREGEXP\\b)")
+	quotedRe       = regexp.MustCompile(`'(?:[^'\\]|\\.)*'`)
+)
+
+// response_status 中的客户端及服务端异常
+func isErrorStatus(status interface{}) bool {
+	switch formattools.ValueToString(status) {
+	case "3", "4":
+		return true
+	}
+	return false
+}
+
+// splitConditions 按最外层的 AND 拆分 WHERE 条件，最外层有 OR 时不拆分
+func splitConditions(where string) []string {
+	conditions := []string{}
+	depth, start := 0, 0
+	quoted := false
+	upper := strings.ToUpper(where)
+	isWord := func(i, n int) bool {
+		before := i == 0 || !isIdentByte(where[i-1])
+		after := i+n >= len(where) || !isIdentByte(where[i+n])
+		return before && after
+	}
+	for i := 0; i < len(where); i++ {
+		switch c := where[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && strings.HasPrefix(upper[i:], "OR") && isWord(i, 2):
+			return []string{strings.TrimSpace(where)}
+		case depth == 0 && strings.HasPrefix(upper[i:], "AND") && isWord(i, 3):
+			conditions = append(conditions, strings.TrimSpace(where[start:i]))
+			start = i + 3
+			i += 2
+		}
+	}
+	return append(conditions, strings.TrimSpace(where[start:]))
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '.' || c == '`' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// sharedWhere 只保留 tag 都在 tags 中的条件，如 application 表中 l7_flow_log 没有的 tag 的条件被去掉
+func sharedWhere(where string, tags map[string]bool) string {
+	kept := []string{}
+	for _, condition := range splitConditions(where) {
+		matches := conditionTagRe.FindAllStringSubmatch(quotedRe.ReplaceAllString(condition, "''"), -1)
+		shared := len(matches) > 0
+		for _, m := range matches {
+			if m[1] != "time" && !tags[m[1]] {
+				shared = false
+				break
+			}
+		}
+		if shared {
+			kept = append(kept, condition)
+		}
+	}
+	if len(kept) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(kept, " AND ")
+}
+
+// exemplarQuery 由主查询得到的 l7_flow_log 查询
+type exemplarQuery struct {
+	interval int64
+	where    string
+}
+
+// newExemplarQuery 使用主查询中 l7_flow_log 也有的 tag 的 WHERE 条件，主查询不是 time(time, N) 分组时返回 false
+func newExemplarQuery(sql string, tags map[string]bool) (exemplarQuery, bool) {
+	m := timeIntervalRe.FindStringSubmatch(sql)
+	if m == nil {
+		return exemplarQuery{}, false
+	}
+	interval, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || interval <= 0 {
+		return exemplarQuery{}, false
+	}
+	q := exemplarQuery{interval: interval}
+	if w := whereClauseRe.FindStringSubmatch(sql); w != nil {
+		q.where = sharedWhere(w[1], tags)
+	}
+	return q, true
+}
+
+// bucketSQL 每个时间桶、每个 response_status 的最大耗时
+func (q exemplarQuery) bucketSQL(from, to int64) string {
+	limit := ((to-from)/q.interval + 2) * exemplarStatusValues
+	return fmt.Sprintf("SELECT time(time, %d) AS `bucket`, `response_status`, Max(`response_duration`) AS `max_duration` FROM `l7_flow_log`%s"+
+		" GROUP BY `bucket`, `response_status` LIMIT %d", q.interval, q.where, limit)
+}
+
+// sampleSQL 耗时为某个时间桶最大耗时的请求
+func (q exemplarQuery) sampleSQL(durations []float64) string {
+	values := make([]string, len(durations))
+	for i, d := range durations {
+		values[i] = strconv.FormatFloat(d, 'f', -1, 64)
+	}
+	where := " WHERE "
+	if q.where != "" {
+		where = q.where + " AND "
+	}
+	return "SELECT toString(_id), `trace_id`, `time`, `response_duration`, `response_status` FROM `l7_flow_log`" + where +
+		"`response_duration` IN (" + strings.Join(values, ", ") + ") ORDER BY `response_duration` DESC LIMIT " + strconv.Itoa(exemplarSampleLimit)
+}
+
+// exemplarTarget 时间桶中要找的请求：最慢的异常请求，没有时为最慢的请求
+type exemplarTarget struct {
+	duration float64
+	isError  bool
+}
+
+// exemplarTargets 按 bucketSQL 的结果确定每个时间桶的目标耗时
+func exemplarTargets(values *deepflowclient.Values) (map[int64]exemplarTarget, error) {
+	bucket, status, duration := values.Column("bucket"), values.Column("response_status"), values.Column("max_duration")
+	if values.Len() > 0 && (bucket == nil || status == nil || duration == nil) {
+		return nil, fmt.Errorf("the exemplar bucket query returns no bucket, response_status or max_duration column")
+	}
+	targets := map[int64]exemplarTarget{}
+	for i := 0; i < values.Len(); i++ {
+		b, ok := bucket.Float(i)
+		if !ok {
+			continue
+		}
+		d, ok := duration.Float(i)
+		if !ok {
+			continue
+		}
+		e := exemplarTarget{duration: d, isError: isErrorStatus(status.Value(i))}
+		cur, ok := targets[int64(b)]
+		if !ok || e.isError && !cur.isError || e.isError == cur.isError && e.duration > cur.duration {
+			targets[int64(b)] = e
+		}
+	}
+	return targets, nil
+}
+
+type exemplar struct {
+	time     int64
+	id       string
+	traceId  string
+	duration float64
+	status   string
+}
+
+// seriesValue 主查询各序列在 ts 时刻 (没有时取之前最近的点) 第一个 metric 的最大值，exemplar 显示在序列上
+func seriesValue(frames data.Frames, ts time.Time) *float64 {
+	var result *float64
+	for _, frame := range frames {
+		if frame.Meta != nil && frame.Meta.DataTopic == data.DataTopicAnnotations {
+			continue
+		}
+		timeField, valueField := -1, -1
+		for i, f := range frame.Fields {
+			switch {
+			case timeField < 0 && f.Type() == data.FieldTypeTime:
+				timeField = i
+			case valueField < 0 && f.Type() == data.FieldTypeNullableFloat64:
+				valueField = i
+			}
+		}
+		if timeField < 0 || valueField < 0 {
+			continue
+		}
+		var v *float64
+		for i := 0; i < frame.Fields[timeField].Len(); i++ {
+			if frame.Fields[timeField].At(i).(time.Time).After(ts) {
+				break
+			}
+			v = frame.Fields[valueField].At(i).(*float64)
+		}
+		if v != nil && (result == nil || *v > *result) {
+			result = v
+		}
+	}
+	return result
+}
+
+// queryExemplars 查询每个时间桶中最慢的异常请求，没有异常时为最慢的请求，返回 exemplar frame，
+// Value 为序列在该时间桶的值，response_duration 单独一列，_id 字段带有打开 appTracingFlame 的链接
+func (d *Datasource) queryExemplars(ctx context.Context, client *querierClient, req QuerierRequest, timeRange backend.TimeRange, frames data.Frames) (*data.Frame, error) {
+	if !timeIntervalRe.MatchString(req.Sql) {
+		return nil, fmt.Errorf("exemplars need a time series query grouped by time(time, N)")
+	}
+	ctx, span := startSpan(ctx, "deepflow.exemplars")
+	defer span.End()
+
+	tags, err := d.enums.tagNames(ctx, client, "flow_log", "l7_flow_log", "")
+	if err != nil {
+		return nil, fmt.Errorf("l7_flow_log tags: %w", err)
+	}
+	q, ok := newExemplarQuery(req.Sql, tags)
+	if !ok {
+		return nil, fmt.Errorf("exemplars need a time series query grouped by time(time, N)")
+	}
+	logRequest := func(sql string) QuerierRequest {
+		return QuerierRequest{Db: "flow_log", Sql: sql, Debug: req.Debug, From: req.From, To: req.To}
+	}
+
+	res, err := client.Query(ctx, logRequest(q.bucketSQL(req.From, req.To)))
+	if err != nil {
+		return nil, err
+	}
+	targets, err := exemplarTargets(res.Values)
+	if err != nil {
+		return nil, err
+	}
+	frame := data.NewFrame("exemplar",
+		data.NewField("Time", nil, []time.Time{}),
+		data.NewField("Value", nil, []*float64{}),
+		data.NewField("response_duration", nil, []float64{}),
+		data.NewField("trace_id", nil, []string{}),
+		data.NewField("_id", nil, []string{}),
+		data.NewField("response_status", nil, []string{}),
+	)
+	frame.Meta = &data.FrameMeta{DataTopic: data.DataTopicAnnotations}
+	frame.Fields[2].Config = &data.FieldConfig{Unit: "µs"}
+	frame.Fields[4].Config = &data.FieldConfig{
+		Links: []data.DataLink{d.flameLink("Open trace", "${__value.raw}", timeRange.From, timeRange.To)},
+	}
+	if len(targets) == 0 {
+		return frame, nil
+	}
+
+	durationSet := map[float64]bool{}
+	for _, t := range targets {
+		durationSet[t.duration] = true
+	}
+	durations := make([]float64, 0, len(durationSet))
+	for v := range durationSet {
+		durations = append(durations, v)
+	}
+	sort.Float64s(durations)
+	res, err = client.Query(ctx, logRequest(q.sampleSQL(durations)))
+	if err != nil {
+		return nil, err
+	}
+	values := res.Values
+	columns := map[string]*deepflowclient.Column{}
+	for _, name := range []string{"toString(_id)", "trace_id", "time", "response_duration", "response_status"} {
+		columns[name] = values.Column(name)
+		if columns[name] == nil && values.Len() > 0 {
+			return nil, fmt.Errorf("the exemplar query returns no %s column", name)
+		}
+	}
+
+	buckets := map[int64]exemplar{}
+	for i := 0; i < values.Len(); i++ {
+		ts, ok := columns["time"].Value(i).(json.Number)
+		if !ok {
+			continue
+		}
+		t, err := ts.Int64()
+		if err != nil {
+			continue
+		}
+		duration, ok := columns["response_duration"].Float(i)
+		if !ok {
+			continue
+		}
+		bucket := t - t%q.interval
+		target, ok := targets[bucket]
+		if !ok || duration != target.duration || target.isError && !isErrorStatus(columns["response_status"].Value(i)) {
+			continue
+		}
+		if _, ok := buckets[bucket]; ok {
+			continue
+		}
+		e := exemplar{
+			time:     t,
+			id:       "id-" + columns["toString(_id)"].Text(i),
+			duration: duration,
+			status:   columns["response_status"].Text(i),
+		}
+		if traceId, ok := columns["trace_id"].Value(i).(string); ok {
+			e.traceId = traceId
+		}
+		buckets[bucket] = e
+	}
+
+	bucketKeys := make([]int64, 0, len(buckets))
+	for k := range buckets {
+		bucketKeys = append(bucketKeys, k)
+	}
+	sort.Slice(bucketKeys, func(i, j int) bool { return bucketKeys[i] < bucketKeys[j] })
+	for _, k := range bucketKeys {
+		e := buckets[k]
+		frame.AppendRow(time.Unix(e.time, 0), seriesValue(frames, time.Unix(k, 0)), e.duration, e.traceId, e.id, e.status)
+	}
+	log.DefaultLogger.Debug("__________exemplars", "buckets", len(bucketKeys), "targets", len(targets), "sampled", values.Len())
+	return frame, nil
+}
@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestSplitConditions(t *testing.T) {
+	cases := map[string][]string{
+		"time >= 1 AND time <= 2":                                   {"time >= 1", "time <= 2"},
+		"a = 'x AND y' and (b = 1 AND c = 2) AND `d` IN ('1', '2')": {"a = 'x AND y'", "(b = 1 AND c = 2)", "`d` IN ('1', '2')"},
+		"a = 1 AND b = 2 OR c = 3":                                  {"a = 1 AND b = 2 OR c = 3"},
+		"brand = 1 AND order_id = 2":                                {"brand = 1", "order_id = 2"},
+		"a = 'it\\'s' AND b = 'or'":                                 {"a = 'it\\'s'", "b = 'or'"},
+	}
+	for where, want := range cases {
+		if got := splitConditions(where); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitConditions(%q) = %q, want %q", where, got, want)
+		}
+	}
+}
+
+func TestNewExemplarQuery(t *testing.T) {
+	tags := map[string]bool{"pod_service_1": true, "response_status": true, "l7_protocol": true}
+	cases := []struct {
+		sql   string
+		where string
+	}{
+		{
+			sql:   "SELECT time(time, 60) AS `t`, Avg(`rrt`) FROM `application.1m` WHERE time >= 1 AND time <= 2 AND `pod_service` = 'web' AND pod_service_1 = 'a' GROUP BY `t`",
+			where: " WHERE time >= 1 AND time <= 2 AND pod_service_1 = 'a'",
+		},
+		// 值中的字符串不当作 tag
+		{
+			sql:   "SELECT time(time, 60) AS `t` FROM `application.1m` WHERE `l7_protocol` IN ('x = 1') AND (response_status = 3 OR pod_cluster = 'c') GROUP BY `t`",
+			where: " WHERE `l7_protocol` IN ('x = 1')",
+		},
+		{sql: "SELECT time(time, 60) AS `t` FROM `application.1m` WHERE `pod` = 'p' GROUP BY `t`"},
+		{sql: "SELECT time(time, 60) AS `t` FROM `application.1m` GROUP BY `t`"},
+	}
+	for _, c := range cases {
+		q, ok := newExemplarQuery(c.sql, tags)
+		if !ok || q.interval != 60 || q.where != c.where {
+			t.Errorf("newExemplarQuery(%q) = %+v, %v, want where %q", c.sql, q, ok, c.where)
+		}
+	}
+	if _, ok := newExemplarQuery("SELECT Avg(`rrt`) FROM `application.1m`", tags); ok {
+		t.Error("exemplar query of a query without time(time, N)")
+	}
+	if got := (exemplarQuery{interval: 60}).sampleSQL([]float64{1200, 1.5}); got != "SELECT toString(_id), `trace_id`, `time`, `response_duration`, `response_status` FROM `l7_flow_log` WHERE `response_duration` IN (1200, 1.5) ORDER BY `response_duration` DESC LIMIT 1000" {
+		t.Errorf("sampleSQL = %s", got)
+	}
+}
+
+func TestExemplarTargets(t *testing.T) {
+	type n = json.Number
+	targets, err := exemplarTargets(testValues(t, []string{"bucket", "response_status", "max_duration"},
+		[]interface{}{n("60"), n("0"), n("900")},
+		[]interface{}{n("60"), n("4"), n("100")},
+		[]interface{}{n("60"), n("3"), n("200")},
+		[]interface{}{n("120"), n("0"), n("300")},
+		[]interface{}{n("120"), n("3"), nil},
+		[]interface{}{n("180"), n("0"), nil},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]exemplarTarget{60: {duration: 200, isError: true}, 120: {duration: 300}}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("targets = %v, want %v", targets, want)
+	}
+}
+
+func TestSeriesValue(t *testing.T) {
+	v := func(f float64) *float64 { return &f }
+	frames := data.Frames{
+		data.NewFrame("a", data.NewField("t", nil, []time.Time{time.Unix(60, 0), time.Unix(180, 0)}), data.NewField("v", nil, []*float64{v(1), v(5)})),
+		data.NewFrame("b", data.NewField("v", nil, []*float64{v(3), nil}), data.NewField("t", nil, []time.Time{time.Unix(60, 0), time.Unix(120, 0)})),
+	}
+	for ts, want := range map[int64]*float64{0: nil, 60: v(3), 120: v(1), 180: v(5)} {
+		got := seriesValue(frames, time.Unix(ts, 0))
+		if (got == nil) != (want == nil) || got != nil && *got != *want {
+			t.Errorf("seriesValue(%d) = %v, want %v", ts, got, want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package plugin
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"deepflow-grafana-backend-plugin/pkg/fixture"
+)
+
+// newFakeDeepflowServer 基于 httptest 的 deepflow-server，返回 testdata 中的querier、profile 及tracing数据，
+// 没有命中的请求返回 404 并使测试失败
+func newFakeDeepflowServer(t *testing.T, routes []fixture.Route) *recordingServer {
+	t.Helper()
+	f := &fixture.Fixture{Responses: routes}
+	handler := f.Handler(func(r *http.Request, _ []byte) {
+		t.Errorf("unexpected request to the fake deepflow-server: %s", r.URL.Path)
+	})
+	return newRecordingServer(t, func(w http.ResponseWriter, rec recordedRequest) {
+		req, _ := http.NewRequest(http.MethodPost, rec.path, strings.NewReader(rec.body))
+		req.Header = rec.header
+		handler.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,190 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// gapFill 分组时序的补点策略，按 time(time, N) 的间隔在对齐后的时间范围内补齐缺失的时间点
+type gapFill struct {
+	// null、zero、previous 或 linear，为空时不补点
+	policy string
+	// 去掉只覆盖了部分时间的首尾两个桶
+	trimPartial bool
+}
+
+func parseGapFill(queryText map[string]interface{}) (gapFill, error) {
+	g := gapFill{}
+	g.policy, _ = queryText["fill"].(string)
+	g.trimPartial, _ = queryText["trimPartialBuckets"].(bool)
+	switch g.policy {
+	case "", "null", "zero", "previous", "linear":
+	default:
+		return g, fmt.Errorf("invalid fill %q, use null, zero, previous or linear", g.policy)
+	}
+	return g, nil
+}
+
+func (g gapFill) enabled() bool {
+	return g.policy != "" || g.trimPartial
+}
+
+// bucketRange 对齐后第一个及最后一个桶的起点，phase 为桶起点相对整数倍间隔的偏移
+// trimPartial 时跳过不完整的首尾桶
+func (g gapFill) bucketRange(from, to time.Time, interval, phase int64) (int64, int64) {
+	align := func(ts int64) int64 {
+		return (ts-phase)/interval*interval + phase
+	}
+	first := align(from.Unix())
+	last := align(to.Unix())
+	if g.trimPartial {
+		if first < from.Unix() {
+			first += interval
+		}
+		if last+interval-1 > to.Unix() {
+			last -= interval
+		}
+	}
+	return first, last
+}
+
+// apply 对每条序列补点或裁剪，sql 不是 time(time, N) 分组时不处理
+func (g gapFill) apply(frames data.Frames, sql string, from, to time.Time, maxRows int) (data.Frames, *data.Notice) {
+	if !g.enabled() {
+		return frames, nil
+	}
+	m := timeIntervalRe.FindStringSubmatch(sql)
+	if m == nil {
+		return frames, nil
+	}
+	interval, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || interval <= 0 {
+		return frames, nil
+	}
+	first, last := g.bucketRange(from, to, interval, 0)
+	if g.policy != "" && (last-first)/interval+1 > int64(maxRows) {
+		return frames, &data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("fill skipped: more than %d points per series, use a larger interval", maxRows),
+		}
+	}
+	for i, frame := range frames {
+		if frame.Rows() == 0 {
+			continue
+		}
+		if filled := g.fillFrame(frame, from, to, interval); filled != nil {
+			frames[i] = filled
+		}
+	}
+	return frames, nil
+}
+
+// fillFrame 生成补点后的新 frame，序列已按时间排序，没有时间字段时返回 nil
+func (g gapFill) fillFrame(frame *data.Frame, from, to time.Time, interval int64) *data.Frame {
+	timeIndex := -1
+	for i, field := range frame.Fields {
+		if field.Type() == data.FieldTypeTime {
+			timeIndex = i
+			break
+		}
+	}
+	if timeIndex < 0 {
+		return nil
+	}
+	times := make([]int64, frame.Rows())
+	rows := make(map[int64]int, frame.Rows())
+	for i := range times {
+		times[i] = frame.Fields[timeIndex].At(i).(time.Time).Unix()
+		rows[times[i]] = i
+	}
+	// 桶的起点以返回数据为准，如按天分组时受时区影响
+	phase := (times[0]%interval + interval) % interval
+	first, last := g.bucketRange(from, to, interval, phase)
+
+	// 每个字段在各行之前及之后最近的非空点，用于 previous 及 linear
+	prevValid := make([][]int, len(frame.Fields))
+	nextValid := make([][]int, len(frame.Fields))
+	for i, field := range frame.Fields {
+		if field.Type() != data.FieldTypeNullableFloat64 {
+			continue
+		}
+		prevValid[i], nextValid[i] = make([]int, len(times)), make([]int, len(times))
+		p := -1
+		for j := range times {
+			if field.At(j).(*float64) != nil {
+				p = j
+			}
+			prevValid[i][j] = p
+		}
+		n := -1
+		for j := len(times) - 1; j >= 0; j-- {
+			if field.At(j).(*float64) != nil {
+				n = j
+			}
+			nextValid[i][j] = n
+		}
+	}
+
+	filled := copyFrameSchema(frame)
+	// next 为第一个时间大于 ts 的行
+	next := 0
+	for ts := first; ts <= last; ts += interval {
+		for next < len(times) && times[next] <= ts {
+			next++
+		}
+		row, ok := rows[ts]
+		if !ok && g.policy == "" {
+			continue
+		}
+		vals := make([]interface{}, len(frame.Fields))
+		for i, field := range frame.Fields {
+			switch {
+			case ok:
+				vals[i] = field.CopyAt(row)
+			case i == timeIndex:
+				vals[i] = time.Unix(ts, 0)
+			case field.Type() == data.FieldTypeNullableFloat64:
+				prev, after := -1, -1
+				if next > 0 {
+					prev = prevValid[i][next-1]
+				}
+				if next < len(times) {
+					after = nextValid[i][next]
+				}
+				vals[i] = g.fillValue(field, times, prev, after, ts)
+			default:
+				// tag 等字段在同一序列中取值相同
+				vals[i] = field.CopyAt(0)
+			}
+		}
+		filled.AppendRow(vals...)
+	}
+	return filled
+}
+
+// fillValue 缺失时间点 ts 的值，prev、next 为之前及之后最近的非空行，不存在时为 -1
+func (g gapFill) fillValue(field *data.Field, times []int64, prev, next int, ts int64) *float64 {
+	switch g.policy {
+	case "zero":
+		v := 0.0
+		return &v
+	case "previous", "linear":
+		if prev < 0 {
+			return nil
+		}
+		pv := *field.At(prev).(*float64)
+		if g.policy == "previous" {
+			return &pv
+		}
+		if next < 0 {
+			return nil
+		}
+		nv := *field.At(next).(*float64)
+		v := pv + (nv-pv)*float64(ts-times[prev])/float64(times[next]-times[prev])
+		return &v
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func gapFillFrame(times []int64, values []*float64) *data.Frame {
+	ts := make([]time.Time, len(times))
+	tags := make([]string, len(times))
+	for i, t := range times {
+		ts[i] = time.Unix(t, 0)
+		tags[i] = "a"
+	}
+	return data.NewFrame("", data.NewField("time", nil, ts), data.NewField("value", nil, values), data.NewField("tag", nil, tags))
+}
+
+func TestGapFill(t *testing.T) {
+	const minuteSQL = "SELECT time(time, 60) AS `t`, Sum(byte) FROM network GROUP BY `t`"
+	const daySQL = "SELECT time(time, 86400) AS `t`, Sum(byte) FROM network GROUP BY `t`"
+	// 按 +08:00 的天分组，桶的起点为 UTC 16:00
+	const day0 = int64(1700064000)
+	const day = int64(86400)
+
+	cases := []struct {
+		name       string
+		g          gapFill
+		sql        string
+		from, to   int64
+		times      []int64
+		values     []*float64
+		wantTimes  []int64
+		wantValues []*float64
+	}{
+		{
+			name: "null", g: gapFill{policy: "null"}, sql: minuteSQL, from: 600, to: 899,
+			times: []int64{660, 780}, values: floats(1.0, 3.0),
+			wantTimes: []int64{600, 660, 720, 780, 840}, wantValues: floats(nil, 1.0, nil, 3.0, nil),
+		},
+		{
+			name: "zero", g: gapFill{policy: "zero"}, sql: minuteSQL, from: 600, to: 899,
+			times: []int64{660, 780}, values: floats(1.0, 3.0),
+			wantTimes: []int64{600, 660, 720, 780, 840}, wantValues: floats(0.0, 1.0, 0.0, 3.0, 0.0),
+		},
+		{
+			name: "previous", g: gapFill{policy: "previous"}, sql: minuteSQL, from: 600, to: 899,
+			times: []int64{660, 780}, values: floats(1.0, 3.0),
+			wantTimes: []int64{600, 660, 720, 780, 840}, wantValues: floats(nil, 1.0, 1.0, 3.0, 3.0),
+		},
+		{
+			name: "linear", g: gapFill{policy: "linear"}, sql: minuteSQL, from: 600, to: 899,
+			times: []int64{660, 840}, values: floats(1.0, 4.0),
+			wantTimes: []int64{600, 660, 720, 780, 840}, wantValues: floats(nil, 1.0, 2.0, 3.0, 4.0),
+		},
+		// 返回的空值不补
+		{
+			name: "null values are kept", g: gapFill{policy: "previous"}, sql: minuteSQL, from: 600, to: 719,
+			times: []int64{600, 660}, values: floats(1.0, nil),
+			wantTimes: []int64{600, 660}, wantValues: floats(1.0, nil),
+		},
+		{
+			name: "day with time zone", g: gapFill{policy: "zero"}, sql: daySQL, from: day0, to: day0 + 3*day - 1,
+			times: []int64{day0, day0 + 2*day}, values: floats(1.0, 3.0),
+			wantTimes: []int64{day0, day0 + day, day0 + 2*day}, wantValues: floats(1.0, 0.0, 3.0),
+		},
+		{
+			name: "day with time zone, partial first day", g: gapFill{policy: "zero"}, sql: daySQL, from: day0 - 3600, to: day0 + 2*day,
+			times: []int64{day0, day0 + 2*day}, values: floats(1.0, 3.0),
+			wantTimes: []int64{day0 - day, day0, day0 + day, day0 + 2*day}, wantValues: floats(0.0, 1.0, 0.0, 3.0),
+		},
+		{
+			name: "day with time zone, trim partial", g: gapFill{policy: "zero", trimPartial: true}, sql: daySQL, from: day0 - 3600, to: day0 + 2*day,
+			times: []int64{day0, day0 + 2*day}, values: floats(1.0, 3.0),
+			wantTimes: []int64{day0, day0 + day}, wantValues: floats(1.0, 0.0),
+		},
+		// 起点、终点正好在桶的边界时首尾桶是完整的
+		{
+			name: "trim partial, complete edges", g: gapFill{trimPartial: true}, sql: minuteSQL, from: 600, to: 899,
+			times: []int64{600, 660, 720, 780, 840}, values: floats(1.0, 2.0, 3.0, 4.0, 5.0),
+			wantTimes: []int64{600, 660, 720, 780, 840}, wantValues: floats(1.0, 2.0, 3.0, 4.0, 5.0),
+		},
+		{
+			name: "trim partial, partial edges", g: gapFill{trimPartial: true}, sql: minuteSQL, from: 601, to: 898,
+			times: []int64{600, 660, 720, 780, 840}, values: floats(1.0, 2.0, 3.0, 4.0, 5.0),
+			wantTimes: []int64{660, 720, 780}, wantValues: floats(2.0, 3.0, 4.0),
+		},
+		// 不补点时缺失的桶仍然缺失
+		{
+			name: "trim partial without fill", g: gapFill{trimPartial: true}, sql: minuteSQL, from: 600, to: 840,
+			times: []int64{600, 720, 840}, values: floats(1.0, 3.0, 5.0),
+			wantTimes: []int64{600, 720}, wantValues: floats(1.0, 3.0),
+		},
+		{
+			name: "not grouped by time", g: gapFill{policy: "zero"}, sql: "SELECT Sum(byte) FROM network", from: 600, to: 899,
+			times: []int64{660}, values: floats(1.0),
+			wantTimes: []int64{660}, wantValues: floats(1.0),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			frames, notice := c.g.apply(data.Frames{gapFillFrame(c.times, c.values)}, c.sql, time.Unix(c.from, 0), time.Unix(c.to, 0), 100)
+			if notice != nil {
+				t.Fatalf("notice: %s", notice.Text)
+			}
+			frame := frames[0]
+			gotTimes := make([]int64, frame.Rows())
+			for i := range gotTimes {
+				gotTimes[i] = frame.Fields[0].At(i).(time.Time).Unix()
+				if tag := frame.Fields[2].At(i).(string); tag != "a" {
+					t.Errorf("row %d: tag = %q", i, tag)
+				}
+			}
+			if !reflect.DeepEqual(gotTimes, c.wantTimes) {
+				t.Fatalf("times = %v, want %v", gotTimes, c.wantTimes)
+			}
+			got := fieldValues(frame.Fields[1])
+			for i := range c.wantValues {
+				if deref(got[i]) != deref(c.wantValues[i]) {
+					t.Errorf("value %d = %v, want %v", i, deref(got[i]), deref(c.wantValues[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestGapFillMaxRows(t *testing.T) {
+	frame := gapFillFrame([]int64{660}, floats(1.0))
+	frames, notice := gapFill{policy: "zero"}.apply(data.Frames{frame},
+		"SELECT time(time, 60) AS `t` FROM network GROUP BY `t`", time.Unix(600, 0), time.Unix(899, 0), 4)
+	if notice == nil || notice.Severity != data.NoticeSeverityWarning {
+		t.Errorf("notice = %v, want a warning", notice)
+	}
+	if frames[0] != frame || frame.Rows() != 1 {
+		t.Error("series are filled past maxRows")
+	}
+}
+
+func TestParseGapFill(t *testing.T) {
+	g, err := parseGapFill(map[string]interface{}{"fill": "linear", "trimPartialBuckets": true})
+	if err != nil || g != (gapFill{policy: "linear", trimPartial: true}) || !g.enabled() {
+		t.Errorf("parseGapFill = %+v, %v", g, err)
+	}
+	if g, _ := parseGapFill(map[string]interface{}{}); g.enabled() {
+		t.Error("empty fill is enabled")
+	}
+	if _, err := parseGapFill(map[string]interface{}{"fill": "next"}); err == nil {
+		t.Error("unknown fill is accepted")
+	}
+}
@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+
+	"deepflow-grafana-backend-plugin/pkg/fixture"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata/golden")
+
+// goldenCase testdata/cases 中的一个用例：数据源配置、面板提交的查询及 deepflow-server 的返回
+type goldenCase struct {
+	Settings  map[string]interface{} `json:"settings"`
+	Query     map[string]interface{} `json:"query"`
+	QueryText map[string]interface{} `json:"queryText"`
+	From      int64                  `json:"from"`
+	To        int64                  `json:"to"`
+	// 为 0 时不限制
+	MaxDataPoints int64           `json:"maxDataPoints"`
+	Responses     []fixture.Route `json:"responses"`
+}
+
+func loadGoldenCase(t *testing.T, path string) goldenCase {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c goldenCase
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return c
+}
+
+// TestGoldenFrames 对比每种 appType 与 formatAs 组合转换出的 frame，
+// 修改转换逻辑后用 go test ./pkg/plugin -run TestGoldenFrames -update 更新
+func TestGoldenFrames(t *testing.T) {
+	paths, err := filepath.Glob("testdata/cases/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no golden cases found")
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			c := loadGoldenCase(t, path)
+			server := newFakeDeepflowServer(t, c.Responses)
+			jsonData := map[string]interface{}{
+				"requestUrl":   server.URL,
+				"traceUrl":     server.URL,
+				"disableCache": true,
+			}
+			for k, v := range c.Settings {
+				jsonData[k] = v
+			}
+			d := newTestDatasource(t, jsonData)
+
+			queryText, err := json.Marshal(c.QueryText)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Query["queryText"] = string(queryText)
+			queryJSON, err := json.Marshal(c.Query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+				Queries: []backend.DataQuery{{
+					RefID:         "A",
+					JSON:          queryJSON,
+					MaxDataPoints: c.MaxDataPoints,
+					TimeRange:     backend.TimeRange{From: time.Unix(c.From, 0), To: time.Unix(c.To, 0)},
+				}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := resp.Responses["A"]
+			if res.Error != nil {
+				// SDK 生成的错误响应 golden 文件无法再读取，错误单独对比
+				checkGoldenError(t, filepath.Join("testdata/golden", name+".txt"), res)
+				return
+			}
+			clearTimingStats(res)
+			experimental.CheckGoldenJSONResponse(t, "testdata/golden", name, &res, *updateGolden)
+		})
+	}
+}
+
+func checkGoldenError(t *testing.T, path string, res backend.DataResponse) {
+	t.Helper()
+	actual := fmt.Sprintf("status: %d\nerror: %s\n", res.Status, res.Error.Error())
+	if *updateGolden {
+		if err := os.WriteFile(path, []byte(actual), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(expected) != actual {
+		t.Errorf("error response differs from %s\nwant: %s\ngot:  %s", path, expected, actual)
+	}
+}
+
+// clearTimingStats 耗时每次运行都不同，对比前置为 0
+func clearTimingStats(res backend.DataResponse) {
+	for _, frame := range res.Frames {
+		if frame.Meta == nil {
+			continue
+		}
+		for i := range frame.Meta.Stats {
+			if frame.Meta.Stats[i].Unit == "ms" {
+				frame.Meta.Stats[i].Value = 0
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+var (
+	// time 的下限过滤，包括 time >= ${__from} 及 time BETWEEN
+	timeFilterRe = regexp.MustCompile("(?i)`?\\btime`?\\s*(>=|>|between\\b)")
+	limitRe      = regexp.MustCompile(`(?i)\bLIMIT\s+(\d+)(?:\s*,\s*(\d+))?`)
+	slimitRe     = regexp.MustCompile(`(?i)\bSLIMIT\s+(\d+)`)
+	tablesRe     = regexp.MustCompile("(?i)\\b(?:FROM|JOIN)\\s+`?([\\w.]+)`?")
+	identRe      = regexp.MustCompile(`[A-Za-z_][\w.]*`)
+	stringRe     = regexp.MustCompile(`'(?:[^'\\]|\\.)*'`)
+	whereRe      = regexp.MustCompile(`(?is)\bWHERE\b(.*?)(?:\bGROUP\s+BY\b|\bHAVING\b|\bORDER\s+BY\b|\bSLIMIT\b|\bLIMIT\b|$)`)
+	// 表名中的数据精度，如 network.1m
+	tablePrecisionRe = regexp.MustCompile(`\.(1s|1m|1h|1d)$`)
+)
+
+// guardrails 查询保护，拒绝没有时间过滤、LIMIT 过大、时间范围过长或访问被禁止的表及列的查询
+type guardrails struct {
+	requireTimeFilter bool
+	maxLimit          int64
+	maxSlimit         int64
+	// 数据精度 -> 最长时间范围，* 为其他精度
+	maxTimeRange     map[string]time.Duration
+	blockedTables    map[string]bool
+	blockedColumns   map[string]bool
+	maxEstimatedRows int64
+	// 时间范围不超过该值时不预估
+	estimateMinTimeRange time.Duration
+}
+
+func newGuardrails(s DatasourceSettings) (guardrails, error) {
+	g := guardrails{
+		requireTimeFilter:    s.RequireTimeFilter,
+		maxLimit:             int64(s.MaxLimit),
+		maxSlimit:            int64(s.MaxSlimit),
+		maxTimeRange:         map[string]time.Duration{},
+		blockedTables:        splitSet(s.BlockedTables),
+		blockedColumns:       splitSet(s.BlockedColumns),
+		maxEstimatedRows:     int64(s.MaxEstimatedRows),
+		estimateMinTimeRange: time.Duration(s.EstimateMinTimeRange) * time.Second,
+	}
+	for _, item := range strings.Split(s.MaxTimeRange, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		precision, seconds, ok := strings.Cut(item, "=")
+		v, err := strconv.ParseInt(strings.TrimSpace(seconds), 10, 64)
+		if !ok || err != nil || v <= 0 {
+			return g, fmt.Errorf("invalid maxTimeRange %q, use <precision>=<seconds> such as 1s=3600", item)
+		}
+		g.maxTimeRange[strings.TrimSpace(precision)] = time.Duration(v) * time.Second
+	}
+	return g, nil
+}
+
+func splitSet(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+// dataPrecision 查询的数据精度，优先使用 queryText 中的 sources，否则取表名的后缀
+func dataPrecision(sources, sql string) string {
+	if sources != "" {
+		return sources
+	}
+	if m := fromTableRe.FindStringSubmatch(sql); m != nil {
+		if p := tablePrecisionRe.FindStringSubmatch(m[1]); p != nil {
+			return p[1]
+		}
+	}
+	return ""
+}
+
+// tableBlocked 被禁止的表可以写为 table、db.table，或不带精度的 network 表示所有精度
+func (g guardrails) tableBlocked(db, table string) bool {
+	base := tablePrecisionRe.ReplaceAllString(table, "")
+	for _, name := range []string{table, base, db + "." + table, db + "." + base} {
+		if g.blockedTables[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// check 在请求 deepflow-server 前检查查询，appTracingFlame 的 sql 由后端拼接，不检查
+func (g guardrails) check(queryJSON json.RawMessage, timeRange backend.TimeRange) error {
+	var qj struct {
+		Sql       string `json:"sql"`
+		QueryText string `json:"queryText"`
+	}
+	var queryText struct {
+		AppType string `json:"appType"`
+		Db      string `json:"db"`
+		Sources string `json:"sources"`
+	}
+	if json.Unmarshal(queryJSON, &qj) != nil || json.Unmarshal([]byte(qj.QueryText), &queryText) != nil {
+		return nil
+	}
+	sql := qj.Sql
+	if sql == "" || queryText.AppType == "appTracingFlame" {
+		return nil
+	}
+
+	// 字符串中的内容不作为表名及列名
+	stripped := stringRe.ReplaceAllString(sql, "''")
+	for _, m := range tablesRe.FindAllStringSubmatch(stripped, -1) {
+		if g.tableBlocked(queryText.Db, m[1]) {
+			return fmt.Errorf("query rejected: table %s is blocked on this datasource", m[1])
+		}
+	}
+	if len(g.blockedColumns) > 0 {
+		for _, ident := range identRe.FindAllString(stripped, -1) {
+			if g.blockedColumns[ident] {
+				return fmt.Errorf("query rejected: column %s is blocked on this datasource", ident)
+			}
+		}
+	}
+
+	precision := dataPrecision(queryText.Sources, sql)
+	maxRange, ok := g.maxTimeRange[precision]
+	if !ok {
+		maxRange = g.maxTimeRange["*"]
+	}
+	if span := timeRange.To.Sub(timeRange.From); maxRange > 0 && span > maxRange {
+		if precision == "" {
+			precision = "default"
+		}
+		return fmt.Errorf("query rejected: time range %s exceeds the maximum %s for %s data, shorten the time range or use a coarser data precision",
+			span.Round(time.Second), maxRange, precision)
+	}
+
+	// profiling 的 sql 中没有时间过滤及 LIMIT
+	if queryText.AppType == "profiling" {
+		return nil
+	}
+	if g.requireTimeFilter && !timeFilterRe.MatchString(stripped) {
+		return fmt.Errorf("query rejected: a time filter is required, add time >= ${__from:date:seconds} to WHERE")
+	}
+	if g.maxLimit > 0 {
+		matches := limitRe.FindAllStringSubmatch(stripped, -1)
+		if len(matches) == 0 {
+			return fmt.Errorf("query rejected: LIMIT is required, at most %d", g.maxLimit)
+		}
+		// 外层查询的 LIMIT 在最后，LIMIT offset, count 时取 count
+		m := matches[len(matches)-1]
+		limit := m[1]
+		if m[2] != "" {
+			limit = m[2]
+		}
+		if v, _ := strconv.ParseInt(limit, 10, 64); v > g.maxLimit {
+			return fmt.Errorf("query rejected: LIMIT %d exceeds the maximum %d", v, g.maxLimit)
+		}
+	}
+	if g.maxSlimit > 0 {
+		for _, m := range slimitRe.FindAllStringSubmatch(stripped, -1) {
+			if v, _ := strconv.ParseInt(m[1], 10, 64); v > g.maxSlimit {
+				return fmt.Errorf("query rejected: SLIMIT %d exceeds the maximum %d", v, g.maxSlimit)
+			}
+		}
+	}
+	return nil
+}
+
+// estimateSQL 与查询相同表及 WHERE 条件的 Count(row)，无法解析表名或 WHERE 中没有 time 的下限时返回空，
+// 此时 Count(row) 会扫描整张表，比查询本身更慢
+func estimateSQL(sql string) string {
+	m := fromTableRe.FindStringSubmatch(sql)
+	if m == nil {
+		return ""
+	}
+	w := whereRe.FindStringSubmatch(sql)
+	if w == nil || !timeFilterRe.MatchString(stringRe.ReplaceAllString(w[1], "''")) {
+		return ""
+	}
+	return "SELECT Count(row) AS `count` FROM `" + m[1] + "` WHERE " + strings.TrimSpace(w[1])
+}
+
+// checkEstimatedRows 查询前估算需要扫描的行数，超过 maxEstimatedRows 时拒绝，估算失败时返回提示继续查询
+// 估算是一次额外的请求，时间范围不超过 estimateMinTimeRange 的查询不估算
+func (d *Datasource) checkEstimatedRows(ctx context.Context, client *querierClient, appType string, req QuerierRequest) (string, error) {
+	if d.guardrails.maxEstimatedRows <= 0 || appType == "profiling" {
+		return "", nil
+	}
+	if time.Duration(req.To-req.From)*time.Second <= d.guardrails.estimateMinTimeRange {
+		return "", nil
+	}
+	req.Sql = estimateSQL(req.Sql)
+	if req.Sql == "" {
+		return "", nil
+	}
+	res, err := d.querier(ctx, client, appType, req)
+	if err != nil {
+		return "row estimate failed: " + err.Error(), nil
+	}
+	rows := rowsOf(res)
+	if len(rows) == 0 {
+		return "", nil
+	}
+	n, ok := rows[0]["count"].(json.Number)
+	if !ok {
+		return fmt.Sprintf("row estimate failed: unexpected count %v", rows[0]["count"]), nil
+	}
+	count, err := n.Int64()
+	if err != nil {
+		f, _ := n.Float64()
+		count = int64(f)
+	}
+	if count > d.guardrails.maxEstimatedRows {
+		return "", fmt.Errorf("query rejected: about %d rows to scan, more than the maximum %d, add filters or shorten the time range",
+			count, d.guardrails.maxEstimatedRows)
+	}
+	return "", nil
+}
@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"deepflow-grafana-backend-plugin/pkg/fixture"
+)
+
+func TestGuardrailsCheck(t *testing.T) {
+	g, err := newGuardrails(DatasourceSettings{
+		RequireTimeFilter: true,
+		MaxLimit:          1000,
+		MaxSlimit:         20,
+		MaxTimeRange:      "1s=3600, *=604800",
+		BlockedTables:     "flow_log.l4_flow_log, network_map",
+		BlockedColumns:    "request_resource",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hour := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+	day := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(86400, 0)}
+	const where = " WHERE time >= '${__from:date:seconds}' AND time <= '${__to:date:seconds}'"
+
+	tests := []struct {
+		name      string
+		sql       string
+		db        string
+		sources   string
+		appType   string
+		timeRange backend.TimeRange
+		err       string
+	}{
+		{name: "ok", sql: "SELECT Sum(byte) FROM `network.1m`" + where + " LIMIT 100", timeRange: day},
+		{name: "empty sql", sql: "", timeRange: day},
+		{name: "no time filter", sql: "SELECT Sum(byte) FROM `network.1m` WHERE start_time > 0 LIMIT 100", timeRange: hour,
+			err: "query rejected: a time filter is required"},
+		{name: "no limit", sql: "SELECT Sum(byte) FROM `network.1m`" + where, timeRange: hour,
+			err: "query rejected: LIMIT is required, at most 1000"},
+		{name: "limit too large", sql: "SELECT Sum(byte) FROM `network.1m`" + where + " LIMIT 5000", timeRange: hour,
+			err: "query rejected: LIMIT 5000 exceeds the maximum 1000"},
+		{name: "limit offset, count", sql: "SELECT Sum(byte) FROM `network.1m`" + where + " LIMIT 10, 2000", timeRange: hour,
+			err: "query rejected: LIMIT 2000 exceeds the maximum 1000"},
+		{name: "slimit too large", sql: "SELECT Sum(byte) FROM `network.1m`" + where + " SLIMIT 50 LIMIT 100", timeRange: hour,
+			err: "query rejected: SLIMIT 50 exceeds the maximum 20"},
+		{name: "time range per precision", sql: "SELECT Sum(byte) FROM `network.1s`" + where + " LIMIT 100", timeRange: day,
+			err: "query rejected: time range 24h0m0s exceeds the maximum 1h0m0s for 1s data"},
+		{name: "time range from sources", sql: "SELECT Sum(byte) FROM `network`" + where + " LIMIT 100", sources: "1s", timeRange: day,
+			err: "query rejected: time range 24h0m0s exceeds the maximum 1h0m0s for 1s data"},
+		{name: "blocked db.table", sql: "SELECT * FROM l4_flow_log" + where + " LIMIT 100", db: "flow_log", timeRange: hour,
+			err: "query rejected: table l4_flow_log is blocked"},
+		{name: "blocked table of any precision", sql: "SELECT Sum(byte) FROM `network_map.1m`" + where + " LIMIT 100", timeRange: hour,
+			err: "query rejected: table network_map.1m is blocked"},
+		{name: "blocked column", sql: "SELECT `request_resource` FROM l7_flow_log" + where + " LIMIT 100", timeRange: hour,
+			err: "query rejected: column request_resource is blocked"},
+		{name: "blocked column in a string", sql: "SELECT Count(row) FROM l7_flow_log" + where + " AND endpoint = 'request_resource' LIMIT 100", timeRange: hour},
+		{name: "profiling", sql: "SELECT function FROM in_process", appType: "profiling", timeRange: hour},
+		{name: "flame", sql: "SELECT request_type FROM l7_flow_log", appType: "appTracingFlame", timeRange: day},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appType := tt.appType
+			if appType == "" {
+				appType = "trafficQuery"
+			}
+			queryText, _ := json.Marshal(map[string]interface{}{"appType": appType, "db": tt.db, "sources": tt.sources})
+			queryJSON, _ := json.Marshal(map[string]interface{}{"queryText": string(queryText), "sql": tt.sql})
+			err := g.check(queryJSON, tt.timeRange)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEstimateSQL(t *testing.T) {
+	got := estimateSQL("SELECT time(time, 60) AS `t`, Sum(`byte`) FROM `network.1m` WHERE time >= 1 AND pod = 'a' GROUP BY `t` LIMIT 100")
+	want := "SELECT Count(row) AS `count` FROM `network.1m` WHERE time >= 1 AND pod = 'a'"
+	if got != want {
+		t.Errorf("estimateSQL = %q, want %q", got, want)
+	}
+	// 没有 time 的下限时 Count(row) 扫描整张表，不预估
+	for _, sql := range []string{
+		"SELECT `pod` FROM `network.1m` LIMIT 100",
+		"SELECT `pod` FROM `network.1m` WHERE pod = 'time >= 1' LIMIT 100",
+		"SELECT `pod` FROM `network.1m` WHERE time <= 100 LIMIT 100",
+	} {
+		if got := estimateSQL(sql); got != "" {
+			t.Errorf("estimateSQL(%q) = %q, want no estimate", sql, got)
+		}
+	}
+}
+
+// TestEstimatedRowsRejected 预估行数超过上限时不请求查询本身
+func TestEstimatedRowsRejected(t *testing.T) {
+	c := loadGoldenCase(t, "testdata/cases/trafficQuery_table.json")
+	estimate := fixture.Route{
+		Path:  "/v1/query/",
+		Match: "Count(row)",
+		Body:  json.RawMessage(`{"OPT_STATUS":"SUCCESS","DESCRIPTION":"","result":{"columns":["count"],"values":[[2000000]]}}`),
+	}
+	server := newFakeDeepflowServer(t, append([]fixture.Route{estimate}, c.Responses...))
+	d := newTestDatasource(t, map[string]interface{}{
+		"requestUrl":       server.URL,
+		"traceUrl":         server.URL,
+		"disableCache":     true,
+		"maxEstimatedRows": 1000000,
+	})
+
+	res := queryGoldenCase(t, d, c)
+	if res.Error == nil || !strings.HasPrefix(res.Error.Error(), "query rejected: about 2000000 rows to scan") {
+		t.Fatalf("error = %v, want the row estimate to reject the query", res.Error)
+	}
+	if n := len(server.recorded()); n != 1 {
+		t.Errorf("got %d requests, want only the row estimate", n)
+	}
+
+	d.guardrails.maxEstimatedRows = 3000000
+	if res = queryGoldenCase(t, d, c); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	// 时间范围不超过 estimateMinTimeRange 时不请求预估
+	d.guardrails.maxEstimatedRows = 1000000
+	d.guardrails.estimateMinTimeRange = time.Duration(c.To-c.From) * time.Second
+	before := len(server.recorded())
+	if res = queryGoldenCase(t, d, c); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	for _, r := range server.recorded()[before:] {
+		if strings.Contains(r.form.Get("sql"), "Count(row)") {
+			t.Errorf("row estimate requested for a short time range: %s", r.form.Get("sql"))
+		}
+	}
+}
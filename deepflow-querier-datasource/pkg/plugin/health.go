@@ -0,0 +1,238 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
+)
+
+const serverVersionPath = "/v1/version/"
+
+// healthKeyTables 数据源测试时检查的库表，表名为空表示只检查库
+var healthKeyTables = []struct {
+	db    string
+	table string
+}{
+	{db: "flow_metrics"},
+	{db: "flow_log", table: "l7_flow_log"},
+	{db: "profile"},
+}
+
+// healthDetails 数据源测试的诊断信息，通过 JSONDetails 返回给页面
+type healthDetails struct {
+	RequestUrl string `json:"requestUrl"`
+	Version    string `json:"version,omitempty"`
+	// 获取版本失败的原因，部分 deepflow-server 版本没有版本接口
+	VersionError string          `json:"versionError,omitempty"`
+	Databases    []string        `json:"databases"`
+	Tables       map[string]bool `json:"tables"`
+	Trace        *traceHealth    `json:"trace,omitempty"`
+	Errors       []string        `json:"errors,omitempty"`
+}
+
+type traceHealth struct {
+	Url       string `json:"url"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+// CheckHealth 检查querier接口，包括版本、token 可见的库表，以及tracing接口是否可达
+func (d *Datasource) CheckHealth(ctx context.Context, _ *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	dsSettings, err := loadSettings(d.settings)
+	if err != nil {
+		return newHealthCheckErrorf(err.Error()), nil
+	}
+	if dsSettings.RequestUrl == "" {
+		return newHealthCheckErrorf("Missing configuration: requestUrl"), nil
+	}
+	requestUrl := dsSettings.RequestUrl
+	token := dsSettings.Token
+	client := d.newQuerierClient(requestUrl, dsSettings.TraceUrl, token)
+
+	details := healthDetails{
+		RequestUrl: requestUrl,
+		Tables:     map[string]bool{},
+	}
+
+	// 库
+	databases, err := healthShow(ctx, client, "", "show databases")
+	if err != nil {
+		details.Errors = append(details.Errors, err.Error())
+		return newHealthCheckResult(backend.HealthStatusError, describeHealthError("querier", requestUrl, err), details), nil
+	}
+	details.Databases = databases
+	if details.Version, err = d.serverVersion(ctx, token, requestUrl); err != nil {
+		details.VersionError = err.Error()
+	}
+
+	// 关键库表
+	missing := []string{}
+	for _, kt := range healthKeyTables {
+		name := kt.db
+		found := containsString(databases, kt.db)
+		if found && kt.table != "" {
+			name = kt.db + "." + kt.table
+			tables, err := healthShow(ctx, client, kt.db, "show tables")
+			if err != nil {
+				details.Errors = append(details.Errors, fmt.Sprintf("%s: %s", name, err.Error()))
+			}
+			found = containsString(tables, kt.table)
+		} else if kt.table != "" {
+			name = kt.db + "." + kt.table
+		}
+		details.Tables[name] = found
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+
+	// tracing接口
+	if dsSettings.TraceUrl != "" {
+		details.Trace = &traceHealth{Url: dsSettings.TraceUrl}
+		now := time.Now().Unix()
+		// 用不存在的 _id 探测，tracing接口对找不到的 trace 返回 400 或 404 时也说明接口可达，
+		// 其他 4xx (如 405、429) 说明地址或代理配置有问题
+		_, err := client.Trace(ctx, TraceRequest{Id: "0", From: now - 60, To: now})
+		var statusErr *deepflowclient.StatusError
+		if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusBadRequest || statusErr.StatusCode == http.StatusNotFound) {
+			err = nil
+		}
+		if err != nil {
+			details.Trace.Error = err.Error()
+			details.Errors = append(details.Errors, err.Error())
+			return newHealthCheckResult(backend.HealthStatusError,
+				"The querier is OK, but "+describeHealthError("tracing", dsSettings.TraceUrl, err), details), nil
+		}
+		details.Trace.Reachable = true
+	}
+
+	message := "The data source test is OK"
+	if details.Version != "" {
+		message += ", deepflow-server " + details.Version
+	}
+	if len(missing) > 0 {
+		message += ", but the token has no access to: " + strings.Join(missing, ", ")
+	}
+	return newHealthCheckResult(backend.HealthStatusOk, message, details), nil
+}
+
+// healthShow 执行 show 语句，返回第一列 (或 name 列) 的值
+func healthShow(ctx context.Context, client *querierClient, db, sql string) ([]string, error) {
+	res, err := client.Query(ctx, QuerierRequest{Db: db, Sql: sql})
+	if err != nil {
+		return nil, err
+	}
+	return firstColumn(res), nil
+}
+
+func firstColumn(res *QuerierResponse) []string {
+	if res.Values.Len() == 0 {
+		return []string{}
+	}
+	column := res.Values.Column("name")
+	if column == nil {
+		column = res.Values.Columns[0]
+	}
+	names := make([]string, 0, column.Len())
+	for i := 0; i < column.Len(); i++ {
+		if name, ok := column.Value(i).(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// serverVersion 尽力获取 deepflow-server 版本，不是所有版本的 deepflow-server 都提供 /v1/version/，
+// 获取失败时返回原因，只写入诊断信息，不影响测试结果
+func (d *Datasource) serverVersion(ctx context.Context, token, requestUrl string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl+serverVersionPath, nil)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		req.Header.Add("Authorization", "Bearer "+token)
+	}
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		log.DefaultLogger.Debug("__________failed to get deepflow-server version", "error", err.Error())
+		return "", fmt.Errorf("version not available: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("version not available: %s returned HTTP %d", serverVersionPath, resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", fmt.Errorf("version not available: %w", err)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+	for _, key := range []string{"VERSION", "version", "DATA", "data"} {
+		if v, ok := body[key].(string); ok {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("version not available: no version in the response of %s", serverVersionPath)
+}
+
+// describeHealthError 区分认证失败与网络不可达
+func describeHealthError(endpoint, endpointUrl string, err error) string {
+	var statusErr *deepflowclient.StatusError
+	var urlErr *url.Error
+	var netErr net.Error
+	switch {
+	case deepflowclient.IsAuthError(err) && errors.As(err, &statusErr):
+		return fmt.Sprintf("authentication to the %s at %s failed (HTTP %d), check the token", endpoint, endpointUrl, statusErr.StatusCode)
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("the %s at %s returned HTTP %d", endpoint, endpointUrl, statusErr.StatusCode)
+	case errors.Is(err, ErrCircuitOpen):
+		return fmt.Sprintf("the %s at %s failed repeatedly and is temporarily skipped: %s", endpoint, endpointUrl, err.Error())
+	case errors.As(err, &netErr), errors.As(err, &urlErr):
+		return fmt.Sprintf("the %s at %s is unreachable, check the url and network: %s", endpoint, endpointUrl, err.Error())
+	default:
+		return fmt.Sprintf("the %s at %s returned an error: %s", endpoint, endpointUrl, err.Error())
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func newHealthCheckResult(status backend.HealthStatus, message string, details healthDetails) *backend.CheckHealthResult {
+	jsonDetails, err := json.Marshal(details)
+	if err != nil {
+		log.DefaultLogger.Error("__________failed to encode health check details", "error", err.Error())
+	}
+	return &backend.CheckHealthResult{
+		Status:      status,
+		Message:     message,
+		JSONDetails: jsonDetails,
+	}
+}
+
+// newHealthCheckErrorf returns a new *backend.CheckHealthResult with its status set to backend.HealthStatusError
+// and the specified message, which is formatted with Sprintf.
+func newHealthCheckErrorf(format string, args ...interface{}) *backend.CheckHealthResult {
+	return &backend.CheckHealthResult{Status: backend.HealthStatusError, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
+	"deepflow-grafana-backend-plugin/pkg/fixture"
+)
+
+func healthRoute(match string, status int, body string) fixture.Route {
+	return fixture.Route{Path: deepflowclient.QueryPath, Match: match, Status: status, Body: json.RawMessage(body)}
+}
+
+const healthDatabases = `{"OPT_STATUS":"SUCCESS","result":{"columns":["name"],"values":[["flow_metrics"],["flow_log"],["profile"]]}}`
+
+func checkHealth(t *testing.T, jsonData map[string]interface{}) (*backend.CheckHealthResult, healthDetails) {
+	t.Helper()
+	jsonData["disableCache"] = true
+	jsonData["retryMaxAttempts"] = 1
+	res, err := newTestDatasource(t, jsonData).CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var details healthDetails
+	if len(res.JSONDetails) > 0 {
+		if err := json.Unmarshal(res.JSONDetails, &details); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res, details
+}
+
+func TestCheckHealthDetails(t *testing.T) {
+	server := newFakeDeepflowServer(t, []fixture.Route{
+		healthRoute("show databases", 0, healthDatabases),
+		healthRoute("show tables", 0, `{"OPT_STATUS":"SUCCESS","result":{"columns":["name"],"values":[["l4_flow_log"],["l7_flow_log"]]}}`),
+		{Path: serverVersionPath, Body: json.RawMessage(`{"VERSION":"v6.6.0"}`)},
+		// 不存在的 trace 返回 404，接口可达
+		{Path: deepflowclient.TracePath, Status: http.StatusNotFound, Body: json.RawMessage(`{}`)},
+	})
+	res, details := checkHealth(t, map[string]interface{}{"requestUrl": server.URL, "traceUrl": server.URL})
+	if res.Status != backend.HealthStatusOk || res.Message != "The data source test is OK, deepflow-server v6.6.0" {
+		t.Errorf("status = %v, message = %q", res.Status, res.Message)
+	}
+	want := map[string]bool{"flow_metrics": true, "flow_log.l7_flow_log": true, "profile": true}
+	if details.Version != "v6.6.0" || len(details.Databases) != 3 || !reflect.DeepEqual(details.Tables, want) {
+		t.Errorf("details = %+v", details)
+	}
+	if details.Trace == nil || !details.Trace.Reachable {
+		t.Errorf("trace = %+v, want reachable", details.Trace)
+	}
+}
+
+// TestCheckHealthMissingTable token 看不到关键表时测试通过但给出提示，没有版本接口时在诊断信息中说明
+func TestCheckHealthMissingTable(t *testing.T) {
+	server := newFakeDeepflowServer(t, []fixture.Route{
+		healthRoute("show databases", 0, healthDatabases),
+		healthRoute("show tables", 0, `{"OPT_STATUS":"SUCCESS","result":{"columns":["name"],"values":[["l4_flow_log"]]}}`),
+		{Path: serverVersionPath, Status: http.StatusNotFound, Body: json.RawMessage(`{}`)},
+	})
+	res, details := checkHealth(t, map[string]interface{}{"requestUrl": server.URL})
+	if res.Status != backend.HealthStatusOk || !strings.HasSuffix(res.Message, "but the token has no access to: flow_log.l7_flow_log") {
+		t.Errorf("status = %v, message = %q", res.Status, res.Message)
+	}
+	if details.Tables["flow_log.l7_flow_log"] || !details.Tables["flow_metrics"] {
+		t.Errorf("tables = %v", details.Tables)
+	}
+	if details.Version != "" || !strings.Contains(details.VersionError, "HTTP 404") {
+		t.Errorf("version = %q, versionError = %q, want the reason the version is missing", details.Version, details.VersionError)
+	}
+}
+
+func TestCheckHealthErrors(t *testing.T) {
+	// 已关闭的端口，连接被拒绝
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "http://" + listener.Addr().String()
+	listener.Close()
+
+	unauthorized := newFakeDeepflowServer(t, []fixture.Route{healthRoute("show databases", http.StatusUnauthorized, `{"DESCRIPTION":"invalid token"}`)})
+	traceNotAllowed := newFakeDeepflowServer(t, []fixture.Route{
+		healthRoute("show databases", 0, healthDatabases),
+		healthRoute("show tables", 0, `{"OPT_STATUS":"SUCCESS","result":{"columns":["name"],"values":[["l7_flow_log"]]}}`),
+		{Path: serverVersionPath, Body: json.RawMessage(`{"VERSION":"v6.6.0"}`)},
+		{Path: deepflowclient.TracePath, Status: http.StatusMethodNotAllowed, Body: json.RawMessage(`{}`)},
+	})
+
+	cases := []struct {
+		name     string
+		jsonData map[string]interface{}
+		message  string
+	}{
+		{"unauthorized", map[string]interface{}{"requestUrl": unauthorized.URL},
+			"authentication to the querier at " + unauthorized.URL + " failed (HTTP 401), check the token"},
+		{"connection refused", map[string]interface{}{"requestUrl": refused},
+			"the querier at " + refused + " is unreachable, check the url and network"},
+		{"trace 405", map[string]interface{}{"requestUrl": traceNotAllowed.URL, "traceUrl": traceNotAllowed.URL},
+			"The querier is OK, but the tracing at " + traceNotAllowed.URL + " returned HTTP 405"},
+	}
+	for _, c := range cases {
+		res, details := checkHealth(t, c.jsonData)
+		if res.Status != backend.HealthStatusError || !strings.HasPrefix(res.Message, c.message) {
+			t.Errorf("%s: status = %v, message = %q, want %q", c.name, res.Status, res.Message, c.message)
+		}
+		if len(details.Errors) == 0 {
+			t.Errorf("%s: no errors in the details", c.name)
+		}
+	}
+}
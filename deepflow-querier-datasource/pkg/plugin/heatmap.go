@@ -0,0 +1,263 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Grafana 热力图的 frame 类型，SDK 中未定义
+const frameTypeHeatmapCells data.FrameType = "heatmap-cells"
+
+// bucketSeries 按 bucket tag 分桶的结果，其余 tag 相同的行为一组
+type bucketSeries struct {
+	name string
+	// 时间桶起点 -> 桶上限 -> 数量
+	cells map[int64]map[float64]float64
+}
+
+// parseBucketBound bucket tag 的值为桶的上限，+Inf 表示无上限；
+// 数量为落在该桶内的数量，不是 Prometheus le 那样的累计值
+func parseBucketBound(v interface{}) (float64, error) {
+	s := strings.TrimSpace(logValue(v))
+	switch strings.ToLower(s) {
+	case "+inf", "inf":
+		return math.Inf(1), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bucket: value: %v is not a number", v)
+	}
+	return f, nil
+}
+
+// valueBucketBound 没有 bucket tag 时按值分桶，桶的上限为 1 及 2 的幂次
+func valueBucketBound(v float64) float64 {
+	if v <= 1 {
+		return 1
+	}
+	return math.Pow(2, math.Ceil(math.Log2(v)))
+}
+
+// bucketColumns 确定 bucket tag 及 metric：设置了 bucketTag 时 metric 为桶内的数量，
+// 否则 metric 的每个值为一次观测值，如 Avg(rrt)、response_duration，按 valueBucketBound 分桶计数
+func bucketColumns(row tableRow, bucketTag string, metricKeys []string) (string, string, error) {
+	if bucketTag != "" {
+		if _, ok := row.get(bucketTag); !ok {
+			return "", "", fmt.Errorf("bucketTag %s is not returned by the query", bucketTag)
+		}
+	}
+	if len(metricKeys) == 0 {
+		return "", "", fmt.Errorf("heatmap and histogram need a metric with the values or the count of a bucket")
+	}
+	sorted := append([]string(nil), metricKeys...)
+	sort.Strings(sorted)
+	return bucketTag, sorted[0], nil
+}
+
+// groupBuckets 按 bucket tag 以外的 tag 分组，累加每个时间桶及值桶的数量，没有时间列时时间为 0
+func groupBuckets(table *resultTable, timeKey, bucketTag, countKey string, tagKeys []string) ([]*bucketSeries, error) {
+	groupTags := make([]string, 0, len(tagKeys))
+	for _, k := range tagKeys {
+		if k != bucketTag {
+			groupTags = append(groupTags, k)
+		}
+	}
+	sort.Strings(groupTags)
+
+	groups := map[string]*bucketSeries{}
+	for i := 0; i < table.rows; i++ {
+		row := table.row(i)
+		names := make([]string, 0, len(groupTags))
+		for _, k := range groupTags {
+			if v := row.value(k); v != nil {
+				names = append(names, logValue(v))
+			}
+		}
+		name := strings.Join(names, ", ")
+		series, ok := groups[name]
+		if !ok {
+			series = &bucketSeries{name: name, cells: map[int64]map[float64]float64{}}
+			groups[name] = series
+		}
+
+		var ts int64
+		if timeKey != "" {
+			n, ok := row.value(timeKey).(json.Number)
+			if !ok {
+				v := row.value(timeKey)
+				return nil, fmt.Errorf("time: columns: %v, value: %v, assertion failed, type %T", timeKey, v, v)
+			}
+			f, err := n.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("time: columns: %v, value: %v, Assertion failed for float64", timeKey, n)
+			}
+			ts = int64(f)
+		}
+		value := 0.0
+		n, ok := row.value(countKey).(json.Number)
+		if ok {
+			var err error
+			if value, err = n.Float64(); err != nil {
+				return nil, fmt.Errorf("columns: %v, value: %v, failed to convert float64", countKey, n)
+			}
+		}
+		var bound, count float64
+		if bucketTag != "" {
+			b, err := parseBucketBound(row.value(bucketTag))
+			if err != nil {
+				return nil, err
+			}
+			bound, count = b, value
+		} else {
+			// 空值不是观测值
+			if !ok {
+				continue
+			}
+			bound, count = valueBucketBound(value), 1
+		}
+		if series.cells[ts] == nil {
+			series.cells[ts] = map[float64]float64{}
+		}
+		series.cells[ts][bound] += count
+	}
+
+	result := make([]*bucketSeries, 0, len(groups))
+	for _, series := range groups {
+		if bucketTag == "" {
+			series.fillValueBuckets()
+		}
+		result = append(result, series)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].name < result[j].name })
+	return result, nil
+}
+
+// bounds 所有出现过的桶上限，升序
+func (s *bucketSeries) bounds() []float64 {
+	set := map[float64]bool{}
+	for _, buckets := range s.cells {
+		for b := range buckets {
+			set[b] = true
+		}
+	}
+	bounds := make([]float64, 0, len(set))
+	for b := range set {
+		bounds = append(bounds, b)
+	}
+	sort.Float64s(bounds)
+	return bounds
+}
+
+// fillValueBuckets 按值分桶时补齐最小及最大的桶之间没有观测值的桶，使除第一个桶外每个桶的下限为上限的一半
+func (s *bucketSeries) fillValueBuckets() {
+	bounds := s.bounds()
+	if len(bounds) == 0 {
+		return
+	}
+	for ts := range s.cells {
+		for b := bounds[0]; b < bounds[len(bounds)-1]; b *= 2 {
+			s.cells[ts][b] += 0
+		}
+		break
+	}
+}
+
+// lowerBounds 每个桶的下限为前一个桶的上限，第一个桶为 0
+func lowerBounds(bounds []float64) map[float64]float64 {
+	lower := make(map[float64]float64, len(bounds))
+	prev := 0.0
+	for _, b := range bounds {
+		lower[b] = math.Min(prev, b)
+		prev = b
+	}
+	return lower
+}
+
+// heatmapFrame Grafana heatmap-cells 格式，每个时间桶及值桶一行，缺失的值桶数量为 0
+func (s *bucketSeries) heatmapFrame() *data.Frame {
+	bounds := s.bounds()
+	lower := lowerBounds(bounds)
+	times := make([]int64, 0, len(s.cells))
+	for ts := range s.cells {
+		times = append(times, ts)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	frame := data.NewFrame(s.name,
+		data.NewField("xMin", nil, []time.Time{}),
+		data.NewField("yMin", nil, []float64{}),
+		data.NewField("yMax", nil, []float64{}),
+		data.NewField("count", nil, []float64{}),
+	)
+	frame.Meta = &data.FrameMeta{Type: frameTypeHeatmapCells, PreferredVisualization: "heatmap"}
+	for _, ts := range times {
+		for _, b := range bounds {
+			frame.AppendRow(time.Unix(ts, 0), lower[b], b, s.cells[ts][b])
+		}
+	}
+	return frame
+}
+
+// histogramFrame Grafana 直方图格式 xMin/xMax/count，所有时间桶的数量累加
+func (s *bucketSeries) histogramFrame() *data.Frame {
+	bounds := s.bounds()
+	lower := lowerBounds(bounds)
+	counts := make(map[float64]float64, len(bounds))
+	for _, buckets := range s.cells {
+		for b, c := range buckets {
+			counts[b] += c
+		}
+	}
+	countName := "count"
+	if s.name != "" {
+		countName = s.name
+	}
+	frame := data.NewFrame(s.name,
+		data.NewField("xMin", nil, []float64{}),
+		data.NewField("xMax", nil, []float64{}),
+		data.NewField(countName, nil, []float64{}),
+	)
+	frame.Meta = &data.FrameMeta{PreferredVisualization: "histogram"}
+	for _, b := range bounds {
+		frame.AppendRow(lower[b], b, counts[b])
+	}
+	return frame
+}
+
+// bucketFrames formatAs 为 heatmap 或 histogram 时，将按桶上限分组的结果或 metric 的观测值转换为对应的 frame
+func bucketFrames(formatAs string, table *resultTable, bucketTag string, timeKeys, tagKeys, metricKeys []string) (data.Frames, error) {
+	if table.rows == 0 {
+		return nil, nil
+	}
+	bucketTag, countKey, err := bucketColumns(table.row(0), bucketTag, metricKeys)
+	if err != nil {
+		return nil, err
+	}
+	timeKey := ""
+	if formatAs == "heatmap" {
+		if len(timeKeys) == 0 {
+			return nil, fmt.Errorf("heatmap needs a time series query grouped by time(time, N)")
+		}
+		timeKey = timeKeys[0]
+	}
+	series, err := groupBuckets(table, timeKey, bucketTag, countKey, tagKeys)
+	if err != nil {
+		return nil, err
+	}
+	frames := make(data.Frames, 0, len(series))
+	for _, s := range series {
+		if formatAs == "heatmap" {
+			frames = append(frames, s.heatmapFrame())
+		} else {
+			frames = append(frames, s.histogramFrame())
+		}
+	}
+	return frames, nil
+}
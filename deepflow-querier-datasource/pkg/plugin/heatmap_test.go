@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestValueBucketBound(t *testing.T) {
+	tests := []struct{ v, want float64 }{
+		{0, 1}, {1, 1}, {1.5, 2}, {64, 64}, {65, 128}, {3000, 4096},
+	}
+	for _, tt := range tests {
+		if got := valueBucketBound(tt.v); got != tt.want {
+			t.Errorf("valueBucketBound(%v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
+
+// TestBucketFramesBucketTag 设置 bucketTag 时每行的 metric 为该桶内的数量，不做累计值的差分
+func TestBucketFramesBucketTag(t *testing.T) {
+	type n = json.Number
+	table := newResultTable(testValues(t, []string{"pod_service", "le", "Sum(request)"},
+		[]interface{}{"web", "100", n("10")},
+		[]interface{}{"web", "1000", n("4")},
+		[]interface{}{"web", "+Inf", n("1")},
+		[]interface{}{"db", "1000", n("2")},
+	))
+	frames, err := bucketFrames("histogram", table, "le", nil, []string{"pod_service", "le"}, []string{"Sum(request)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || frames[1].Name != "web" {
+		t.Fatalf("got %d frames, want db and web", len(frames))
+	}
+	web := frames[1]
+	wantMax := []float64{100, 1000, math.Inf(1)}
+	wantCount := []float64{10, 4, 1}
+	for i := range wantMax {
+		if web.Fields[1].At(i).(float64) != wantMax[i] || web.Fields[2].At(i).(float64) != wantCount[i] {
+			t.Errorf("bucket %d = %v/%v, want %v/%v", i, web.Fields[1].At(i), web.Fields[2].At(i), wantMax[i], wantCount[i])
+		}
+	}
+
+	if _, err := bucketFrames("histogram", table, "rrt_bucket", nil, []string{"pod_service", "le"}, []string{"Sum(request)"}); err == nil {
+		t.Errorf("expected an error for a bucketTag missing from the result")
+	}
+}
+
+// TestBucketFramesValuesPerTag 没有 bucketTag 时按其余 tag 分组，每个 pod_service 一个 heatmap
+func TestBucketFramesValuesPerTag(t *testing.T) {
+	type n = json.Number
+	table := newResultTable(testValues(t, []string{"time_60", "pod_service", "Avg(rrt)"},
+		[]interface{}{n("1700000000"), "web", n("90")},
+		[]interface{}{n("1700000000"), "db", n("3000")},
+		[]interface{}{n("1700000060"), "web", n("120")},
+		[]interface{}{n("1700000060"), "db", nil},
+	))
+	frames, err := bucketFrames("heatmap", table, "", []string{"time_60"}, []string{"pod_service"}, []string{"Avg(rrt)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || frames[0].Name != "db" || frames[1].Name != "web" {
+		t.Fatalf("got %d frames, want one heatmap for db and one for web", len(frames))
+	}
+	for _, frame := range frames {
+		if frame.Meta == nil || frame.Meta.Type != frameTypeHeatmapCells {
+			t.Errorf("%s: frame type is not heatmap-cells", frame.Name)
+		}
+	}
+	// db 只有一个非空的观测值 3000，落在 (2048, 4096]
+	db := frames[0]
+	if db.Rows() != 1 || db.Fields[2].At(0).(float64) != 4096 || db.Fields[3].At(0).(float64) != 1 {
+		t.Errorf("db: %d rows, want one observation in the 4096 bucket", db.Rows())
+	}
+	// web 的 90 及 120 都落在 (64, 128]
+	web := frames[1]
+	if web.Rows() != 2 || web.Fields[2].At(0).(float64) != 128 || web.Fields[3].At(1).(float64) != 1 {
+		t.Errorf("web: %d rows, want one observation in the 128 bucket per minute", web.Rows())
+	}
+}
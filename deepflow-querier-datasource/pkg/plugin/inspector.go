@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// querierCall querier 请求 deepflow-server 或从缓存读取的一个时间范围
+type querierCall struct {
+	req    QuerierRequest
+	cached bool
+}
+
+// querierCalls 一次 querier 调用中实际请求的时间范围、缓存命中次数及请求 deepflow-server 的耗时，
+// 缓存按时间拆分时一次调用包括缓存的历史部分及重新查询的最近部分
+type querierCalls struct {
+	calls        []querierCall
+	hits, misses int
+	requestTime  time.Duration
+}
+
+type querierCallsKey struct{}
+
+func withQuerierCalls(ctx context.Context) (context.Context, *querierCalls) {
+	calls := &querierCalls{}
+	return context.WithValue(ctx, querierCallsKey{}, calls), calls
+}
+
+func querierCallsFromContext(ctx context.Context) *querierCalls {
+	calls, _ := ctx.Value(querierCallsKey{}).(*querierCalls)
+	return calls
+}
+
+// request 记录一次对 deepflow-server 的请求
+func (c *querierCalls) request(req QuerierRequest, elapsed time.Duration) {
+	if c == nil {
+		return
+	}
+	c.calls = append(c.calls, querierCall{req: req})
+	c.requestTime += elapsed
+}
+
+// lookup 记录一次缓存查找，命中时 req 为缓存的时间范围
+func (c *querierCalls) lookup(req QuerierRequest, hit bool) {
+	if c == nil {
+		return
+	}
+	if !hit {
+		c.misses++
+		return
+	}
+	c.hits++
+	c.calls = append(c.calls, querierCall{req: req, cached: true})
+}
+
+// queryInspector 面板主查询发送给 deepflow-server 的 SQL、耗时及返回大小，写入 frame meta 后在 Grafana 的 query inspector 中展示
+type queryInspector struct {
+	req   QuerierRequest
+	calls *querierCalls
+	// 收到返回的时间，之后为转换 frame 的时间
+	received time.Time
+	rows     int
+	bytes    int64
+}
+
+// inspectedQuerier 请求querier并记录用于 query inspector 的信息
+func (d *Datasource) inspectedQuerier(ctx context.Context, client *querierClient, appType string, req QuerierRequest) (*QuerierResponse, *queryInspector, error) {
+	ctx, calls := withQuerierCalls(ctx)
+	res, err := d.querier(ctx, client, appType, req)
+	inspector := &queryInspector{req: req, calls: calls, received: time.Now(), rows: querierRows(res)}
+	if res != nil {
+		inspector.bytes = res.Bytes
+	}
+	return res, inspector, err
+}
+
+// executedQueryString 每个实际查询的时间范围宏替换后的 SQL，及请求中的 db 和数据精度，来自缓存的范围单独标出
+func (q *queryInspector) executedQueryString() string {
+	calls := q.calls.calls
+	if len(calls) == 0 {
+		calls = []querierCall{{req: q.req}}
+	}
+	queries := make([]string, len(calls))
+	for i, call := range calls {
+		form := call.req.Form()
+		precision := form.Get("data_precision")
+		if precision == "" {
+			precision = "default"
+		}
+		db := form.Get("db")
+		if db == "" {
+			db = "default"
+		}
+		header := fmt.Sprintf("-- db: %s, data precision: %s", db, precision)
+		if call.cached {
+			header += ", from cache"
+		}
+		queries[i] = header + "\n" + form.Get("sql")
+	}
+	return strings.Join(queries, "\n\n")
+}
+
+// apply 写入 ExecutedQueryString 及 Stats，在 frame 构建完成后调用
+// Querier latency 只包括请求 deepflow-server 的时间，全部来自缓存时为 0
+func (q *queryInspector) apply(meta *data.FrameMeta) {
+	meta.ExecutedQueryString = q.executedQueryString()
+	meta.Stats = []data.QueryStat{
+		{FieldConfig: data.FieldConfig{DisplayName: "Querier latency", Unit: "ms"}, Value: float64(q.calls.requestTime.Microseconds()) / 1000},
+		{FieldConfig: data.FieldConfig{DisplayName: "Conversion time", Unit: "ms"}, Value: float64(time.Since(q.received).Microseconds()) / 1000},
+		{FieldConfig: data.FieldConfig{DisplayName: "Rows"}, Value: float64(q.rows)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Response size", Unit: "decbytes"}, Value: float64(q.bytes)},
+	}
+	if q.calls.hits+q.calls.misses > 0 {
+		meta.Stats = append(meta.Stats,
+			data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: "Cache hits"}, Value: float64(q.calls.hits)},
+			data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: "Cache misses"}, Value: float64(q.calls.misses)},
+		)
+	}
+}
+
+// meta 没有其他元数据的 frame 使用的 meta
+func (q *queryInspector) meta() *data.FrameMeta {
+	meta := &data.FrameMeta{}
+	q.apply(meta)
+	return meta
+}
+
+// 查询结果为空时的提示
+var noDataNotice = data.Notice{
+	Severity: data.NoticeSeverityInfo,
+	Text:     "the query returned no data, check the time range and filters",
+}
+
+// emptyResponse 查询结果为空时返回没有字段的 frame，在 meta 中说明原因
+func (q *queryInspector) emptyResponse() data.Frames {
+	frame := data.NewFrame("response")
+	frame.Meta = q.meta()
+	frame.Meta.Notices = append(frame.Meta.Notices, noDataNotice)
+	return data.Frames{frame}
+}
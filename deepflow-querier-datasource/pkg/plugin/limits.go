@@ -0,0 +1,38 @@
+package plugin
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	defaultMaxRows          = 100000
+	defaultMaxResponseBytes = 256 // MB
+)
+
+// resultLimits 单次查询返回的行数及字节数上限
+type resultLimits struct {
+	maxRows  int
+	maxBytes int64
+}
+
+func newResultLimits(s DatasourceSettings) resultLimits {
+	limits := resultLimits{
+		maxRows:  int(s.MaxRows),
+		maxBytes: int64(s.MaxResponseBytes) << 20,
+	}
+	if limits.maxRows <= 0 {
+		limits.maxRows = defaultMaxRows
+	}
+	if limits.maxBytes <= 0 {
+		limits.maxBytes = defaultMaxResponseBytes << 20
+	}
+	return limits
+}
+
+// truncatedNotice 结果被截断时在 frame meta 中给出提示
+func truncatedNotice(reason string) data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     "result truncated: " + reason + ", narrow the time range or add filters and LIMIT to see all data",
+	}
+}
@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// tracingLinks appTracing 结果跳转火焰图的链接配置
+type tracingLinks struct {
+	enabled bool
+	// 链接时间范围向两侧扩展的时长
+	padding time.Duration
+}
+
+func newTracingLinks(s DatasourceSettings) tracingLinks {
+	return tracingLinks{
+		enabled: s.TracingLinks,
+		padding: time.Duration(s.TracingLinkPadding) * time.Second,
+	}
+}
+
+// flameLink 打开同一数据源 appTracingFlame 查询的内部链接，value 为 _id (id-<n> 或 <n>)，可以是 Grafana 链接变量
+// 时间范围按 padding 向两侧扩展，使跨越面板边界的调用链也能查全
+func (d *Datasource) flameLink(title, value string, from, to time.Time) data.DataLink {
+	queryText, _ := json.Marshal(map[string]interface{}{
+		"appType": "appTracingFlame",
+		"db":      "flow_log",
+		"sources": "",
+		"tracingId": map[string]interface{}{
+			"value":      value,
+			"isVariable": false,
+		},
+	})
+	return data.DataLink{
+		Title: title,
+		Internal: &data.InternalDataLink{
+			DatasourceUID:  d.settings.UID,
+			DatasourceName: d.settings.Name,
+			Query: map[string]interface{}{
+				"refId":     "A",
+				"queryText": string(queryText),
+			},
+			Range: &data.TimeRange{From: from.Add(-d.links.padding), To: to.Add(d.links.padding)},
+		},
+	}
+}
+
+// addTracingLinks 给 appTracing 结果的 _id、trace_id 字段加上打开火焰图的链接
+// trace_id 本身不能直接查询火焰图，使用同一行的 _id
+func (d *Datasource) addTracingLinks(frame *data.Frame, from, to time.Time) {
+	if !d.links.enabled {
+		return
+	}
+	if _, idx := frame.FieldByName("_id"); idx < 0 {
+		return
+	}
+	for _, field := range frame.Fields {
+		var link data.DataLink
+		switch field.Name {
+		case "_id":
+			link = d.flameLink("Open trace", "${__value.raw}", from, to)
+		case "trace_id":
+			link = d.flameLink("Open trace", "${__data.fields._id}", from, to)
+		default:
+			continue
+		}
+		if field.Config == nil {
+			field.Config = &data.FieldConfig{}
+		}
+		field.Config.Links = append(field.Config.Links, link)
+	}
+}
@@ -0,0 +1,208 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
+)
+
+const (
+	defaultLogMaxPayloadBytes = 2048
+	defaultLogSampleRate      = 100 // %
+
+	redactedValue = "[REDACTED]"
+)
+
+// sql 中 tag 与字符串或列表比较的条件，如 `token` = 'x'、token IN ('x', 'y')
+var sqlConditionRe = regexp.MustCompile("(?i)`?([\\w.]+)`?" +
+	`(\s*(?:=|!=|<>|\bNOT\s+LIKE\b|\bLIKE\b|\bNOT\s+IN\b|\bIN\b|\bREGEXP\b)\s*)('(?:[^'\\]|\\.)*'|\([^)]*\))`)
+
+// 始终脱敏的字段，与配置的 logRedactTags 一起按小写匹配
+var builtinSensitiveKeys = []string{
+	"authorization", "cookie", "set-cookie", "x-api-key",
+	"token", "password", "secret", "tlsclientkey", "tlscacert", "tlsclientcert",
+}
+
+// logPolicy 日志策略：
+//   - 行数据只在日志级别为 Debug 且查询开启 debug 时输出
+//   - 敏感字段 (header、配置、结果中的 tag) 脱敏
+//   - 单条日志中的数据按 maxPayload 截断
+//   - 每个查询的 Info 日志按 sampleRate 采样，错误日志不采样
+type logPolicy struct {
+	maxPayload int
+	sampleRate int
+	sensitive  map[string]struct{}
+}
+
+func newLogPolicy(s DatasourceSettings) *logPolicy {
+	p := &logPolicy{
+		maxPayload: int(s.LogMaxPayloadBytes),
+		sampleRate: int(s.LogSampleRate),
+		sensitive:  map[string]struct{}{},
+	}
+	if p.maxPayload <= 0 {
+		p.maxPayload = defaultLogMaxPayloadBytes
+	}
+	if p.sampleRate <= 0 || p.sampleRate > 100 {
+		p.sampleRate = defaultLogSampleRate
+	}
+	for _, k := range builtinSensitiveKeys {
+		p.sensitive[k] = struct{}{}
+	}
+	for _, tag := range strings.Split(s.LogRedactTags, ",") {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			p.sensitive[tag] = struct{}{}
+		}
+	}
+	return p
+}
+
+// sampled 判断本次查询的 Info 日志是否输出
+func (p *logPolicy) sampled() bool {
+	return p.sampleRate >= 100 || rand.Intn(100) < p.sampleRate
+}
+
+func debugEnabled() bool {
+	level := log.DefaultLogger.Level()
+	return level != log.NoLevel && level <= log.Debug
+}
+
+// dumpRows 输出转换过程中的行数据，只在 Debug 级别且查询开启 debug 时输出
+func (p *logPolicy) dumpRows(debug bool, msg string, v interface{}) {
+	if !debug || !debugEnabled() {
+		return
+	}
+	log.DefaultLogger.Debug(msg, "data", p.payload(v))
+}
+
+// payload 脱敏并截断后的 JSON，[]byte 按 JSON 解析
+func (p *logPolicy) payload(v interface{}) string {
+	var generic interface{}
+	switch b := v.(type) {
+	case []byte:
+		if err := json.Unmarshal(b, &generic); err != nil {
+			return p.truncate(string(b))
+		}
+	case json.RawMessage:
+		if err := json.Unmarshal(b, &generic); err != nil {
+			return p.truncate(string(b))
+		}
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return "<" + err.Error() + ">"
+		}
+		json.Unmarshal(raw, &generic)
+	}
+	out, err := json.Marshal(p.redact(generic))
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return p.truncate(string(out))
+}
+
+// truncate 按字节截断，不截断多字节字符
+func (p *logPolicy) truncate(s string) string {
+	if len(s) <= p.maxPayload {
+		return s
+	}
+	end := p.maxPayload
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end] + "...(truncated)"
+}
+
+// errorFields 错误日志的字段，错误信息中的 sql 脱敏并截断；
+// deepflow-server 返回错误时，请求参数单独按 sql 或 JSON 脱敏后输出
+func (p *logPolicy) errorFields(err error) []interface{} {
+	fields := []interface{}{"error", p.sql(err.Error())}
+	var statusErr *deepflowclient.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Params == "" {
+		return fields
+	}
+	if form, e := url.ParseQuery(statusErr.Params); e == nil && form.Get("sql") != "" {
+		return append(fields, "sql", p.sql(form.Get("sql")))
+	}
+	return append(fields, "params", p.payload([]byte(statusErr.Params)))
+}
+
+// sql 脱敏并截断后的 sql，用于日志及 span 属性
+func (p *logPolicy) sql(s string) string {
+	return p.truncate(p.redactSQL(s))
+}
+
+// redactSQL 替换 sql 中敏感 tag 的比较值
+func (p *logPolicy) redactSQL(s string) string {
+	return sqlConditionRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := sqlConditionRe.FindStringSubmatch(m)
+		if !p.isSensitive(sub[1]) {
+			return m
+		}
+		value := "'" + redactedValue + "'"
+		if strings.HasPrefix(sub[3], "(") {
+			value = "(" + value + ")"
+		}
+		return m[:len(m)-len(sub[3])] + value
+	})
+}
+
+func (p *logPolicy) isSensitive(key string) bool {
+	_, ok := p.sensitive[strings.ToLower(key)]
+	return ok
+}
+
+// redact 递归替换敏感字段的值，queryText 等 JSON 字符串也会展开检查
+func (p *logPolicy) redact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, vv := range t {
+			if p.isSensitive(k) {
+				out[k] = redactedValue
+				continue
+			}
+			out[k] = p.redact(vv)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, vv := range t {
+			out[i] = p.redact(vv)
+		}
+		return out
+	case string:
+		trimmed := strings.TrimSpace(t)
+		if strings.HasPrefix(trimmed, "{") {
+			var nested map[string]interface{}
+			if json.Unmarshal([]byte(trimmed), &nested) == nil {
+				b, _ := json.Marshal(p.redact(nested))
+				return string(b)
+			}
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+// redactHeaders 请求头脱敏
+func (p *logPolicy) redactHeaders(headers map[string]string) map[string]string {
+	out := make(map[string]string, len(headers))
+	for k, v := range headers {
+		// Grafana 转发的请求头以 http_ 为前缀
+		if p.isSensitive(k) || p.isSensitive(strings.TrimPrefix(strings.ToLower(k), "http_")) {
+			v = redactedValue
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
+)
+
+func TestRedactSQL(t *testing.T) {
+	p := newLogPolicy(DatasourceSettings{LogRedactTags: "attribute.user_id", LogMaxPayloadBytes: 120})
+	cases := map[string]string{
+		"SELECT a FROM t WHERE `attribute.user_id` = 'u1' AND ip = '10.0.0.1'": "SELECT a FROM t WHERE `attribute.user_id` = '[REDACTED]' AND ip = '10.0.0.1'",
+		"SELECT a FROM t WHERE token IN ('a', 'b') OR Password LIKE 'x\\'y%'":  "SELECT a FROM t WHERE token IN ('[REDACTED]') OR Password LIKE '[REDACTED]'",
+		"SELECT a FROM t WHERE secret!='s' AND token = 1":                      "SELECT a FROM t WHERE secret!='[REDACTED]' AND token = 1",
+		"SELECT token FROM t WHERE time >= 1":                                  "SELECT token FROM t WHERE time >= 1",
+	}
+	for sql, want := range cases {
+		if got := p.sql(sql); got != want {
+			t.Errorf("sql(%q) = %q, want %q", sql, got, want)
+		}
+	}
+
+	long := "SELECT a FROM t WHERE token = 'abc' AND " + strings.Repeat("b = 1 AND ", 20) + "c = 1"
+	got := p.sql(long)
+	if strings.Contains(got, "abc") || !strings.HasSuffix(got, "...(truncated)") || len(got) != 120+len("...(truncated)") {
+		t.Errorf("sql(long) = %q, want redacted and truncated to 120 bytes", got)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	p := newLogPolicy(DatasourceSettings{LogRedactTags: " Request_Resource , attribute.user_id"})
+	in := map[string]interface{}{
+		"Token":            "secret-token",
+		"request_resource": "/login?u=1",
+		"ip":               "10.0.0.1",
+		"rows": []interface{}{
+			map[string]interface{}{"attribute.user_id": "u1", "bytes": 10.0},
+			"plain",
+		},
+		"queryText": `{"sql":"SELECT 1","password":"p"}`,
+		"nested":    map[string]interface{}{"secret": map[string]interface{}{"a": 1.0}},
+	}
+	out := p.redact(in).(map[string]interface{})
+
+	if out["Token"] != redactedValue || out["request_resource"] != redactedValue {
+		t.Errorf("sensitive keys are not redacted: %v", out)
+	}
+	if out["ip"] != "10.0.0.1" {
+		t.Errorf("ip = %v", out["ip"])
+	}
+	rows := out["rows"].([]interface{})
+	if row := rows[0].(map[string]interface{}); row["attribute.user_id"] != redactedValue || row["bytes"] != 10.0 {
+		t.Errorf("rows[0] = %v", row)
+	}
+	if rows[1] != "plain" {
+		t.Errorf("rows[1] = %v", rows[1])
+	}
+	if q := out["queryText"].(string); strings.Contains(q, `"p"`) || !strings.Contains(q, "SELECT 1") {
+		t.Errorf("queryText = %s", q)
+	}
+	if nested := out["nested"].(map[string]interface{}); nested["secret"] != redactedValue {
+		t.Errorf("nested = %v", nested)
+	}
+	// 原始数据不被修改
+	if in["Token"] != "secret-token" {
+		t.Error("redact modified its input")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	p := newLogPolicy(DatasourceSettings{LogMaxPayloadBytes: 5})
+	cases := map[string]string{
+		"":       "",
+		"abcde":  "abcde",
+		"abcdef": "abcde...(truncated)",
+		// 多字节字符不被截断
+		"ab服务":  "ab服...(truncated)",
+		"abcd服": "abcd...(truncated)",
+	}
+	for in, want := range cases {
+		if got := p.truncate(in); got != want {
+			t.Errorf("truncate(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := newLogPolicy(DatasourceSettings{}).truncate(strings.Repeat("a", defaultLogMaxPayloadBytes)); len(got) != defaultLogMaxPayloadBytes {
+		t.Errorf("default limit truncated %d bytes", len(got))
+	}
+	if got := p.payload(map[string]interface{}{"token": "x"}); got != `{"tok...(truncated)` {
+		t.Errorf("payload = %q", got)
+	}
+}
+
+// TestErrorFields deepflow-server 返回错误时，请求参数中的 sql 脱敏后单独输出，不出现在错误信息中
+func TestErrorFields(t *testing.T) {
+	p := newLogPolicy(DatasourceSettings{LogRedactTags: "user_id"})
+	form := url.Values{"sql": {"SELECT a FROM t WHERE user_id = 'u1'"}}
+	err := fmt.Errorf("query: %w", &deepflowclient.StatusError{StatusCode: 500, Params: form.Encode(), Body: "bad sql near user_id = 'u1'"})
+
+	fields := p.errorFields(err)
+	got := map[string]string{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		got[fields[i].(string)] = fields[i+1].(string)
+	}
+	if strings.Contains(got["error"], "u1") || !strings.Contains(got["error"], "status code") {
+		t.Errorf("error = %q, want the status without the sensitive value", got["error"])
+	}
+	if want := "SELECT a FROM t WHERE user_id = '[REDACTED]'"; got["sql"] != want {
+		t.Errorf("sql = %q, want %q", got["sql"], want)
+	}
+
+	trace := &deepflowclient.StatusError{StatusCode: 500, Params: `{"token":"x","trace_id":"t"}`}
+	if fields := p.errorFields(trace); len(fields) != 4 || fields[2] != "params" || strings.Contains(fields[3].(string), `"x"`) {
+		t.Errorf("fields = %v, want redacted JSON params", fields)
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	p := newLogPolicy(DatasourceSettings{LogRedactTags: "x-tenant"})
+	got := p.redactHeaders(map[string]string{
+		"Authorization":      "Bearer abc",
+		"http_Cookie":        "grafana_session=1",
+		"http_X-Api-Key":     "k",
+		"X-Tenant":           "t1",
+		"Content-Type":       "application/json",
+		"http_X-Grafana-Org": "1",
+	})
+	want := map[string]string{
+		"Authorization":      redactedValue,
+		"http_Cookie":        redactedValue,
+		"http_X-Api-Key":     redactedValue,
+		"X-Tenant":           redactedValue,
+		"Content-Type":       "application/json",
+		"http_X-Grafana-Org": "1",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d headers, want %d", len(got), len(want))
+	}
+}
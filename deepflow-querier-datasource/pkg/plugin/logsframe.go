@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 默认的日志内容模板，${tag} 取同一行的值，不存在时为空
+const defaultLogsTemplate = "${request_type} ${request_domain}${request_resource} ${response_code} ${response_exception}"
+
+var (
+	logsTemplateRe = regexp.MustCompile(`\$\{([^}\s]+)\}`)
+	spacesRe       = regexp.MustCompile(`\s+`)
+)
+
+// 日志时间依次取以下列
+var logsTimeColumns = []string{"start_time", "end_time", "time"}
+
+// DeepFlow 返回的字符串时间格式
+var logsTimeLayouts = []string{"2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999", time.RFC3339Nano}
+
+// logsFormat formatAs 为 logs 时的日志 frame 配置
+type logsFormat struct {
+	template string
+	// 解析字符串时间使用的时区
+	location *time.Location
+}
+
+func newLogsFormat(s DatasourceSettings) (logsFormat, error) {
+	f := logsFormat{template: s.LogsTemplate, location: time.Local}
+	if f.template == "" {
+		f.template = defaultLogsTemplate
+	}
+	if s.LogsTimezone != "" {
+		loc, err := time.LoadLocation(s.LogsTimezone)
+		if err != nil {
+			return f, fmt.Errorf("invalid logsTimezone: %w", err)
+		}
+		f.location = loc
+	}
+	return f, nil
+}
+
+// logValue 列值转字符串，数组等其他类型按 JSON 输出
+func logValue(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// renderLogBody 按模板生成日志内容，多余的空白合并为一个空格
+func renderLogBody(template string, row tableRow) string {
+	body := logsTemplateRe.ReplaceAllStringFunc(template, func(s string) string {
+		v, ok := row.get(s[2 : len(s)-1])
+		if !ok || v == nil {
+			return ""
+		}
+		return logValue(v)
+	})
+	return strings.TrimSpace(spacesRe.ReplaceAllString(body, " "))
+}
+
+// logSeverity 由 response_status 得到日志级别，支持原始值及 Enum(response_status) 翻译后的值
+func logSeverity(status interface{}) string {
+	switch v := status.(type) {
+	case nil:
+		return "unknown"
+	case json.Number:
+		switch v.String() {
+		case "0":
+			return "info"
+		case "2", "3":
+			return "error"
+		case "4":
+			return "warning"
+		}
+		return "unknown"
+	default:
+		s := strings.ToLower(logValue(v))
+		switch {
+		case strings.Contains(s, "server") || strings.Contains(s, "服务端") ||
+			strings.Contains(s, "timeout") || strings.Contains(s, "超时"):
+			return "error"
+		case strings.Contains(s, "client") || strings.Contains(s, "客户端"):
+			return "warning"
+		case strings.Contains(s, "success") || strings.Contains(s, "正常"):
+			return "info"
+		}
+		return "unknown"
+	}
+}
+
+// parseLogTime 数值按秒、毫秒或微秒解析，字符串按 DeepFlow 的时间格式解析
+func (f logsFormat) parseLogTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case json.Number:
+		n, err := t.Float64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch {
+		case n > 1e15:
+			return time.UnixMicro(int64(n)), nil
+		case n > 1e12:
+			return time.UnixMilli(int64(n)), nil
+		}
+		return time.Unix(int64(n), 0), nil
+	case string:
+		for _, layout := range logsTimeLayouts {
+			if ts, err := time.ParseInLocation(layout, t, f.location); err == nil {
+				return ts, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("time: value: %v, unsupported format, type %T", v, v)
+}
+
+// logsFrame 将查询结果转换为 Grafana 日志 frame：timestamp、body、severity、id 及 labels
+// labels 包含除时间及 _id 外的所有非空列，用于 Explore 中的过滤
+func (f logsFormat) logsFrame(table *resultTable, template string) (*data.Frame, error) {
+	if template == "" {
+		template = f.template
+	}
+	rows := table.rows
+	timeColumn := ""
+	if rows > 0 {
+		for _, c := range logsTimeColumns {
+			if table.column(c) != nil {
+				timeColumn = c
+				break
+			}
+		}
+		if timeColumn == "" {
+			return nil, fmt.Errorf("formatAs logs needs one of the columns: %s", strings.Join(logsTimeColumns, ", "))
+		}
+	}
+
+	frame := data.NewFrame("response",
+		data.NewField("timestamp", nil, make([]time.Time, 0, rows)),
+		data.NewField("body", nil, make([]string, 0, rows)),
+		data.NewField("severity", nil, make([]string, 0, rows)),
+		data.NewField("id", nil, make([]string, 0, rows)),
+		data.NewField("labels", nil, make([]json.RawMessage, 0, rows)),
+	)
+	frame.Meta = &data.FrameMeta{
+		Type:                   data.FrameTypeLogLines,
+		TypeVersion:            data.FrameTypeVersion{0, 0},
+		PreferredVisualization: data.VisTypeLogs,
+	}
+
+	for i := 0; i < rows; i++ {
+		row := table.row(i)
+		ts, err := f.parseLogTime(row.value(timeColumn))
+		if err != nil {
+			return nil, fmt.Errorf("columns: %v, %w", timeColumn, err)
+		}
+		id := fmt.Sprintf("%d", i)
+		if v := row.value("_id"); v != nil {
+			id = logValue(v)
+		}
+
+		labels := make(map[string]string, len(table.columns))
+		for _, c := range table.columns {
+			if c.Name == timeColumn || c.Name == "_id" || c.IsNull(i) {
+				continue
+			}
+			labels[c.Name] = c.Text(i)
+		}
+		labelsJSON, err := json.Marshal(labels)
+		if err != nil {
+			return nil, err
+		}
+
+		frame.AppendRow(ts, renderLogBody(template, row), logSeverity(row.value("response_status")), id, json.RawMessage(labelsJSON))
+	}
+	return frame, nil
+}
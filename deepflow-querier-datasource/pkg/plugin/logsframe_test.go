@@ -0,0 +1,30 @@
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLogSeverity(t *testing.T) {
+	cases := []struct {
+		status interface{}
+		want   string
+	}{
+		{json.Number("0"), "info"},
+		{json.Number("2"), "error"},
+		{json.Number("3"), "error"},
+		{json.Number("4"), "warning"},
+		{json.Number("1"), "unknown"},
+		{nil, "unknown"},
+		{"正常", "info"},
+		{"超时", "error"},
+		{"Timeout", "error"},
+		{"服务端异常", "error"},
+		{"客户端异常", "warning"},
+	}
+	for _, c := range cases {
+		if got := logSeverity(c.status); got != c.want {
+			t.Errorf("logSeverity(%v) = %s, want %s", c.status, got, c.want)
+		}
+	}
+}
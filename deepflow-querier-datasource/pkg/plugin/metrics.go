@@ -0,0 +1,242 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
+)
+
+const (
+	metricsNamespace      = "deepflow_querier"
+	metricsMiddlewareName = "deepflow-metrics"
+)
+
+// 指标注册到 prometheus 默认 registry，由 SDK 在插件的 metrics 接口中暴露给 Grafana
+var (
+	queriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "queries_total",
+		Help:      "Number of panel queries by app type and status.",
+	}, []string{"app_type", "status"})
+
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "query_duration_seconds",
+		Help:      "Duration of panel queries, including requests to deepflow-server and frame building, without the queue wait.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"app_type"})
+
+	frameBuildDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "frame_build_duration_seconds",
+		Help:      "Time of a panel query not spent waiting for deepflow-server, mostly converting results to frames.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"app_type"})
+
+	queryRows = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "query_rows",
+		Help:      "Rows returned to Grafana by a panel query.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"app_type"})
+
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "Requests to deepflow-server by endpoint and status code, every retry attempt is counted.",
+	}, []string{"endpoint", "status_code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time until the response headers of deepflow-server are received.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"endpoint"})
+
+	httpResponseBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_response_bytes_total",
+		Help:      "Bytes read from deepflow-server responses.",
+	}, []string{"endpoint"})
+
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_requests_total",
+		Help:      "Query cache lookups by result, hit or miss.",
+	}, []string{"result"})
+
+	throttledQueriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "throttled_queries_total",
+		Help:      "Panel queries rejected by the rate or concurrency limits, by the limit that rejected them, datasource or user.",
+	}, []string{"scope"})
+
+	queryQueueDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "query_queue_duration_seconds",
+		Help:      "Time a panel query waited for the rate and concurrency limits.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	})
+)
+
+// metricsAppType 限制 app_type 标签的取值，避免前端传入任意值导致基数膨胀
+func metricsAppType(appType string) string {
+	switch appType {
+	case "trafficQuery", "accessRelationship", "appTracing", "appTracingFlame", "profiling":
+		return appType
+	case "":
+		return "unknown"
+	default:
+		return "other"
+	}
+}
+
+func metricsEndpoint(path string) string {
+	switch path {
+	case deepflowclient.QueryPath:
+		return "query"
+	case deepflowclient.ProfilePath:
+		return "profile"
+	case deepflowclient.TracePath:
+		return "trace"
+	default:
+		return "other"
+	}
+}
+
+// queryStats 单个查询的统计，query 中记录 appType，querierClient 累计等待 deepflow-server 的时间
+type queryStats struct {
+	mu          sync.Mutex
+	appType     string
+	querierTime time.Duration
+	// 等待速率及并发上限的时间，不计入查询耗时
+	queueWait time.Duration
+}
+
+type queryStatsKey struct{}
+
+func withQueryStats(ctx context.Context) (context.Context, *queryStats) {
+	stats := &queryStats{}
+	return context.WithValue(ctx, queryStatsKey{}, stats), stats
+}
+
+func queryStatsFromContext(ctx context.Context) *queryStats {
+	stats, _ := ctx.Value(queryStatsKey{}).(*queryStats)
+	return stats
+}
+
+func (s *queryStats) setAppType(appType string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.appType = appType
+	s.mu.Unlock()
+}
+
+func (s *queryStats) addQuerierTime(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.querierTime += d
+	s.mu.Unlock()
+}
+
+// setQueueWait 记录查询等待速率及并发上限的时间
+func (s *queryStats) setQueueWait(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.queueWait = d
+	s.mu.Unlock()
+}
+
+// observeQuery 查询结束时记录查询数、耗时、返回行数及 frame 构建时间，
+// elapsed 包括排队时间，排队时间单独记录在 query_queue_duration_seconds 中，不计入耗时
+func observeQuery(stats *queryStats, elapsed time.Duration, res backend.DataResponse, err error) {
+	stats.mu.Lock()
+	appType := metricsAppType(stats.appType)
+	querierTime := stats.querierTime
+	elapsed -= stats.queueWait
+	stats.mu.Unlock()
+
+	status := "ok"
+	if err != nil || res.Error != nil {
+		status = "error"
+	}
+	queriesTotal.WithLabelValues(appType, status).Inc()
+	queryDuration.WithLabelValues(appType).Observe(elapsed.Seconds())
+	if buildTime := elapsed - querierTime; buildTime >= 0 {
+		frameBuildDuration.WithLabelValues(appType).Observe(buildTime.Seconds())
+	}
+	if err == nil {
+		rows := 0
+		for _, frame := range res.Frames {
+			rows += frame.Rows()
+		}
+		queryRows.WithLabelValues(appType).Observe(float64(rows))
+	}
+}
+
+func observeCache(hit bool) {
+	if hit {
+		cacheRequestsTotal.WithLabelValues("hit").Inc()
+	} else {
+		cacheRequestsTotal.WithLabelValues("miss").Inc()
+	}
+}
+
+// metricsMiddleware 记录每次请求 (包括重试) 的状态码、耗时及响应字节数，需放在重试中间件之后
+func metricsMiddleware() httpclient.Middleware {
+	return httpclient.NamedMiddlewareFunc(metricsMiddlewareName, func(_ httpclient.Options, next http.RoundTripper) http.RoundTripper {
+		return httpclient.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			endpoint := metricsEndpoint(req.URL.Path)
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			httpRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+			if err != nil {
+				statusCode := "error"
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					statusCode = "canceled"
+				}
+				httpRequestsTotal.WithLabelValues(endpoint, statusCode).Inc()
+				return resp, err
+			}
+			httpRequestsTotal.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+			resp.Body = &countingBody{ReadCloser: resp.Body, counter: httpResponseBytes.WithLabelValues(endpoint)}
+			return resp, nil
+		})
+	})
+}
+
+type countingBody struct {
+	io.ReadCloser
+	counter prometheus.Counter
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.counter.Add(float64(n))
+	return n, err
+}
+
+func observeThrottled(scope string) {
+	throttledQueriesTotal.WithLabelValues(scope).Inc()
+}
+
+func observeQueueWait(d time.Duration) {
+	queryQueueDuration.Observe(d.Seconds())
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
+)
+
+// histogramSample 返回 histogram 当前的样本数及总和，指标是全局的，测试比较前后的差值
+func histogramSample(t *testing.T, o prometheus.Observer) (uint64, float64) {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := o.(prometheus.Metric).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestMetricsLabels(t *testing.T) {
+	for appType, want := range map[string]string{"trafficQuery": "trafficQuery", "profiling": "profiling", "": "unknown", "anything": "other"} {
+		if got := metricsAppType(appType); got != want {
+			t.Errorf("metricsAppType(%q) = %q, want %q", appType, got, want)
+		}
+	}
+	for path, want := range map[string]string{deepflowclient.QueryPath: "query", deepflowclient.ProfilePath: "profile", deepflowclient.TracePath: "trace", "/v1/version/": "other"} {
+		if got := metricsEndpoint(path); got != want {
+			t.Errorf("metricsEndpoint(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	const body = "table not found"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: metricsMiddleware().CreateMiddleware(httpclient.Options{}, http.DefaultTransport)}
+
+	requests := httpRequestsTotal.WithLabelValues("query", "404")
+	bytes := httpResponseBytes.WithLabelValues("query")
+	beforeRequests, beforeBytes := testutil.ToFloat64(requests), testutil.ToFloat64(bytes)
+	beforeCount, _ := histogramSample(t, httpRequestDuration.WithLabelValues("query"))
+
+	resp, err := client.Post(server.URL+deepflowclient.QueryPath, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if got := testutil.ToFloat64(requests) - beforeRequests; got != 1 {
+		t.Errorf("http_requests_total{query,404} increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(bytes) - beforeBytes; got != float64(len(body)) {
+		t.Errorf("http_response_bytes_total{query} increased by %v, want %d", got, len(body))
+	}
+	if count, _ := histogramSample(t, httpRequestDuration.WithLabelValues("query")); count != beforeCount+1 {
+		t.Errorf("http_request_duration_seconds{query} count = %d, want %d", count, beforeCount+1)
+	}
+
+	// 传输错误记为 error
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "http://" + listener.Addr().String()
+	listener.Close()
+	failed := httpRequestsTotal.WithLabelValues("trace", "error")
+	before := testutil.ToFloat64(failed)
+	if _, err := client.Post(refused+deepflowclient.TracePath, "text/plain", nil); err == nil {
+		t.Fatal("no error from a closed port")
+	}
+	if got := testutil.ToFloat64(failed) - before; got != 1 {
+		t.Errorf("http_requests_total{trace,error} increased by %v, want 1", got)
+	}
+}
+
+// TestObserveQueryQueueWait 排队时间不计入查询耗时及 frame 构建时间
+func TestObserveQueryQueueWait(t *testing.T) {
+	stats := &queryStats{}
+	stats.setAppType("profiling")
+	stats.addQuerierTime(time.Second)
+	stats.setQueueWait(5 * time.Second)
+	frame := data.NewFrame("", data.NewField("value", nil, []float64{1, 2, 3}))
+
+	queries := queriesTotal.WithLabelValues("profiling", "ok")
+	before := testutil.ToFloat64(queries)
+	durationCount, durationSum := histogramSample(t, queryDuration.WithLabelValues("profiling"))
+	buildCount, buildSum := histogramSample(t, frameBuildDuration.WithLabelValues("profiling"))
+	_, rowsSum := histogramSample(t, queryRows.WithLabelValues("profiling"))
+
+	observeQuery(stats, 7*time.Second, backend.DataResponse{Frames: data.Frames{frame}}, nil)
+
+	if got := testutil.ToFloat64(queries) - before; got != 1 {
+		t.Errorf("queries_total{profiling,ok} increased by %v, want 1", got)
+	}
+	if count, sum := histogramSample(t, queryDuration.WithLabelValues("profiling")); count != durationCount+1 || sum-durationSum != 2 {
+		t.Errorf("query_duration_seconds observed %vs, want 2s without the queue wait", sum-durationSum)
+	}
+	if count, sum := histogramSample(t, frameBuildDuration.WithLabelValues("profiling")); count != buildCount+1 || sum-buildSum != 1 {
+		t.Errorf("frame_build_duration_seconds observed %vs, want 1s", sum-buildSum)
+	}
+	if _, sum := histogramSample(t, queryRows.WithLabelValues("profiling")); sum-rowsSum != 3 {
+		t.Errorf("query_rows observed %v, want 3", sum-rowsSum)
+	}
+}
@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+
+	"deepflow-grafana-backend-plugin/pkg/fixture"
+)
+
+const (
+	recordMiddlewareName = "deepflow-record"
+	replayMiddlewareName = "deepflow-replay"
+
+	// Grafana 服务器上允许录制及回放的根目录，未设置时不能使用 recordDir、replayDir
+	debugDirEnv = "GF_PLUGIN_DEEPFLOW_DEBUG_DIR"
+)
+
+// debugDir recordDir、replayDir 由数据源编辑者配置，只能是 debugDirEnv 下的目录
+func debugDir(name, dir string) (string, error) {
+	root := os.Getenv(debugDirEnv)
+	if root == "" {
+		return "", fmt.Errorf("%s: set %s on the grafana server to enable record and replay", name, debugDirEnv)
+	}
+	rel := dir
+	if filepath.IsAbs(dir) {
+		var err error
+		if rel, err = filepath.Rel(root, dir); err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s: %s is not a directory under %s", name, dir, debugDirEnv)
+	}
+	return filepath.Join(root, rel), nil
+}
+
+// recordReplayMiddlewares 调试用：recordDir 录制每次querier、tracing请求及返回 (token 脱敏)，
+// replayDir 用录制的文件代替 deepflow-server 返回，两者放在最内层，重试的每次请求都会录制
+func recordReplayMiddlewares(s DatasourceSettings) ([]httpclient.Middleware, error) {
+	middlewares := []httpclient.Middleware{}
+	if s.RecordDir != "" {
+		dir, err := debugDir("record dir", s.RecordDir)
+		if err != nil {
+			return nil, err
+		}
+		recorder, err := fixture.NewRecorder(dir, newResultLimits(s).maxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("record dir: %w", err)
+		}
+		log.DefaultLogger.Warn("__________recording deepflow requests", "dir", dir)
+		middlewares = append(middlewares, httpclient.NamedMiddlewareFunc(recordMiddlewareName,
+			func(_ httpclient.Options, next http.RoundTripper) http.RoundTripper {
+				return recorder.RoundTripper(next)
+			}))
+	}
+	if s.ReplayDir != "" {
+		dir, err := debugDir("replay dir", s.ReplayDir)
+		if err != nil {
+			return nil, err
+		}
+		f, err := fixture.Load(dir)
+		if err != nil {
+			return nil, fmt.Errorf("replay dir: %w", err)
+		}
+		log.DefaultLogger.Warn("__________replaying recorded deepflow responses", "dir", dir, "responses", len(f.Responses))
+		middlewares = append(middlewares, httpclient.NamedMiddlewareFunc(replayMiddlewareName,
+			func(_ httpclient.Options, _ http.RoundTripper) http.RoundTripper {
+				return f.RoundTripper()
+			}))
+	}
+	return middlewares, nil
+}
@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"deepflow-grafana-backend-plugin/pkg/fixture"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	c := loadGoldenCase(t, "testdata/cases/appTracingFlame.json")
+	server := newFakeDeepflowServer(t, c.Responses)
+	root := t.TempDir()
+	t.Setenv(debugDirEnv, root)
+	dir := filepath.Join(root, "rec")
+
+	queryText, _ := json.Marshal(c.QueryText)
+	c.Query["queryText"] = string(queryText)
+	queryJSON, _ := json.Marshal(c.Query)
+	run := func(d *Datasource, shift int64) backend.DataResponse {
+		t.Helper()
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{
+				RefID:     "A",
+				JSON:      queryJSON,
+				TimeRange: backend.TimeRange{From: time.Unix(c.From+shift, 0), To: time.Unix(c.To+shift, 0)},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Responses["A"]
+	}
+
+	recorded := run(newTestDatasource(t, map[string]interface{}{
+		"requestUrl":   server.URL,
+		"traceUrl":     server.URL,
+		"token":        "secret",
+		"disableCache": true,
+		"recordDir":    "rec",
+	}), 0)
+	if recorded.Error != nil {
+		t.Fatal(recorded.Error)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != len(server.recorded()) {
+		t.Fatalf("got %d recordings, want %d", len(files), len(server.recorded()))
+	}
+	for _, file := range files {
+		b, _ := os.ReadFile(file)
+		if strings.Contains(string(b), "secret") {
+			t.Errorf("%s contains the token", filepath.Base(file))
+		}
+	}
+
+	// 回放时 deepflow-server 不可达，面板的时间范围与录制时不同
+	server.Close()
+	replay := newTestDatasource(t, map[string]interface{}{
+		"requestUrl":   server.URL,
+		"traceUrl":     server.URL,
+		"disableCache": true,
+		"replayDir":    dir,
+	})
+	clearTimingStats(recorded)
+	want, _ := json.Marshal(recorded)
+	for _, shift := range []int64{0, 3600} {
+		replayed := run(replay, shift)
+		if replayed.Error != nil {
+			t.Fatalf("shift %d: %v", shift, replayed.Error)
+		}
+		clearTimingStats(replayed)
+		if got, _ := json.Marshal(replayed); string(want) != string(got) {
+			t.Errorf("shift %d: replayed response differs\nwant: %s\ngot:  %s", shift, want, got)
+		}
+	}
+}
+
+func TestDebugDir(t *testing.T) {
+	root := t.TempDir()
+	cases := []struct {
+		dir  string
+		want string
+	}{
+		{dir: "rec", want: filepath.Join(root, "rec")},
+		{dir: filepath.Join(root, "a", "b"), want: filepath.Join(root, "a", "b")},
+		{dir: "../rec"},
+		{dir: filepath.Join(root, "..", "rec")},
+		{dir: "/etc"},
+	}
+	if _, err := debugDir("record dir", "rec"); err == nil {
+		t.Errorf("record dir is accepted without %s", debugDirEnv)
+	}
+	t.Setenv(debugDirEnv, root)
+	for _, c := range cases {
+		got, err := debugDir("record dir", c.dir)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("%s: accepted as %s", c.dir, got)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%s = %q, %v, want %q", c.dir, got, err, c.want)
+		}
+	}
+}
+
+// TestRecorderMaxBytes 超过上限的返回不录制，客户端仍读到完整的返回
+func TestRecorderMaxBytes(t *testing.T) {
+	body := `{"OPT_STATUS":"SUCCESS","result":{"columns":["a"],"values":[[1],[2],[3]]}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	for _, c := range []struct {
+		maxBytes   int64
+		recordings int
+	}{{0, 1}, {int64(len(body)), 1}, {int64(len(body)) - 1, 0}} {
+		dir := t.TempDir()
+		recorder, err := fixture.NewRecorder(dir, c.maxBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: recorder.RoundTripper(http.DefaultTransport)}
+		resp, err := client.Post(server.URL+"/v1/query/", "application/x-www-form-urlencoded", strings.NewReader("sql=SELECT+1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != body {
+			t.Errorf("max %d: client read %q", c.maxBytes, b)
+		}
+		if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != c.recordings {
+			t.Errorf("max %d: got %d recordings, want %d", c.maxBytes, len(files), c.recordings)
+		}
+	}
+}
+
+func TestFixtureMatchTimeBounds(t *testing.T) {
+	f := &fixture.Fixture{Responses: []fixture.Route{
+		{Path: "/v1/query/", Match: "SELECT a FROM t WHERE time >= 100 AND time <= 200", Body: []byte(`1`)},
+		{Path: "/v1/query/", Match: "SELECT a FROM t WHERE time >= 200 AND time <= 300", Body: []byte(`2`)},
+		{Path: "/trace", Match: `{"time_end":200,"time_start":100,"trace_id":"x"}`, Body: []byte(`3`)},
+	}}
+	form := "application/x-www-form-urlencoded"
+	cases := []struct {
+		path, body, contentType string
+		want                    string
+	}{
+		// 时间范围相同时精确匹配
+		{"/v1/query/", "sql=SELECT+a+FROM+t+WHERE+time+>%3D+200+AND+time+<%3D+300", form, "2"},
+		{"/v1/query/", "sql=SELECT+a+FROM+t+WHERE+time+>%3D+'500'+AND+time+<%3D+'600'", form, "1"},
+		{"/trace", `{"time_end":900,"time_start":800,"trace_id":"x"}`, "application/json", "3"},
+		{"/trace", `{"time_end":900,"time_start":800,"trace_id":"y"}`, "application/json", ""},
+		{"/v1/query/", "sql=SELECT+b+FROM+t+WHERE+time+>%3D+100+AND+time+<%3D+200", form, ""},
+	}
+	for _, c := range cases {
+		route, ok := f.Match(c.path, []byte(c.body), c.contentType)
+		if got := string(route.Body); ok != (c.want != "") || got != c.want {
+			t.Errorf("%s %s = %q, %v, want %q", c.path, c.body, got, ok, c.want)
+		}
+	}
+}
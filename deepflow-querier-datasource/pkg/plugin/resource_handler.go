@@ -1,6 +1,6 @@
 package plugin
 
-// test file
+// 资源接口，目前只返回示例数据，前端没有使用
 
 import (
 	"encoding/json"
@@ -0,0 +1,309 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	retryMiddlewareName = "deepflow-retry"
+
+	defaultRetryMaxAttempts       = 3
+	defaultRetryInitialBackoff    = 200 * time.Millisecond
+	defaultRetryMaxBackoff        = 5 * time.Second
+	defaultCircuitBreakerFailures = 5
+	defaultCircuitBreakerCooldown = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned without sending the request while the circuit
+// breaker of an endpoint is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// retryOptions 重试与熔断配置
+type retryOptions struct {
+	maxAttempts      int
+	initialBackoff   time.Duration
+	maxBackoff       time.Duration
+	breakerFailures  int
+	breakerCooldown  time.Duration
+	disableBreaker   bool
+	idempotentMethod map[string]bool
+}
+
+func newRetryOptions(s DatasourceSettings) retryOptions {
+	opts := retryOptions{
+		maxAttempts:     int(s.RetryMaxAttempts),
+		initialBackoff:  time.Duration(s.RetryInitialBackoff) * time.Millisecond,
+		maxBackoff:      time.Duration(s.RetryMaxBackoff) * time.Millisecond,
+		breakerFailures: int(s.CircuitBreakerFailures),
+		breakerCooldown: time.Duration(s.CircuitBreakerCooldown) * time.Second,
+		disableBreaker:  s.DisableCircuitBreaker,
+		idempotentMethod: map[string]bool{
+			http.MethodGet:     true,
+			http.MethodHead:    true,
+			http.MethodOptions: true,
+		},
+	}
+	if opts.maxAttempts <= 0 {
+		opts.maxAttempts = defaultRetryMaxAttempts
+	}
+	if opts.initialBackoff <= 0 {
+		opts.initialBackoff = defaultRetryInitialBackoff
+	}
+	if opts.maxBackoff <= 0 {
+		opts.maxBackoff = defaultRetryMaxBackoff
+	}
+	if opts.breakerFailures <= 0 {
+		opts.breakerFailures = defaultCircuitBreakerFailures
+	}
+	if opts.breakerCooldown <= 0 {
+		opts.breakerCooldown = defaultCircuitBreakerCooldown
+	}
+	return opts
+}
+
+type idempotentKey struct{}
+
+// withIdempotent 标记请求可安全重试，querier 与 trace 的查询虽然是 POST，但都是只读的
+func withIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(req *http.Request, opts retryOptions) bool {
+	if opts.idempotentMethod[req.Method] {
+		return true
+	}
+	v, _ := req.Context().Value(idempotentKey{}).(bool)
+	return v && (req.Body == nil || req.GetBody != nil)
+}
+
+// retryMiddleware 对可重试的请求按指数退避加抖动重试，并按 endpoint 熔断
+func retryMiddleware(opts retryOptions) httpclient.Middleware {
+	breakers := newCircuitBreakers(opts)
+	return httpclient.NamedMiddlewareFunc(retryMiddlewareName, func(_ httpclient.Options, next http.RoundTripper) http.RoundTripper {
+		return httpclient.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			endpoint := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+			breaker := breakers.get(endpoint)
+
+			attempts := 1
+			if isIdempotent(req, opts) {
+				attempts = opts.maxAttempts
+			}
+
+			var resp *http.Response
+			var err error
+			for attempt := 1; ; attempt++ {
+				// 每次尝试前检查，重试中熔断打开后不再请求，half-open 的探测请求也不重试
+				if !breaker.allow() {
+					if attempt > 1 {
+						return nil, fmt.Errorf("%s: %w after %d attempts, retry after %s", endpoint, ErrCircuitOpen, attempt-1, opts.breakerCooldown)
+					}
+					return nil, fmt.Errorf("%s: %w, retry after %s", endpoint, ErrCircuitOpen, opts.breakerCooldown)
+				}
+				if attempt > 1 && req.GetBody != nil {
+					body, bodyErr := req.GetBody()
+					if bodyErr != nil {
+						return nil, bodyErr
+					}
+					req.Body = body
+				}
+				resp, err = next.RoundTrip(req)
+				recordBreaker(breaker, req, resp, err)
+				retryable := isRetryable(resp, err)
+				if !retryable || attempt >= attempts || req.Context().Err() != nil {
+					return resp, err
+				}
+
+				wait, ok := backoff(opts, attempt, resp)
+				if !ok {
+					// Retry-After 超过最大退避时间，直接返回
+					return resp, err
+				}
+				log.DefaultLogger.Warn("__________retry deepflow request", "url", endpoint, "attempt", attempt, "wait", wait.String(), "status", statusOf(resp), "error", errString(err))
+				if resp != nil {
+					// 丢弃响应以便复用连接
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+				if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < wait {
+					return nil, fmt.Errorf("giving up on %s after %d attempts: %w", endpoint, attempt, context.DeadlineExceeded)
+				}
+				timer := time.NewTimer(wait)
+				select {
+				case <-req.Context().Done():
+					timer.Stop()
+					return nil, req.Context().Err()
+				case <-timer.C:
+				}
+			}
+		})
+	})
+}
+
+// recordBreaker 传输错误、超时及 5xx 响应记为失败，其他响应记为成功，调用方取消的请求不计入
+func recordBreaker(breaker *circuitBreaker, req *http.Request, resp *http.Response, err error) {
+	switch {
+	case err == nil && resp.StatusCode < http.StatusInternalServerError:
+		breaker.record(true)
+	case errors.Is(err, context.Canceled) && errors.Is(req.Context().Err(), context.Canceled):
+		breaker.cancel()
+	default:
+		breaker.record(false)
+	}
+}
+
+// isRetryable 网关错误、限流及连接被重置等瞬时错误可以重试
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		var netErr net.Error
+		return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+			errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+			(errors.As(err, &netErr) && netErr.Timeout())
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff 返回第 attempt 次重试前的等待时间，优先使用 Retry-After，
+// Retry-After 超过 maxBackoff 时不再重试
+func backoff(opts retryOptions, attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return wait, wait <= opts.maxBackoff
+		}
+	}
+	wait := opts.initialBackoff << (attempt - 1)
+	if wait <= 0 || wait > opts.maxBackoff {
+		wait = opts.maxBackoff
+	}
+	// full jitter
+	return time.Duration(rand.Int63n(int64(wait)) + 1), true
+}
+
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		wait := time.Until(t)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+func statusOf(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+type circuitBreakers struct {
+	mu       sync.Mutex
+	opts     retryOptions
+	breakers map[string]*circuitBreaker
+}
+
+func newCircuitBreakers(opts retryOptions) *circuitBreakers {
+	return &circuitBreakers{opts: opts, breakers: make(map[string]*circuitBreaker)}
+}
+
+func (c *circuitBreakers) get(endpoint string) *circuitBreaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[endpoint]
+	if !ok {
+		b = &circuitBreaker{
+			disabled:  c.opts.disableBreaker,
+			threshold: c.opts.breakerFailures,
+			cooldown:  c.opts.breakerCooldown,
+		}
+		c.breakers[endpoint] = b
+	}
+	return b
+}
+
+// circuitBreaker 连续失败 threshold 次后打开，cooldown 后放行一个探测请求 (half-open)
+type circuitBreaker struct {
+	mu        sync.Mutex
+	disabled  bool
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *circuitBreaker) allow() bool {
+	if b.disabled {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// cancel 放弃的请求不影响熔断状态，只结束探测
+func (b *circuitBreaker) cancel() {
+	if b.disabled {
+		return
+	}
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+func (b *circuitBreaker) record(success bool) {
+	if b.disabled {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
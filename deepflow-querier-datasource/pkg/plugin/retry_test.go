@@ -0,0 +1,248 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+)
+
+func testRetryOptions() retryOptions {
+	opts := newRetryOptions(DatasourceSettings{})
+	opts.initialBackoff = time.Millisecond
+	opts.maxBackoff = 10 * time.Millisecond
+	opts.breakerFailures = 3
+	opts.breakerCooldown = 50 * time.Millisecond
+	return opts
+}
+
+// newRetryClient 使用重试中间件的 http 客户端，响应头超时为 headerTimeout
+func newRetryClient(opts retryOptions, headerTimeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = headerTimeout
+	return &http.Client{Transport: retryMiddleware(opts).CreateMiddleware(httpclient.Options{}, transport)}
+}
+
+func post(t *testing.T, client *http.Client, url string, idempotent bool) (*http.Response, error) {
+	t.Helper()
+	ctx := context.Background()
+	if idempotent {
+		ctx = withIdempotent(ctx)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader("sql=show databases"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	b := &circuitBreaker{threshold: 2, cooldown: 50 * time.Millisecond}
+
+	b.record(false)
+	if !b.allow() {
+		t.Fatal("breaker opened before the threshold")
+	}
+	// 成功重置连续失败次数
+	b.record(true)
+	b.record(false)
+	if !b.allow() {
+		t.Fatal("a success did not reset the failures")
+	}
+	b.record(false)
+	if b.allow() {
+		t.Fatal("breaker is closed after 2 consecutive failures")
+	}
+
+	// cooldown 后只放行一个探测请求，探测失败时重新打开
+	time.Sleep(60 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("no probe request after the cooldown")
+	}
+	if b.allow() {
+		t.Fatal("a second request was let through while probing")
+	}
+	b.record(false)
+	if b.allow() {
+		t.Fatal("breaker is closed after a failed probe")
+	}
+
+	// 探测成功时关闭
+	time.Sleep(60 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("no probe request after the cooldown")
+	}
+	b.record(true)
+	for i := 0; i < 3; i++ {
+		if !b.allow() {
+			t.Fatal("breaker is open after a successful probe")
+		}
+	}
+}
+
+func TestCircuitBreakerCancelledProbe(t *testing.T) {
+	b := &circuitBreaker{threshold: 1, cooldown: time.Millisecond}
+	b.record(false)
+	time.Sleep(5 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("no probe request after the cooldown")
+	}
+	// 调用方取消的探测不计入，下一个请求可以继续探测
+	b.cancel()
+	if !b.allow() {
+		t.Fatal("no probe request after a cancelled probe")
+	}
+}
+
+// TestCircuitBreakerOpensOnTimeout 没有响应的服务端在连续超时后熔断，不再发送请求
+func TestCircuitBreakerOpensOnTimeout(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(300 * time.Millisecond):
+		}
+	}))
+	defer server.Close()
+	client := newRetryClient(testRetryOptions(), 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if _, err := post(t, client, server.URL, false); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("request %d: error = %v, want a timeout", i, err)
+		}
+	}
+	if _, err := post(t, client, server.URL, false); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("error = %v, want %v", err, ErrCircuitOpen)
+	}
+	if n := received.Load(); n != 3 {
+		t.Errorf("server received %d requests, want 3", n)
+	}
+}
+
+// TestCircuitBreakerOpensOn5xx 不可重试的 5xx 也记为失败
+func TestCircuitBreakerOpensOn5xx(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+	opts := testRetryOptions()
+	client := newRetryClient(opts, time.Second)
+
+	for i := 0; i < 3; i++ {
+		resp, err := post(t, client, server.URL, true)
+		if err != nil || resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("request %d: status = %d, error = %v", i, statusOf(resp), err)
+		}
+	}
+	if _, err := post(t, client, server.URL, true); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// cooldown 后的探测请求成功，熔断关闭
+	status.Store(http.StatusOK)
+	time.Sleep(opts.breakerCooldown + 10*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if resp, err := post(t, client, server.URL, true); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d after the cooldown: status = %d, error = %v", i, statusOf(resp), err)
+		}
+	}
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if received.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	client := newRetryClient(testRetryOptions(), time.Second)
+
+	resp, err := post(t, client, server.URL, true)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, error = %v, want 200 after retries", statusOf(resp), err)
+	}
+	if n := received.Load(); n != 3 {
+		t.Errorf("server received %d requests, want 3", n)
+	}
+
+	// 非幂等请求不重试
+	received.Store(0)
+	resp, err = post(t, client, server.URL, false)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, error = %v, want 503 without retries", statusOf(resp), err)
+	}
+	if n := received.Load(); n != 1 {
+		t.Errorf("server received %d requests, want 1", n)
+	}
+}
+
+// TestRetryStopsWhenBreakerOpens 重试中熔断打开后不再请求，cooldown 后的探测请求失败时不重试
+func TestRetryStopsWhenBreakerOpens(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	opts := testRetryOptions()
+	opts.maxAttempts = 5
+	opts.breakerFailures = 2
+	client := newRetryClient(opts, time.Second)
+
+	if _, err := post(t, client, server.URL, true); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error = %v, want %v", err, ErrCircuitOpen)
+	}
+	if n := received.Load(); n != 2 {
+		t.Errorf("server received %d requests, want 2 before the breaker opened", n)
+	}
+
+	received.Store(0)
+	time.Sleep(opts.breakerCooldown + 10*time.Millisecond)
+	if _, err := post(t, client, server.URL, true); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("probe: error = %v, want %v", err, ErrCircuitOpen)
+	}
+	if n := received.Load(); n != 1 {
+		t.Errorf("server received %d probe requests, want 1", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	opts := retryOptions{initialBackoff: 100 * time.Millisecond, maxBackoff: time.Second}
+	for attempt, limit := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		for i := 0; i < 20; i++ {
+			wait, ok := backoff(opts, attempt, nil)
+			if !ok || wait <= 0 || wait > limit {
+				t.Fatalf("attempt %d: wait = %s, want (0, %s]", attempt, wait, limit)
+			}
+		}
+	}
+
+	retryAfter := func(v string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{v}}}
+	}
+	if wait, ok := backoff(opts, 1, retryAfter("1")); !ok || wait != time.Second {
+		t.Errorf("Retry-After 1: wait = %s, %v, want 1s", wait, ok)
+	}
+	if _, ok := backoff(opts, 1, retryAfter("10")); ok {
+		t.Error("Retry-After longer than the maximum backoff is retried")
+	}
+	if wait, ok := backoff(opts, 1, retryAfter("invalid")); !ok || wait > 100*time.Millisecond {
+		t.Errorf("invalid Retry-After: wait = %s, %v, want the exponential backoff", wait, ok)
+	}
+}
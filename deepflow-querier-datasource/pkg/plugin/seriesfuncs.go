@@ -0,0 +1,304 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 时序函数，在分组后的每条序列上依次执行，写法如 rate | movingAverage(5) | topK(10, avg)
+// topK/bottomK 在所有序列之间比较，其余函数只作用于单条序列中查询返回的 metric 字段
+
+var seriesFuncRe = regexp.MustCompile(`^([A-Za-z]+)\s*(?:\((.*)\))?$`)
+
+// seriesFunc 解析后的一个时序函数
+type seriesFunc struct {
+	name   string
+	params []string
+}
+
+// 各函数的参数个数
+var seriesFuncParams = map[string][2]int{
+	"rate":          {0, 0},
+	"increase":      {0, 0},
+	"derivative":    {0, 0},
+	"movingAverage": {1, 1},
+	"movingMedian":  {1, 1},
+	"cumulativeSum": {0, 0},
+	"scale":         {1, 1},
+	"topK":          {1, 2},
+	"bottomK":       {1, 2},
+}
+
+// 排序使用的聚合方式
+var seriesAggregators = map[string]func([]float64) float64{
+	"avg": func(v []float64) float64 { return sumOf(v) / float64(len(v)) },
+	"sum": sumOf,
+	"max": func(v []float64) float64 {
+		m := v[0]
+		for _, f := range v[1:] {
+			m = math.Max(m, f)
+		}
+		return m
+	},
+	"min": func(v []float64) float64 {
+		m := v[0]
+		for _, f := range v[1:] {
+			m = math.Min(m, f)
+		}
+		return m
+	},
+	"last": func(v []float64) float64 { return v[len(v)-1] },
+}
+
+func sumOf(v []float64) float64 {
+	s := 0.0
+	for _, f := range v {
+		s += f
+	}
+	return s
+}
+
+// parseSeriesFuncs 解析 queryText 中的 seriesFunctions
+func parseSeriesFuncs(s string) ([]seriesFunc, error) {
+	funcs := []seriesFunc{}
+	for _, stage := range strings.Split(s, "|") {
+		stage = strings.TrimSpace(stage)
+		if stage == "" {
+			continue
+		}
+		m := seriesFuncRe.FindStringSubmatch(stage)
+		if m == nil {
+			return nil, fmt.Errorf("series function %q: invalid syntax", stage)
+		}
+		f := seriesFunc{name: m[1]}
+		if strings.TrimSpace(m[2]) != "" {
+			for _, p := range strings.Split(m[2], ",") {
+				f.params = append(f.params, strings.TrimSpace(p))
+			}
+		}
+		n, ok := seriesFuncParams[f.name]
+		if !ok {
+			return nil, fmt.Errorf("series function %q: unknown function", f.name)
+		}
+		if len(f.params) < n[0] || len(f.params) > n[1] {
+			return nil, fmt.Errorf("series function %q: expects %d to %d parameters, got %d", f.name, n[0], n[1], len(f.params))
+		}
+		if err := f.validate(); err != nil {
+			return nil, err
+		}
+		funcs = append(funcs, f)
+	}
+	return funcs, nil
+}
+
+func (f seriesFunc) validate() error {
+	switch f.name {
+	case "movingAverage", "movingMedian", "topK", "bottomK":
+		if n, err := strconv.Atoi(f.params[0]); err != nil || n <= 0 {
+			return fmt.Errorf("series function %q: %q is not a positive integer", f.name, f.params[0])
+		}
+	case "scale":
+		if _, err := strconv.ParseFloat(f.params[0], 64); err != nil {
+			return fmt.Errorf("series function %q: %q is not a number", f.name, f.params[0])
+		}
+	}
+	if f.name == "topK" || f.name == "bottomK" {
+		if _, ok := seriesAggregators[f.aggregator()]; !ok {
+			return fmt.Errorf("series function %q: unknown aggregator %q, use avg, sum, max, min or last", f.name, f.aggregator())
+		}
+	}
+	return nil
+}
+
+func (f seriesFunc) intParam() int {
+	n, _ := strconv.Atoi(f.params[0])
+	return n
+}
+
+func (f seriesFunc) aggregator() string {
+	if len(f.params) > 1 {
+		return f.params[1]
+	}
+	return "avg"
+}
+
+// applySeriesFuncs 依次执行时序函数，frames 为分组后的序列，每个 frame 一条，
+// metrics 为返回的 metric 字段的下标，数值类型的 tag 等其余字段不变
+func applySeriesFuncs(frames data.Frames, funcs []seriesFunc, metrics []int) data.Frames {
+	if len(metrics) == 0 {
+		return frames
+	}
+	for _, f := range funcs {
+		switch f.name {
+		case "topK", "bottomK":
+			frames = topKFrames(frames, metrics[0], f.intParam(), f.aggregator(), f.name == "bottomK")
+		default:
+			for _, frame := range frames {
+				times := frameTimes(frame)
+				for _, i := range metrics {
+					if i >= len(frame.Fields) || frame.Fields[i].Type() != data.FieldTypeNullableFloat64 {
+						continue
+					}
+					applySeriesFunc(f, times, frame.Fields[i])
+				}
+			}
+		}
+	}
+	return frames
+}
+
+// frameTimes 序列的时间字段，没有时间字段时返回 nil
+func frameTimes(frame *data.Frame) []time.Time {
+	for _, field := range frame.Fields {
+		if field.Type() != data.FieldTypeTime {
+			continue
+		}
+		times := make([]time.Time, field.Len())
+		for i := range times {
+			times[i] = field.At(i).(time.Time)
+		}
+		return times
+	}
+	return nil
+}
+
+func fieldValues(field *data.Field) []*float64 {
+	values := make([]*float64, field.Len())
+	for i := range values {
+		values[i] = field.At(i).(*float64)
+	}
+	return values
+}
+
+func applySeriesFunc(f seriesFunc, times []time.Time, field *data.Field) {
+	values := fieldValues(field)
+	out := make([]*float64, len(values))
+	switch f.name {
+	case "rate", "increase", "derivative":
+		// 与前一个非空点比较，rate/increase 遇到计数器重置时从 0 开始计算
+		prev := -1
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			if prev >= 0 {
+				delta := *v - *values[prev]
+				if delta < 0 && f.name != "derivative" {
+					delta = *v
+				}
+				if f.name == "increase" {
+					out[i] = &delta
+				} else if times != nil {
+					if seconds := times[i].Sub(times[prev]).Seconds(); seconds > 0 {
+						r := delta / seconds
+						out[i] = &r
+					}
+				}
+			}
+			prev = i
+		}
+	case "movingAverage", "movingMedian":
+		// 窗口为当前点及之前的 n-1 个非空点
+		n := f.intParam()
+		window := make([]float64, 0, n)
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			window = append(window, *v)
+			if len(window) > n {
+				window = window[1:]
+			}
+			var r float64
+			if f.name == "movingAverage" {
+				r = sumOf(window) / float64(len(window))
+			} else {
+				r = median(window)
+			}
+			out[i] = &r
+		}
+	case "cumulativeSum":
+		sum := 0.0
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			sum += *v
+			r := sum
+			out[i] = &r
+		}
+	case "scale":
+		factor, _ := strconv.ParseFloat(f.params[0], 64)
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			r := *v * factor
+			out[i] = &r
+		}
+	}
+	for i, v := range out {
+		field.Set(i, v)
+	}
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// topKFrames 按下标为 metric 的字段的聚合值保留前 k 条序列，没有数据的序列排在最后
+func topKFrames(frames data.Frames, metric, k int, aggregator string, bottom bool) data.Frames {
+	type ranked struct {
+		frame *data.Frame
+		value float64
+		ok    bool
+	}
+	rankedFrames := make([]ranked, len(frames))
+	for i, frame := range frames {
+		rankedFrames[i] = ranked{frame: frame}
+		if metric >= len(frame.Fields) || frame.Fields[metric].Type() != data.FieldTypeNullableFloat64 {
+			continue
+		}
+		values := []float64{}
+		for _, v := range fieldValues(frame.Fields[metric]) {
+			if v != nil {
+				values = append(values, *v)
+			}
+		}
+		if len(values) > 0 {
+			rankedFrames[i].value = seriesAggregators[aggregator](values)
+			rankedFrames[i].ok = true
+		}
+	}
+	sort.SliceStable(rankedFrames, func(i, j int) bool {
+		a, b := rankedFrames[i], rankedFrames[j]
+		if a.ok != b.ok {
+			return a.ok
+		}
+		if bottom {
+			return a.value < b.value
+		}
+		return a.value > b.value
+	})
+	if k > len(rankedFrames) {
+		k = len(rankedFrames)
+	}
+	result := make(data.Frames, k)
+	for i := range result {
+		result[i] = rankedFrames[i].frame
+	}
+	return result
+}
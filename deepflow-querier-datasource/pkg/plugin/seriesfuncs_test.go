@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func floats(values ...interface{}) []*float64 {
+	out := make([]*float64, len(values))
+	for i, v := range values {
+		if f, ok := v.(float64); ok {
+			out[i] = &f
+		}
+	}
+	return out
+}
+
+func seriesFrame(values []*float64) *data.Frame {
+	times := make([]time.Time, len(values))
+	for i := range times {
+		times[i] = time.Unix(int64(1700000000+60*i), 0)
+	}
+	return data.NewFrame("", data.NewField("time", nil, times), data.NewField("value", nil, values))
+}
+
+func TestSeriesFuncs(t *testing.T) {
+	tests := []struct {
+		funcs string
+		in    []*float64
+		want  []*float64
+	}{
+		// 计数器在第 4 个点重置
+		{"rate", floats(60.0, 120.0, nil, 240.0, 60.0), floats(nil, 1.0, nil, 1.0, 1.0)},
+		{"increase", floats(60.0, 120.0, nil, 240.0, 60.0), floats(nil, 60.0, nil, 120.0, 60.0)},
+		{"derivative", floats(60.0, 120.0, 60.0), floats(nil, 1.0, -1.0)},
+		{"movingAverage(2)", floats(1.0, 3.0, nil, 5.0), floats(1.0, 2.0, nil, 4.0)},
+		{"movingMedian(3)", floats(1.0, 9.0, 2.0, 3.0), floats(1.0, 5.0, 2.0, 3.0)},
+		{"cumulativeSum | scale(0.5)", floats(1.0, nil, 3.0), floats(0.5, nil, 2.0)},
+	}
+	for _, tt := range tests {
+		funcs, err := parseSeriesFuncs(tt.funcs)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.funcs, err)
+		}
+		frames := applySeriesFuncs(data.Frames{seriesFrame(tt.in)}, funcs, []int{1})
+		got := fieldValues(frames[0].Fields[1])
+		for i := range tt.want {
+			if (got[i] == nil) != (tt.want[i] == nil) || got[i] != nil && *got[i] != *tt.want[i] {
+				t.Errorf("%s: value %d = %v, want %v", tt.funcs, i, deref(got[i]), deref(tt.want[i]))
+			}
+		}
+	}
+}
+
+func deref(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func TestSeriesFuncsTopK(t *testing.T) {
+	frames := data.Frames{
+		seriesFrame(floats(1.0, 2.0)),
+		seriesFrame(floats(nil, nil)),
+		seriesFrame(floats(10.0, 0.0)),
+		seriesFrame(floats(4.0, 4.0)),
+	}
+	funcs, err := parseSeriesFuncs("bottomK(3, avg)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := applySeriesFuncs(frames, funcs, []int{1})
+	if len(got) != 3 || got[0] != frames[0] || got[1] != frames[3] || got[2] != frames[2] {
+		t.Errorf("bottomK kept the wrong series")
+	}
+}
+
+// TestSeriesFuncsMetricFields 数值类型的 tag 字段不被时序函数修改，topK 按 metric 字段排序
+func TestSeriesFuncsMetricFields(t *testing.T) {
+	frame := func(id float64, values []*float64) *data.Frame {
+		f := seriesFrame(values)
+		f.Fields = append(f.Fields, data.NewField("pod_id", nil, floats(id, id)))
+		return f
+	}
+	frames := data.Frames{frame(1, floats(1.0, 2.0)), frame(2, floats(5.0, 8.0))}
+	funcs, err := parseSeriesFuncs("scale(10) | topK(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := applySeriesFuncs(frames, funcs, []int{1})
+	if len(got) != 1 || got[0] != frames[1] {
+		t.Fatalf("topK kept the wrong series")
+	}
+	if v := fieldValues(got[0].Fields[1]); *v[0] != 50 || *v[1] != 80 {
+		t.Errorf("metric = %v, %v", *v[0], *v[1])
+	}
+	if v := fieldValues(got[0].Fields[2]); *v[0] != 2 || *v[1] != 2 {
+		t.Errorf("pod_id = %v, %v, want unchanged", *v[0], *v[1])
+	}
+}
+
+func TestParseSeriesFuncsErrors(t *testing.T) {
+	for _, s := range []string{"foo", "rate(1)", "movingAverage", "movingAverage(0)", "scale(x)", "topK(5, p99)"} {
+		if _, err := parseSeriesFuncs(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+)
+
+// 数据源配置项，ConfigEditor 中的输入框提交的是字符串，数值类配置统一用 jsonInt 解析
+type DatasourceSettings struct {
+	RequestUrl string `json:"requestUrl"`
+	TraceUrl   string `json:"traceUrl"`
+	Token      string `json:"token"`
+
+	// 超时，单位秒，与 SDK 的 jsonData 字段名保持一致
+	Timeout             jsonInt `json:"timeout"`
+	DialTimeout         jsonInt `json:"dialTimeout"`
+	TLSHandshakeTimeout jsonInt `json:"httpTLSHandshakeTimeout"`
+
+	// 返回数据上限，MaxResponseBytes 单位 MB
+	MaxRows          jsonInt `json:"maxRows"`
+	MaxResponseBytes jsonInt `json:"maxResponseBytes"`
+
+	// 查询结果缓存
+	DisableCache   bool    `json:"disableCache"`
+	CacheBackend   string  `json:"cacheBackend"`
+	CacheTTL       jsonInt `json:"cacheTTL"`
+	CacheMaxMemory jsonInt `json:"cacheMaxMemory"`
+	CacheAlignment jsonInt `json:"cacheAlignment"`
+
+	// 重试与熔断
+	RetryMaxAttempts       jsonInt `json:"retryMaxAttempts"`
+	RetryInitialBackoff    jsonInt `json:"retryInitialBackoff"`
+	RetryMaxBackoff        jsonInt `json:"retryMaxBackoff"`
+	DisableCircuitBreaker  bool    `json:"disableCircuitBreaker"`
+	CircuitBreakerFailures jsonInt `json:"circuitBreakerFailures"`
+	CircuitBreakerCooldown jsonInt `json:"circuitBreakerCooldown"`
+
+	// 日志
+	LogMaxPayloadBytes jsonInt `json:"logMaxPayloadBytes"`
+	LogSampleRate      jsonInt `json:"logSampleRate"`
+	LogRedactTags      string  `json:"logRedactTags"`
+
+	// appTracing 结果中 _id、trace_id 跳转火焰图的链接，TracingLinkPadding 单位秒
+	TracingLinks       bool    `json:"tracingLinks"`
+	TracingLinkPadding jsonInt `json:"tracingLinkPadding"`
+
+	// formatAs 为 logs 时的日志内容模板，及解析字符串时间使用的时区
+	LogsTemplate string `json:"logsTemplate"`
+	LogsTimezone string `json:"logsTimezone"`
+
+	// 根据 tag 元数据翻译枚举 tag，EnumCacheTTL 单位秒
+	TranslateEnums bool    `json:"translateEnums"`
+	EnumCacheTTL   jsonInt `json:"enumCacheTTL"`
+
+	// 多集群，requestUrl/traceUrl 为名为 ClusterName 的默认集群，Clusters 为其他集群
+	ClusterName string            `json:"clusterName"`
+	Clusters    []ClusterSettings `json:"clusters"`
+
+	// 查询保护，MaxTimeRange 为逗号分隔的 <数据精度>=<秒>，* 为其他精度，时间范围不超过 EstimateMinTimeRange 秒的查询不预估行数
+	RequireTimeFilter    bool    `json:"requireTimeFilter"`
+	MaxLimit             jsonInt `json:"maxLimit"`
+	MaxSlimit            jsonInt `json:"maxSlimit"`
+	MaxTimeRange         string  `json:"maxTimeRange"`
+	BlockedTables        string  `json:"blockedTables"`
+	BlockedColumns       string  `json:"blockedColumns"`
+	MaxEstimatedRows     jsonInt `json:"maxEstimatedRows"`
+	EstimateMinTimeRange jsonInt `json:"estimateMinTimeRange"`
+
+	// 查询速率及并发上限，QueryRateLimit 单位次/秒，QueryQueueTimeout 单位秒，User 开头的为每个 Grafana 用户的上限
+	QueryRateLimit           jsonInt `json:"queryRateLimit"`
+	QueryBurst               jsonInt `json:"queryBurst"`
+	MaxConcurrentQueries     jsonInt `json:"maxConcurrentQueries"`
+	UserQueryRateLimit       jsonInt `json:"userQueryRateLimit"`
+	UserQueryBurst           jsonInt `json:"userQueryBurst"`
+	UserMaxConcurrentQueries jsonInt `json:"userMaxConcurrentQueries"`
+	QueryQueueTimeout        jsonInt `json:"queryQueueTimeout"`
+
+	// 调试：录制及回放 deepflow-server 的请求
+	RecordDir string `json:"recordDir"`
+	ReplayDir string `json:"replayDir"`
+}
+
+// jsonInt accepts both JSON numbers and numeric strings, empty string means 0.
+type jsonInt int64
+
+func (i *jsonInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(strings.TrimSpace(string(b)), `"`)
+	if s == "" || s == "null" {
+		*i = 0
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %s: %w", string(b), err)
+	}
+	*i = jsonInt(v)
+	return nil
+}
+
+// loadSettings 解析 settings.JSONData
+func loadSettings(settings backend.DataSourceInstanceSettings) (DatasourceSettings, error) {
+	s := DatasourceSettings{}
+	if len(settings.JSONData) == 0 {
+		return s, nil
+	}
+	if err := json.Unmarshal(settings.JSONData, &s); err != nil {
+		return s, fmt.Errorf("settings.JSONData decoding failed: %w", err)
+	}
+	return s, nil
+}
+
+const (
+	defaultTimeout             = 300 * time.Second
+	defaultDialTimeout         = 10 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// newTimeoutOptions 基于 SDK 默认值生成数据源自己的超时配置
+func newTimeoutOptions(s DatasourceSettings) *httpclient.TimeoutOptions {
+	timeouts := httpclient.DefaultTimeoutOptions
+	timeouts.Timeout = secondsOr(s.Timeout, defaultTimeout)
+	timeouts.DialTimeout = secondsOr(s.DialTimeout, defaultDialTimeout)
+	timeouts.TLSHandshakeTimeout = secondsOr(s.TLSHandshakeTimeout, defaultTLSHandshakeTimeout)
+	return &timeouts
+}
+
+func secondsOr(v jsonInt, def time.Duration) time.Duration {
+	if v <= 0 {
+		return def
+	}
+	return time.Duration(v) * time.Second
+}
@@ -0,0 +1,238 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
+	"deepflow-grafana-backend-plugin/pkg/formattools"
+)
+
+// resultTable querier返回的结果，按列保存，toString(_id)、资源字段及枚举翻译等派生列也按列计算，
+// 转换 frame 时不为每行构造 map
+type resultTable struct {
+	columns []*deepflowclient.Column
+	rows    int
+}
+
+// newResultTable 同名的列只保留最后一列，toString(_id) 转换为 _id 列，值加 id- 前缀
+func newResultTable(values *deepflowclient.Values) *resultTable {
+	t := &resultTable{rows: values.Len()}
+	if values == nil {
+		return t
+	}
+	for _, c := range values.Columns {
+		if c.Name == "toString(_id)" {
+			id := deepflowclient.NewColumn("_id")
+			for i := 0; i < t.rows; i++ {
+				id.Append("id-" + c.Text(i))
+			}
+			c = id
+		}
+		t.set(c)
+	}
+	return t
+}
+
+// set 加入一列，已有同名的列时在原位置替换
+func (t *resultTable) set(c *deepflowclient.Column) {
+	for i, existing := range t.columns {
+		if existing.Name == c.Name {
+			t.columns[i] = c
+			return
+		}
+	}
+	t.columns = append(t.columns, c)
+}
+
+// column 按列名取列，不存在时返回 nil
+func (t *resultTable) column(name string) *deepflowclient.Column {
+	for _, c := range t.columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// names 排序后的列名
+func (t *resultTable) names() []string {
+	names := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		names[i] = c.Name
+	}
+	sort.Strings(names)
+	return names
+}
+
+// row 第 i 行
+func (t *resultTable) row(i int) tableRow {
+	return tableRow{table: t, index: i}
+}
+
+// rowMap 第 i 行转换为 map，只用于调试日志等少量行
+func (t *resultTable) rowMap(i int) map[string]interface{} {
+	row := make(map[string]interface{}, len(t.columns))
+	for _, c := range t.columns {
+		row[c.Name] = c.Value(i)
+	}
+	return row
+}
+
+// tableRow 结果中的一行，按列名取值
+type tableRow struct {
+	table *resultTable
+	index int
+}
+
+// get 列名对应的值，列不存在时 ok 为 false
+func (r tableRow) get(name string) (v interface{}, ok bool) {
+	c := r.table.column(name)
+	if c == nil {
+		return nil, false
+	}
+	return c.Value(r.index), true
+}
+
+// value 列名对应的值，列不存在时为 nil
+func (r tableRow) value(name string) interface{} {
+	v, _ := r.get(name)
+	return v
+}
+
+// addResourceColumns 按 client_node_type/server_node_type 计算资源字段，
+// 每行只用 _0/_1 后缀的列构造 formattools.AddResourceFieldsInData 需要的 map 并复用
+func (t *resultTable) addResourceColumns(role string) {
+	suffix := "_0"
+	if role == "server" {
+		suffix = "_1"
+	}
+	prefix := role + "_"
+	var source []*deepflowclient.Column
+	for _, c := range t.columns {
+		if strings.HasSuffix(c.Name, suffix) || c.Name == "gprocess" || c.Name == prefix+"node_type" {
+			source = append(source, c)
+		}
+	}
+
+	names := []string{prefix + "resource_id", prefix + "resource", prefix + "resource_type"}
+	derived := make([]*deepflowclient.Column, len(names))
+	row := make(map[string]interface{}, len(source)+len(names))
+	for i := 0; i < t.rows; i++ {
+		for k := range row {
+			delete(row, k)
+		}
+		for _, c := range source {
+			row[c.Name] = c.Value(i)
+		}
+		formattools.AddResourceFieldsInData(row, role)
+		for j, name := range names {
+			v, ok := row[name]
+			if derived[j] == nil {
+				if !ok {
+					continue
+				}
+				// 之前的行没有该字段，补空值
+				derived[j] = deepflowclient.NewColumn(name)
+				for k := 0; k < i; k++ {
+					derived[j].Append(nil)
+				}
+			}
+			derived[j].Append(v)
+		}
+	}
+	for _, c := range derived {
+		if c != nil {
+			t.set(c)
+		}
+	}
+}
+
+// translateEnums 为有翻译的枚举列加入 Enum(列名) 列，值为显示名称，没有对应名称时为原始值，空值为空字符串
+func (t *resultTable) translateEnums(translations map[string]map[string]string) {
+	for column, values := range translations {
+		c := t.column(column)
+		if c == nil {
+			continue
+		}
+		enum := deepflowclient.NewColumn(enumColumnName(column))
+		for i := 0; i < t.rows; i++ {
+			if c.IsNull(i) {
+				enum.Append("")
+				continue
+			}
+			raw := c.Text(i)
+			if name, ok := values[raw]; ok {
+				enum.Append(name)
+			} else {
+				enum.Append(raw)
+			}
+		}
+		t.set(enum)
+	}
+}
+
+// MarshalJSON 按行输出，用于调试日志
+func (t *resultTable) MarshalJSON() ([]byte, error) {
+	rows := make([]map[string]interface{}, t.rows)
+	for i := range rows {
+		rows[i] = t.rowMap(i)
+	}
+	return json.Marshal(rows)
+}
+
+// columnField 按 formatParams 得到的字段类型转换一列，rows 为 nil 时转换所有行，否则只转换 rows 中的行
+func columnField(name string, fieldType interface{}, column *deepflowclient.Column, rows []int) (*data.Field, error) {
+	n := column.Len()
+	if rows != nil {
+		n = len(rows)
+	}
+	index := func(k int) int {
+		if rows == nil {
+			return k
+		}
+		return rows[k]
+	}
+
+	switch fieldType.(type) {
+	case []time.Time:
+		values := make([]time.Time, n)
+		for k := range values {
+			tv, ok := column.Float(index(k))
+			if !ok {
+				v := column.Value(index(k))
+				return nil, fmt.Errorf("time: columns: %v, value: %v, assertion failed, type %T", column.Name, v, v)
+			}
+			values[k] = time.Unix(int64(tv), 0)
+		}
+		return data.NewField(name, nil, values), nil
+	case []*float64:
+		// 非空值指向同一个底层数组
+		floats := make([]float64, n)
+		values := make([]*float64, n)
+		for k := range values {
+			if column.IsNull(index(k)) {
+				continue
+			}
+			mv, ok := column.Float(index(k))
+			if !ok {
+				v := column.Value(index(k))
+				return nil, fmt.Errorf("columns: %v, value: %v, failed to convert float64, type %T", column.Name, v, v)
+			}
+			floats[k] = mv
+			values[k] = &floats[k]
+		}
+		return data.NewField(name, nil, values), nil
+	default:
+		values := make([]string, n)
+		for k := range values {
+			values[k] = column.Text(index(k))
+		}
+		return data.NewField(name, nil, values), nil
+	}
+}
@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"deepflow-grafana-backend-plugin/pkg/deepflowclient"
+	"deepflow-grafana-backend-plugin/pkg/formattools"
+)
+
+func testValues(t *testing.T, columns []string, rows ...[]interface{}) *deepflowclient.Values {
+	t.Helper()
+	values := deepflowclient.NewValues(columns)
+	for _, row := range rows {
+		if err := values.AppendRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return values
+}
+
+// TestResultTableResourceColumns 按列计算的资源字段与逐行调用 AddResourceFieldsInData 的结果一致
+func TestResultTableResourceColumns(t *testing.T) {
+	columns := []string{"toString(_id)", "client_node_type", "ip_0", "ip_id_0", "pod_0", "pod_id_0", "server_node_type", "auto_service_1", "auto_service_id_1"}
+	type n = json.Number
+	values := testValues(t, columns,
+		[]interface{}{n("1"), "ip", "10.0.0.1", n("3"), nil, nil, "pod", "svc", n("7")},
+		[]interface{}{n("2"), "pod", "10.0.0.2", n("4"), "pod-a", n("5"), "pod", nil, nil},
+	)
+	table := newResultTable(values)
+	table.addResourceColumns("client")
+	table.addResourceColumns("server")
+
+	for i := 0; i < values.Len(); i++ {
+		want := map[string]interface{}{}
+		for j, c := range columns {
+			want[c] = values.Row(i)[j]
+		}
+		formattools.AddResourceFieldsInData(want, "client")
+		formattools.AddResourceFieldsInData(want, "server")
+		want["_id"] = "id-" + want["toString(_id)"].(json.Number).String()
+		delete(want, "toString(_id)")
+
+		if got := table.rowMap(i); !reflect.DeepEqual(got, want) {
+			t.Errorf("row %d = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestResultTableTranslateEnums(t *testing.T) {
+	table := newResultTable(testValues(t, []string{"response_status"},
+		[]interface{}{json.Number("0")}, []interface{}{nil}, []interface{}{json.Number("9")}))
+	table.translateEnums(map[string]map[string]string{"response_status": {"0": "正常"}})
+
+	c := table.column("Enum(response_status)")
+	if c == nil {
+		t.Fatal("no Enum(response_status) column")
+	}
+	for i, want := range []string{"正常", "", "9"} {
+		if got := c.Text(i); got != want {
+			t.Errorf("row %d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestColumnField(t *testing.T) {
+	column := testValues(t, []string{"v"},
+		[]interface{}{json.Number("3")}, []interface{}{nil}, []interface{}{json.Number("1.5")}).Columns[0]
+
+	field, err := columnField("v", []*float64{}, column, []int{2, 1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []interface{}{}
+	for i := 0; i < field.Len(); i++ {
+		if v := field.At(i).(*float64); v != nil {
+			got = append(got, *v)
+		} else {
+			got = append(got, nil)
+		}
+	}
+	if want := []interface{}{1.5, nil, 3.0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("float field = %v, want %v", got, want)
+	}
+
+	field, err = columnField("t", []time.Time{}, column, []int{0})
+	if err != nil || !field.At(0).(time.Time).Equal(time.Unix(3, 0)) {
+		t.Errorf("time field = %v, %v", field, err)
+	}
+	if _, err := columnField("t", []time.Time{}, column, nil); err == nil {
+		t.Error("null time value is converted")
+	}
+
+	field, _ = columnField("s", []string{}, column, nil)
+	if got := []string{field.At(0).(string), field.At(1).(string), field.At(2).(string)}; !reflect.DeepEqual(got, []string{"3", "null", "1.5"}) {
+		t.Errorf("string field = %v", got)
+	}
+}
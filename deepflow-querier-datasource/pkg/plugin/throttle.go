@@ -0,0 +1,224 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	defaultQueryQueueTimeout = 30 * time.Second
+	// 超过该时间没有查询的用户限流状态被清理
+	userThrottleIdle = 10 * time.Minute
+)
+
+// ErrTooManyQueries is returned when a query waited longer than the queue
+// timeout for the rate or concurrency limits of the datasource or the user.
+var ErrTooManyQueries = errors.New("too many concurrent DeepFlow queries")
+
+// tokenBucket 令牌桶，每秒补充 rate 个令牌，最多 burst 个
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int64) *tokenBucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve 预定一个令牌，返回需要等待的时间，令牌可以为负数表示排队中的查询
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel 归还放弃等待的查询预定的令牌
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	b.tokens = min(b.burst, b.tokens+1)
+	b.mu.Unlock()
+}
+
+// queryLimiter 一个数据源或一个用户的速率及并发上限，未配置的部分为 nil
+type queryLimiter struct {
+	bucket *tokenBucket
+	slots  chan struct{}
+	// 只用于清理空闲的用户
+	lastUsed time.Time
+}
+
+func newQueryLimiter(rate, burst, concurrency int64) *queryLimiter {
+	l := &queryLimiter{}
+	if rate > 0 {
+		l.bucket = newTokenBucket(rate, burst)
+	}
+	if concurrency > 0 {
+		l.slots = make(chan struct{}, concurrency)
+	}
+	return l
+}
+
+func (l *queryLimiter) enabled() bool {
+	return l.bucket != nil || l.slots != nil
+}
+
+// acquire 等待令牌及并发槽位，超过 deadline 时放弃
+func (l *queryLimiter) acquire(ctx context.Context, deadline time.Time) (release func(), err error) {
+	if l.bucket != nil {
+		now := time.Now()
+		wait := l.bucket.reserve(now)
+		if wait > 0 {
+			if now.Add(wait).After(deadline) {
+				l.bucket.cancel()
+				return nil, fmt.Errorf("rate limit exceeded")
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				l.bucket.cancel()
+				return nil, ctx.Err()
+			}
+		}
+	}
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("all %d query slots are in use", cap(l.slots))
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// queryThrottle 数据源及每个 Grafana 用户的查询速率和并发上限，超出的查询排队，等待超过 timeout 时失败
+type queryThrottle struct {
+	timeout    time.Duration
+	datasource *queryLimiter
+
+	userRate        int64
+	userBurst       int64
+	userConcurrency int64
+
+	mu    sync.Mutex
+	users map[string]*queryLimiter
+}
+
+func newQueryThrottle(s DatasourceSettings) *queryThrottle {
+	return &queryThrottle{
+		timeout:         secondsOr(s.QueryQueueTimeout, defaultQueryQueueTimeout),
+		datasource:      newQueryLimiter(int64(s.QueryRateLimit), int64(s.QueryBurst), int64(s.MaxConcurrentQueries)),
+		userRate:        int64(s.UserQueryRateLimit),
+		userBurst:       int64(s.UserQueryBurst),
+		userConcurrency: int64(s.UserMaxConcurrentQueries),
+		users:           map[string]*queryLimiter{},
+	}
+}
+
+// userKey 用户的 login，没有用户信息的查询 (如告警) 不受用户上限限制
+func userKey(user *backend.User) string {
+	if user == nil {
+		return ""
+	}
+	if user.Login != "" {
+		return user.Login
+	}
+	return user.Email
+}
+
+func (t *queryThrottle) user(key string) *queryLimiter {
+	if key == "" || (t.userRate <= 0 && t.userConcurrency <= 0) {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	// 顺便清理空闲且没有进行中查询的用户
+	for k, l := range t.users {
+		if now.Sub(l.lastUsed) > userThrottleIdle && (l.slots == nil || len(l.slots) == 0) {
+			delete(t.users, k)
+		}
+	}
+	l, ok := t.users[key]
+	if !ok {
+		l = newQueryLimiter(t.userRate, t.userBurst, t.userConcurrency)
+		t.users[key] = l
+	}
+	l.lastUsed = now
+	return l
+}
+
+// acquire 先等待用户的上限再等待数据源的上限，避免一个用户排队时占用数据源的并发槽位
+func (t *queryThrottle) acquire(ctx context.Context, user *backend.User) (release func(), err error) {
+	userLimiter := t.user(userKey(user))
+	if !t.datasource.enabled() && userLimiter == nil {
+		return func() {}, nil
+	}
+	// ctx 的截止时间不作为排队的截止时间，ctx 结束时返回 ctx 的错误
+	start := time.Now()
+	deadline := start.Add(t.timeout)
+
+	defer func() {
+		queryStatsFromContext(ctx).setQueueWait(time.Since(start))
+	}()
+
+	releaseUser := func() {}
+	if userLimiter != nil {
+		if releaseUser, err = userLimiter.acquire(ctx, deadline); err != nil {
+			// 请求被取消或超时不是被限流
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			observeThrottled("user")
+			return nil, fmt.Errorf("%w for user %s: %s, retry later", ErrTooManyQueries, userKey(user), err.Error())
+		}
+	}
+	releaseDatasource, err := t.datasource.acquire(ctx, deadline)
+	if err != nil {
+		releaseUser()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		observeThrottled("datasource")
+		return nil, fmt.Errorf("%w: %s, retry later", ErrTooManyQueries, err.Error())
+	}
+	observeQueueWait(time.Since(start))
+	return func() {
+		releaseDatasource()
+		releaseUser()
+	}, nil
+}
+
+// throttledQuery 在速率及并发上限内执行查询，panic 时也释放槽位
+func (d *Datasource) throttledQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) (backend.DataResponse, error) {
+	release, err := d.throttle.acquire(ctx, pCtx.User)
+	if err != nil {
+		return backend.DataResponse{}, err
+	}
+	defer release()
+	return d.query(ctx, pCtx, query)
+}
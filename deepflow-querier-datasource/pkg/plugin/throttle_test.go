@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// newTestThrottle 排队最多 50ms
+func newTestThrottle(s DatasourceSettings) *queryThrottle {
+	throttle := newQueryThrottle(s)
+	throttle.timeout = 50 * time.Millisecond
+	return throttle
+}
+
+func TestThrottleConcurrencyPerUser(t *testing.T) {
+	throttle := newTestThrottle(DatasourceSettings{UserMaxConcurrentQueries: 1, MaxConcurrentQueries: 2})
+	alice, bob := &backend.User{Login: "alice"}, &backend.User{Login: "bob"}
+
+	release, err := throttle.acquire(context.Background(), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := throttle.acquire(context.Background(), alice); !errors.Is(err, ErrTooManyQueries) || !strings.Contains(err.Error(), "for user alice") {
+		t.Errorf("second query of alice: error = %v, want %v", err, ErrTooManyQueries)
+	}
+	releaseBob, err := throttle.acquire(context.Background(), bob)
+	if err != nil {
+		t.Fatalf("query of bob: %v", err)
+	}
+	// 数据源的两个槽位都在使用中，没有用户信息的查询也需要排队
+	if _, err := throttle.acquire(context.Background(), nil); !errors.Is(err, ErrTooManyQueries) {
+		t.Errorf("query without user: error = %v, want %v", err, ErrTooManyQueries)
+	}
+
+	release()
+	releaseBob()
+	if release, err = throttle.acquire(context.Background(), alice); err != nil {
+		t.Fatalf("query of alice after release: %v", err)
+	}
+	release()
+}
+
+func TestThrottleRate(t *testing.T) {
+	throttle := newTestThrottle(DatasourceSettings{QueryRateLimit: 1, QueryBurst: 2})
+	for i := 0; i < 2; i++ {
+		release, err := throttle.acquire(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("query %d within the burst: %v", i, err)
+		}
+		release()
+	}
+	// 下一个令牌在约 1 秒后，超过等待时间时直接失败并归还令牌
+	if _, err := throttle.acquire(context.Background(), nil); !errors.Is(err, ErrTooManyQueries) {
+		t.Errorf("error = %v, want %v", err, ErrTooManyQueries)
+	}
+	if tokens := throttle.datasource.bucket.tokens; tokens < -0.01 {
+		t.Errorf("tokens = %v, the token of the rejected query was not returned", tokens)
+	}
+}
+
+// TestThrottleCanceled 排队中的请求被取消时返回 ctx 的错误，不是限流
+func TestThrottleCanceled(t *testing.T) {
+	throttle := newQueryThrottle(DatasourceSettings{MaxConcurrentQueries: 1})
+	release, err := throttle.acquire(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := throttle.acquire(ctx, nil); err != context.Canceled {
+		t.Errorf("error = %v, want %v", err, context.Canceled)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := throttle.acquire(ctx, nil); err != context.DeadlineExceeded {
+		t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// TestThrottleQueueWait 排队时间记录在 queryStats 中，不计入查询及生成 frame 的耗时
+func TestThrottleQueueWait(t *testing.T) {
+	throttle := newTestThrottle(DatasourceSettings{MaxConcurrentQueries: 1})
+	release, err := throttle.acquire(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(30*time.Millisecond, release)
+
+	ctx, stats := withQueryStats(context.Background())
+	releaseQueued, err := throttle.acquire(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseQueued()
+	if stats.queueWait < 20*time.Millisecond {
+		t.Errorf("queueWait = %v, want the time waiting for the first query", stats.queueWait)
+	}
+}
+
+func TestQueryDataTooManyRequests(t *testing.T) {
+	c := loadGoldenCase(t, "testdata/cases/trafficQuery_table.json")
+	server := newFakeDeepflowServer(t, c.Responses)
+	d := newTestDatasource(t, map[string]interface{}{
+		"requestUrl":           server.URL,
+		"traceUrl":             server.URL,
+		"disableCache":         true,
+		"maxConcurrentQueries": 1,
+		"queryQueueTimeout":    1,
+	})
+	release, err := d.throttle.acquire(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	res := queryGoldenCase(t, d, c)
+	if res.Status != backend.StatusTooManyRequests || res.Error == nil || !strings.HasPrefix(res.Error.Error(), ErrTooManyQueries.Error()) {
+		t.Errorf("status = %d, error = %v, want %d %v", res.Status, res.Error, backend.StatusTooManyRequests, ErrTooManyQueries)
+	}
+	if n := len(server.recorded()); n != 0 {
+		t.Errorf("got %d requests to deepflow-server, want none", n)
+	}
+}
@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const traceContextMiddlewareName = "deepflow-trace-context"
+
+// 使用 SDK 的 tracer，Grafana 开启 tracing 时 span 上报到 Grafana 配置的 collector
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.DefaultTracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan 记录错误并结束 span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endQuerySpan 结束子查询的 span，记录返回的 frame 及行数
+func endQuerySpan(span trace.Span, res backend.DataResponse, err error) {
+	rows := 0
+	for _, frame := range res.Frames {
+		rows += frame.Rows()
+	}
+	span.SetAttributes(attribute.Int("frames", len(res.Frames)), attribute.Int("rows", rows))
+	endSpan(span, err)
+}
+
+// startFrameSpan 查询结果转换为 frame 的 span，在 query 的各个分支中请求结束后开始
+func startFrameSpan(ctx context.Context, appType string, rows int) trace.Span {
+	_, span := startSpan(ctx, "deepflow.buildFrames",
+		attribute.String("app_type", appType),
+		attribute.Int("querier_rows", rows))
+	return span
+}
+
+// traceContextMiddleware Grafana 未配置 propagation 时全局 propagator 为空，
+// 这里总是按 W3C 格式注入 traceparent，便于在 deepflow-server 侧关联插件的请求
+func traceContextMiddleware() httpclient.Middleware {
+	propagator := propagation.TraceContext{}
+	return httpclient.NamedMiddlewareFunc(traceContextMiddlewareName, func(_ httpclient.Options, next http.RoundTripper) http.RoundTripper {
+		return httpclient.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("traceparent") == "" && trace.SpanContextFromContext(req.Context()).IsValid() {
+				propagator.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+			}
+			return next.RoundTrip(req)
+		})
+	})
+}
+
+func querierRows(res *QuerierResponse) int {
+	if res == nil {
+		return 0
+	}
+	return res.Values.Len()
+}
@@ -0,0 +1,177 @@
+package querycache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache stores encoded querier results. Implementations must be safe for
+// concurrent use.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// Options are the settings shared by all cache backends.
+type Options struct {
+	TTL       time.Duration
+	MaxMemory int64
+}
+
+// Factory creates a cache backend.
+type Factory func(opts Options) (Cache, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{
+		"memory": func(opts Options) (Cache, error) {
+			return NewLRU(opts.MaxMemory), nil
+		},
+	}
+)
+
+// Register makes an external cache backend available by name.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = factory
+}
+
+// New creates the cache backend registered under name, "" means "memory".
+func New(name string, opts Options) (Cache, error) {
+	if name == "" {
+		name = "memory"
+	}
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown cache backend: %s", name)
+	}
+	return factory(opts)
+}
+
+// LRU is an in-memory cache bounded by the total size of the stored values.
+type LRU struct {
+	mu        sync.Mutex
+	maxMemory int64
+	used      int64
+	ll        *list.List
+	items     map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRU creates an in-memory cache holding at most maxMemory bytes.
+func NewLRU(maxMemory int64) *LRU {
+	return &LRU{
+		maxMemory: maxMemory,
+		ll:        list.New(),
+		items:     make(map[string]*list.Element),
+	}
+}
+
+func (c *LRU) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *LRU) Set(key string, value []byte, ttl time.Duration) {
+	size := int64(len(key) + len(value))
+	if ttl <= 0 || size > c.maxMemory {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value, expires: time.Now().Add(ttl)})
+	c.used += size
+	for c.used > c.maxMemory {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Len returns the number of cached entries.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRU) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.key)
+	c.used -= int64(len(e.key) + len(e.value))
+}
+
+var (
+	spaceRegexp = regexp.MustCompile(`\s+`)
+	// time(time, N) with more arguments, e.g. an offset, is not aligned to N
+	// and is not matched.
+	intervalRegexp = regexp.MustCompile("(?i)\\btime\\(\\s*time\\s*,\\s*(\\d+)\\s*\\)\\s+AS\\s+`?(\\w+)`?")
+	limitRegexp    = regexp.MustCompile(`(?i)\b(S?LIMIT|OFFSET|ORDER\s+BY)\b`)
+)
+
+// NormalizeSQL collapses whitespace so that formatting differences share a key.
+func NormalizeSQL(sql string) string {
+	return strings.TrimSpace(spaceRegexp.ReplaceAllString(sql, " "))
+}
+
+// Key builds a cache key, parts are joined in order and hashed.
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Align rounds ts down to a multiple of step.
+func Align(ts, step int64) int64 {
+	if step <= 0 {
+		return ts
+	}
+	return ts - ts%step
+}
+
+// SplitInterval returns the bucket interval and the bucket column of a time
+// series sql when its rows can be fetched in several time ranges and
+// concatenated, i.e. it groups by time(time, N) AS alias, with buckets aligned
+// to multiples of N, and has no limit, offset or order by that spans the whole
+// range.
+func SplitInterval(sql string) (interval int64, column string, ok bool) {
+	m := intervalRegexp.FindStringSubmatch(sql)
+	if len(m) < 3 || !strings.Contains(strings.ToUpper(sql), "GROUP BY") || limitRegexp.MatchString(sql) {
+		return 0, "", false
+	}
+	interval, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || interval <= 0 {
+		return 0, "", false
+	}
+	return interval, m[2], true
+}
@@ -0,0 +1,62 @@
+package querycache
+
+import "testing"
+
+func TestAlign(t *testing.T) {
+	cases := []struct {
+		ts, step, want int64
+	}{
+		{ts: 1000, step: 300, want: 900},
+		{ts: 900, step: 300, want: 900},
+		{ts: 899, step: 300, want: 600},
+		{ts: 1000, step: 0, want: 1000},
+		{ts: 1000, step: -60, want: 1000},
+	}
+	for _, c := range cases {
+		if got := Align(c.ts, c.step); got != c.want {
+			t.Errorf("Align(%d, %d) = %d, want %d", c.ts, c.step, got, c.want)
+		}
+	}
+}
+
+func TestSplitInterval(t *testing.T) {
+	cases := []struct {
+		sql      string
+		interval int64
+		column   string
+		ok       bool
+	}{
+		{
+			sql:      "SELECT time(time, 60) AS `time_60`, Sum(byte) AS `b` FROM network WHERE time >= 1 GROUP BY `time_60`",
+			interval: 60, column: "time_60", ok: true,
+		},
+		{
+			sql:      "select TIME( time ,300 ) as t, Sum(byte) from network group by t",
+			interval: 300, column: "t", ok: true,
+		},
+		// 带 offset 参数的时间桶不对齐到 N 的整数倍
+		{sql: "SELECT time(time, 86400, 1, '', 28800) AS `t` FROM network GROUP BY `t`"},
+		// 没有别名时无法确定时间列
+		{sql: "SELECT time(time, 60), Sum(byte) FROM network GROUP BY time(time, 60)"},
+		{sql: "SELECT time(time, 60) AS `t`, Sum(byte) FROM network"},
+		{sql: "SELECT time(time, 60) AS `t` FROM network GROUP BY `t` LIMIT 10"},
+		{sql: "SELECT time(time, 60) AS `t` FROM network GROUP BY `t` ORDER BY `t`"},
+		{sql: "SELECT time(time, 0) AS `t` FROM network GROUP BY `t`"},
+		{sql: "SELECT Sum(byte) FROM network GROUP BY ip"},
+	}
+	for _, c := range cases {
+		interval, column, ok := SplitInterval(c.sql)
+		if interval != c.interval || column != c.column || ok != c.ok {
+			t.Errorf("SplitInterval(%q) = %d, %q, %v, want %d, %q, %v", c.sql, interval, column, ok, c.interval, c.column, c.ok)
+		}
+	}
+}
+
+func TestNormalizeSQLAndKey(t *testing.T) {
+	if got := NormalizeSQL("  SELECT  a,\n\tb FROM t "); got != "SELECT a, b FROM t" {
+		t.Errorf("NormalizeSQL = %q", got)
+	}
+	if Key("a", "bc") == Key("ab", "c") {
+		t.Error("keys of different parts collide")
+	}
+}
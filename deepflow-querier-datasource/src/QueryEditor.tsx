@@ -2,7 +2,7 @@ import React, { PureComponent } from 'react'
 import { QueryEditorProps, VariableModel } from '@grafana/data'
 import { DataSource } from './datasource'
 import { MyDataSourceOptions, MyQuery } from './types'
-import { Button, InlineField, Select, Input, Alert, getTheme, Icon, Tooltip } from '@grafana/ui'
+import { Button, InlineField, InlineSwitch, Select, Input, Alert, getTheme, Icon, Tooltip } from '@grafana/ui'
 import { BasicData, QueryEditorFormRow } from './components/QueryEditorFormRow'
 import _ from 'lodash'
 import * as querierJs from 'deepflow-sdk-js'
@@ -14,7 +14,9 @@ import {
   getParamByName,
   addTimeToWhere,
   uuid,
-  queryCondsFilter
+  queryCondsFilter,
+  parseSeriesFunctions,
+  formatSeriesFunctions
 } from 'utils/tools'
 import { getTemplateSrv } from '@grafana/runtime'
 import {
@@ -26,6 +28,12 @@ import {
   defaultFormDB,
   DISABLE_TAGS,
   formatAsOpts,
+  logsFormatAsOpts,
+  fillOpts,
+  downsampleOpts,
+  seriesFunctionOpts,
+  SERIES_FUNCTION_OPS,
+  SERIES_FUNCTION_PARAM_FUNCS,
   formItemConfigs,
   FormTypes,
   GROUP_BY_DISABLE_TAG_TYPES,
@@ -47,6 +55,7 @@ import {
 import { DATA_SOURCE_SETTINGS, getTagMapCache, SQL_CACHE } from 'utils/cache'
 import { INPUT_TAG_VAL_TYPES, SELECT_TAG_VAL_OPS } from 'components/TagValueSelector'
 import { TracingIdSelector } from 'components/TracingIdSelector'
+import { SubFuncsEditor } from 'components/SubFuncsEditor'
 import { format as sqlFormatter } from 'sql-formatter'
 import './QueryEditor.css'
 import { getI18NLabelByName } from 'utils/i18n'
@@ -116,9 +125,17 @@ export class QueryEditor extends PureComponent<Props> {
     slimit: string
     limit: string
     offset: string
-    formatAs: 'timeSeries' | 'table' | ''
+    formatAs: 'timeSeries' | 'table' | 'logs' | 'heatmap' | 'histogram' | ''
     alias: string
     showMetrics: ShowMetricsVal
+    exemplars: boolean
+    logsTemplate: string
+    seriesFunctions: string
+    fill: string
+    trimPartialBuckets: boolean
+    downsample: string
+    bucketTag: string
+    clusters: string
     tracingId: LabelItem | null
     errorMsg: string
     showErrorAlert: boolean
@@ -1324,6 +1341,14 @@ export class QueryEditor extends PureComponent<Props> {
       formatAs,
       alias,
       showMetrics,
+      exemplars,
+      logsTemplate,
+      seriesFunctions,
+      fill,
+      trimPartialBuckets,
+      downsample,
+      bucketTag,
+      clusters,
       tracingId
     } = this.state
     return (
@@ -1570,6 +1595,23 @@ export class QueryEditor extends PureComponent<Props> {
                         </div>
                       </InlineField>
                     ) : null}
+                    {!this.usingProfilingType ? (
+                      <InlineField
+                        className="custom-label"
+                        label="CLUSTERS"
+                        labelWidth={10}
+                        tooltip="comma separated cluster names of the datasource, * for all clusters"
+                      >
+                        <div className="w-100-percent">
+                          <Input
+                            value={clusters}
+                            onChange={(ev: any) => this.onFieldChange('clusters', ev.target)}
+                            placeholder="default"
+                            width={16}
+                          />
+                        </div>
+                      </InlineField>
+                    ) : null}
                   </div>
                   {this.usingGroupBy && !this.usingAccessRelationshipType && !this.usingProfilingType ? (
                     <div className="row-start-center">
@@ -1583,6 +1625,21 @@ export class QueryEditor extends PureComponent<Props> {
                           width="auto"
                         />
                       </InlineField>
+                      {formatAs === 'heatmap' || formatAs === 'histogram' ? (
+                        <InlineField
+                          className="custom-label"
+                          label="BUCKET TAG"
+                          labelWidth={12}
+                          tooltip="optional tag whose values are the upper bounds of the buckets, the first metric is the count per bucket (not cumulative). Empty: the values of the first metric, e.g. Avg(rrt), are counted in power-of-2 buckets"
+                        >
+                          <Input
+                            value={bucketTag}
+                            onChange={(ev: any) => this.onFieldChange('bucketTag', ev.target)}
+                            placeholder="first numeric tag"
+                            width={28}
+                          />
+                        </InlineField>
+                      ) : null}
                       {formatAs === 'timeSeries' ? (
                         <>
                           <InlineField className="custom-label" label="ALIAS" labelWidth={6}>
@@ -1628,10 +1685,113 @@ export class QueryEditor extends PureComponent<Props> {
                               </Tooltip>
                             </div>
                           </InlineField>
+                          <InlineField className="custom-label" label="FILL" labelWidth={6}>
+                            <Select
+                              options={fillOpts}
+                              value={fill}
+                              onChange={(val: any) => this.onFieldChange('fill', val)}
+                              placeholder="FILL"
+                              width="auto"
+                            />
+                          </InlineField>
+                          <InlineField
+                            className="custom-label"
+                            label="TRIM PARTIAL"
+                            labelWidth={14}
+                            tooltip="drop the first and last buckets when the time range covers them only partially"
+                          >
+                            <InlineSwitch
+                              value={!!trimPartialBuckets}
+                              onChange={(ev: any) => this.onFieldChange('trimPartialBuckets', ev.currentTarget.checked)}
+                            />
+                          </InlineField>
+                          <InlineField
+                            className="custom-label"
+                            label="DOWNSAMPLE"
+                            labelWidth={12}
+                            tooltip="reduce series with more points than max data points of the panel"
+                          >
+                            <Select
+                              options={downsampleOpts}
+                              value={downsample || 'none'}
+                              onChange={(val: any) => this.onFieldChange('downsample', val)}
+                              placeholder="DOWNSAMPLE"
+                              width="auto"
+                            />
+                          </InlineField>
+                          <InlineField
+                            className="custom-label"
+                            label="FUNCTIONS"
+                            labelWidth={11}
+                            tooltip="functions applied to every metric of the series in order: rate, increase, derivative, movingAverage(n), movingMedian(n), cumulativeSum, scale(factor), topK(k, agg), bottomK(k, agg)"
+                          >
+                            <SubFuncsEditor
+                              title=""
+                              subFuncs={parseSeriesFunctions(seriesFunctions)}
+                              subFuncOpts={seriesFunctionOpts}
+                              funcOps={SERIES_FUNCTION_OPS}
+                              paramFuncs={SERIES_FUNCTION_PARAM_FUNCS}
+                              onSubFuncsChange={funcs => this.onFieldChange('seriesFunctions', formatSeriesFunctions(funcs))}
+                              usingAlerting={this.usingAlerting}
+                              templateVariableOptsFull={templateVariableOpts}
+                            />
+                          </InlineField>
+                          {appType === APP_TYPE.METRICS ? (
+                            <InlineField className="custom-label" label="EXEMPLARS" labelWidth={11}>
+                              <div
+                                style={{
+                                  display: 'flex',
+                                  alignItems: 'center'
+                                }}
+                              >
+                                <InlineSwitch
+                                  value={!!exemplars}
+                                  onChange={(ev: any) => this.onFieldChange('exemplars', ev.currentTarget.checked)}
+                                />
+                                <Tooltip
+                                  placement="top"
+                                  content={
+                                    <span>attach the slowest or erroring request of each bucket from l7_flow_log.</span>
+                                  }
+                                >
+                                  <Icon
+                                    style={{
+                                      cursor: 'pointer',
+                                      marginLeft: '4px'
+                                    }}
+                                    name="question-circle"
+                                  />
+                                </Tooltip>
+                              </div>
+                            </InlineField>
+                          ) : null}
                         </>
                       ) : null}
                     </div>
                   ) : null}
+                  {!this.usingGroupBy && db === 'flow_log' ? (
+                    <div className="row-start-center">
+                      <InlineField className="custom-label" label="FORMAT AS" labelWidth={11}>
+                        <Select
+                          options={logsFormatAsOpts}
+                          value={formatAs === 'logs' ? 'logs' : 'table'}
+                          onChange={(val: any) => this.onFieldChange('formatAs', val)}
+                          placeholder="FORMAT_AS"
+                          width="auto"
+                        />
+                      </InlineField>
+                      {formatAs === 'logs' ? (
+                        <InlineField className="custom-label" label="LOG BODY" labelWidth={10}>
+                          <Input
+                            value={logsTemplate}
+                            onChange={(ev: any) => this.onFieldChange('logsTemplate', ev.target)}
+                            placeholder="${request_type} ${request_domain}${request_resource} ${response_code}"
+                            width={48}
+                          />
+                        </InlineField>
+                      ) : null}
+                    </div>
+                  ) : null}
                 </>
               ) : (
                 <InlineField className="custom-label" label="_id" labelWidth={10}>
@@ -5,8 +5,14 @@ import { SelectableValue } from '@grafana/data'
 import { VAR_INTERVAL_LABEL } from 'consts'
 interface SubFuncsEditorProps {
   customClassName?: string
+  // title before the function select, SUB FUNCTIONS: when not set
+  title?: string
   subFuncs: any[]
   subFuncOpts: SelectOptsWithStringValue
+  // functions with an op select and its options, Math when not set
+  funcOps?: Record<string, SelectOpts>
+  // functions with a number parameter, Math when not set
+  paramFuncs?: string[]
   onSubFuncsChange: (newSubFuncs: GenFuncDisplayNameParam[]) => void
   usingAlerting: boolean
   templateVariableOptsFull: SelectOpts
@@ -15,7 +21,7 @@ interface SubFuncsEditorProps {
 interface GenFuncDisplayNameParam {
   func: string
   op: string
-  params: number | undefined
+  params: number | string | undefined
 }
 
 interface MathOpsMap {
@@ -43,11 +49,16 @@ export function SubFuncsEditor(props: SubFuncsEditorProps) {
   const [op, setOp] = useState('')
   const [params, setParams] = useState<number | string | undefined>(undefined)
 
+  const funcOps = props.funcOps || { Math: mathOperatorOpts }
+  const paramFuncs = props.paramFuncs || ['Math']
+  const isMath = func.toLocaleLowerCase() === 'math'
+
   function genFuncDisplayName(item: GenFuncDisplayNameParam) {
     if (item.func.toLocaleLowerCase() === 'math') {
       return `${item.func}(${mathOpsMap[item.op as MathOpKeys]}${item.params as number})`
     }
-    return item.func
+    const args = [item.params, item.op].filter(e => e !== undefined && e !== '')
+    return args.length ? `${item.func}(${args.join(', ')})` : item.func
   }
 
   const subFuncOpts = [
@@ -59,19 +70,24 @@ export function SubFuncsEditor(props: SubFuncsEditorProps) {
         })
       )
     }),
-    {
-      label: 'Math',
-      value: 'Math'
-    }
+    ...(props.funcOps
+      ? []
+      : [
+          {
+            label: 'Math',
+            value: 'Math'
+          }
+        ])
   ]
 
   const noFunc = !func
   const isVariableParams = props.templateVariableOptsFull.find(e => e.label === params)
-  const mathWithoutOpOrParams =
-    func.toLocaleLowerCase() === 'math' &&
-    (!op || !(Number.isInteger(params) || params === VAR_INTERVAL_LABEL || isVariableParams))
+  const validParams = isMath ? Number.isInteger(params) : typeof params === 'number'
+  const withoutOp = !!funcOps[func] && !op
+  const withoutParams =
+    paramFuncs.includes(func) && !(validParams || params === VAR_INTERVAL_LABEL || isVariableParams)
   const sunFuncsMaxLen = props.subFuncs.length >= 8
-  const addBtnDisable = noFunc || mathWithoutOpOrParams || sunFuncsMaxLen
+  const addBtnDisable = noFunc || withoutOp || withoutParams || sunFuncsMaxLen
 
   function onAddBtnClick(ev: React.MouseEvent<HTMLButtonElement>) {
     const basic: {
@@ -81,13 +97,16 @@ export function SubFuncsEditor(props: SubFuncsEditorProps) {
     } = {
       func
     }
-    if (func.toLocaleLowerCase() !== 'math') {
+    if (funcOps[func]) {
+      basic.op = op
+    }
+    if (paramFuncs.includes(func)) {
+      basic.params = params
+    }
+    if (!isMath) {
       setFunc('')
       setOp('')
       setParams(undefined)
-    } else {
-      basic.op = op
-      basic.params = params
     }
     props.onSubFuncsChange([...props.subFuncs, basic])
     ev.stopPropagation()
@@ -129,7 +148,7 @@ export function SubFuncsEditor(props: SubFuncsEditorProps) {
       }}
     >
       <div className="sub-functions-editor">
-        <p className="sub-functions-title">SUB FUNCTIONS:</p>
+        {props.title !== '' ? <p className="sub-functions-title">{props.title || 'SUB FUNCTIONS:'}</p> : null}
         <Select
           width="auto"
           options={subFuncOpts}
@@ -141,10 +160,10 @@ export function SubFuncsEditor(props: SubFuncsEditorProps) {
           placeholder="SUB FUNC"
           key={func ? 'funcSelWithValue' : 'funcSelWithoutValue'}
         ></Select>
-        {func === 'Math' ? (
+        {funcOps[func] ? (
           <Select
             width="auto"
-            options={mathOperatorOpts}
+            options={funcOps[func]}
             value={op}
             onChange={ev => {
               setOp(ev.value || '')
@@ -152,17 +171,17 @@ export function SubFuncsEditor(props: SubFuncsEditorProps) {
             placeholder="OP"
           ></Select>
         ) : null}
-        {func === 'Math' && op ? (
+        {paramFuncs.includes(func) && (!funcOps[func] || op) ? (
           <Select
             allowCustomValue
             options={numberOpts}
             value={params}
             onCreateOption={v => {
-              const valueNumber = parseInt(v, 10)
+              const valueNumber = isMath ? parseInt(v, 10) : parseFloat(v)
               let _v: number
               if (isNaN(valueNumber)) {
                 return
-              } else if (valueNumber < 1) {
+              } else if (isMath && valueNumber < 1) {
                 _v = 1
               } else {
                 _v = valueNumber
@@ -76,8 +76,93 @@ export const formatAsOpts: SelectOpts = [
   {
     label: 'Table',
     value: 'table'
+  },
+  {
+    label: 'Heatmap',
+    value: 'heatmap'
+  },
+  {
+    label: 'Histogram',
+    value: 'histogram'
+  }
+]
+export const logsFormatAsOpts: SelectOpts = [
+  {
+    label: 'Table',
+    value: 'table'
+  },
+  {
+    label: 'Logs',
+    value: 'logs'
+  }
+]
+export const fillOpts: SelectOpts = [
+  {
+    label: 'none',
+    value: ''
+  },
+  {
+    label: 'null',
+    value: 'null'
+  },
+  {
+    label: 'zero',
+    value: 'zero'
+  },
+  {
+    label: 'previous',
+    value: 'previous'
+  },
+  {
+    label: 'linear',
+    value: 'linear'
+  }
+]
+export const downsampleOpts: SelectOpts = [
+  {
+    label: 'none',
+    value: 'none'
+  },
+  {
+    label: 'lttb',
+    value: 'lttb'
+  },
+  {
+    label: 'avg',
+    value: 'avg'
+  },
+  {
+    label: 'min',
+    value: 'min'
+  },
+  {
+    label: 'max',
+    value: 'max'
   }
 ]
+export const seriesFunctionOpts = [
+  'rate',
+  'increase',
+  'derivative',
+  'movingAverage',
+  'movingMedian',
+  'cumulativeSum',
+  'scale',
+  'topK',
+  'bottomK'
+].map(value => {
+  return {
+    label: value,
+    value
+  }
+})
+// series functions with a number parameter
+export const SERIES_FUNCTION_PARAM_FUNCS = ['movingAverage', 'movingMedian', 'scale', 'topK', 'bottomK']
+// series functions with an aggregator, the op of the function
+export const SERIES_FUNCTION_OPS: Record<string, SelectOpts> = {
+  topK: ['avg', 'sum', 'max', 'min', 'last'].map(value => ({ label: value, value })),
+  bottomK: ['avg', 'sum', 'max', 'min', 'last'].map(value => ({ label: value, value }))
+}
 export const intervalOpts: SelectOpts = [
   {
     label: '1s',
@@ -186,9 +271,17 @@ export type QueryDataType = {
   slimit: string
   limit: string
   offset: string
-  formatAs: 'timeSeries' | 'table' | ''
+  formatAs: 'timeSeries' | 'table' | 'logs' | 'heatmap' | 'histogram' | ''
   alias: string
   showMetrics: ShowMetricsVal
+  exemplars: boolean
+  logsTemplate: string
+  seriesFunctions: string
+  fill: string
+  trimPartialBuckets: boolean
+  downsample: string
+  bucketTag: string
+  clusters: string
 }
 
 export const defaultFormDB: Pick<QueryDataType, 'db' | 'sources'> = {
@@ -247,7 +340,15 @@ export const defaultFormData: Omit<QueryDataType, 'appType' | 'db' | 'sources'>
   offset: '',
   formatAs: 'timeSeries',
   alias: '',
-  showMetrics: -1
+  showMetrics: -1,
+  exemplars: false,
+  logsTemplate: '',
+  seriesFunctions: '',
+  fill: '',
+  trimPartialBuckets: false,
+  downsample: 'none',
+  bucketTag: '',
+  clusters: ''
 }
 
 export const ID_PREFIX = 'id-'
@@ -1,4 +1,10 @@
-import { DataQueryRequest, DataQueryResponse, DataSourceInstanceSettings, ScopedVars } from '@grafana/data'
+import {
+  DataQueryRequest,
+  DataQueryResponse,
+  DataSourceInstanceSettings,
+  QueryFixAction,
+  ScopedVars
+} from '@grafana/data'
 
 import { MyQuery, MyDataSourceOptions } from './types'
 import {
@@ -26,9 +32,14 @@ import { DATA_SOURCE_SETTINGS, QUERY_DATA_CACHE, SQL_CACHE } from 'utils/cache'
 import { MyVariableQuery } from 'components/VariableQueryEditor'
 import { Observable, of, zip } from 'rxjs'
 import { catchError, switchMap } from 'rxjs/operators'
-import { APP_TYPE } from 'consts'
+import { APP_TYPE, defaultItem } from 'consts'
 import DataStreamer from 'utils/dataStreamer'
 
+// SupplementaryQueryType of Grafana >= 10, not in @grafana/data 9.2
+const LOGS_VOLUME = 'LogsVolume'
+// time bucket of the log volume histogram, in seconds
+const LOGS_VOLUME_INTERVAL = '60'
+
 export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptions> {
   url: string
   constructor(instanceSettings: DataSourceInstanceSettings<MyDataSourceOptions>) {
@@ -196,6 +207,62 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
     )
   }
 
+  getSupportedSupplementaryQueryTypes(): string[] {
+    return [LOGS_VOLUME]
+  }
+
+  // log volume of a logs query: the count of log rows per time bucket with the same filters
+  getSupplementaryQuery(options: { type: string }, query: MyQuery): MyQuery | undefined {
+    if (options.type !== LOGS_VOLUME || !query.queryText) {
+      return undefined
+    }
+    const queryData = JSON.parse(query.queryText)
+    if (queryData.formatAs !== 'logs') {
+      return undefined
+    }
+    return {
+      ...query,
+      refId: `log-volume-${query.refId}`,
+      queryText: JSON.stringify({
+        ...queryData,
+        select: [{ ...defaultItem(), type: 'metric', key: 'row', func: 'Count' }],
+        groupBy: [{ ...defaultItem(), type: 'tag' }],
+        orderBy: [{ ...defaultItem(), type: 'metric', sort: 'asc' }],
+        interval: LOGS_VOLUME_INTERVAL,
+        formatAs: 'timeSeries',
+        alias: 'logs',
+        exemplars: false,
+        seriesFunctions: '',
+        fill: '',
+        downsample: 'lttb'
+      })
+    }
+  }
+
+  // ad-hoc filters of Explore: filter for / filter out a label of a log line
+  modifyQuery(query: MyQuery, action: QueryFixAction): MyQuery {
+    const { key, value } = action.options || {}
+    if (!query.queryText || !key || !['ADD_FILTER', 'ADD_FILTER_OUT'].includes(action.type)) {
+      return query
+    }
+    const queryData = JSON.parse(query.queryText)
+    // translated enum labels are named Enum(tag)
+    const enumMatch = key.match(/^Enum\((.+)\)$/)
+    const where = (queryData.where || []).filter((e: any) => e.key)
+    where.push({
+      ...defaultItem(),
+      type: 'tag',
+      key: enumMatch ? enumMatch[1] : key,
+      func: enumMatch ? 'Enum' : '',
+      op: action.type === 'ADD_FILTER' ? '=' : '!=',
+      val: { label: value, value }
+    })
+    return {
+      ...query,
+      queryText: JSON.stringify({ ...queryData, where })
+    }
+  }
+
   applyTemplateVariables(query: MyQuery & { requestId: string }, scopedVars: ScopedVars): any {
     const queryDataOriginal = JSON.parse(query.queryText)
     const _queryText = replaceIntervalAndVariables(query.queryText, scopedVars)
@@ -37,4 +37,61 @@ export interface MyJsonData {
   token: string
   traceUrl: string
   aiUrl: string
+  timeout?: string
+  dialTimeout?: string
+  httpTLSHandshakeTimeout?: string
+  tlsAuth?: boolean
+  tlsAuthWithCACert?: boolean
+  tlsSkipVerify?: boolean
+  serverName?: string
+  maxRows?: number
+  maxResponseBytes?: number
+  disableCache?: boolean
+  cacheBackend?: string
+  cacheTTL?: number
+  cacheMaxMemory?: number
+  cacheAlignment?: number
+  retryMaxAttempts?: number
+  retryInitialBackoff?: number
+  retryMaxBackoff?: number
+  disableCircuitBreaker?: boolean
+  circuitBreakerFailures?: number
+  circuitBreakerCooldown?: number
+  logMaxPayloadBytes?: number
+  logSampleRate?: number
+  logRedactTags?: string
+  tracingLinks?: boolean
+  tracingLinkPadding?: string
+  logsTemplate?: string
+  logsTimezone?: string
+  translateEnums?: boolean
+  enumCacheTTL?: number
+  clusterName?: string
+  clusters?: Array<{ name: string; requestUrl: string; traceUrl?: string; token?: string }>
+  requireTimeFilter?: boolean
+  maxLimit?: number
+  maxSlimit?: number
+  maxTimeRange?: string
+  blockedTables?: string
+  blockedColumns?: string
+  maxEstimatedRows?: number
+  estimateMinTimeRange?: number
+  queryRateLimit?: number
+  queryBurst?: number
+  maxConcurrentQueries?: number
+  userQueryRateLimit?: number
+  userQueryBurst?: number
+  userMaxConcurrentQueries?: number
+  queryQueueTimeout?: number
+  recordDir?: string
+  replayDir?: string
+}
+
+/**
+ * Values that are encrypted by Grafana and only sent to the backend
+ */
+export interface MySecureJsonData {
+  tlsCACert?: string
+  tlsClientCert?: string
+  tlsClientKey?: string
 }
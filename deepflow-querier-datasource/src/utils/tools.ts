@@ -534,3 +534,38 @@ export function queryCondsFilter(conds: BasicDataWithId[] | undefined, type: 'ta
   })
   return result?.length ? result : defaultCond
 }
+
+type SeriesFunction = {
+  func: string
+  op?: string
+  params?: number | string
+}
+
+// parse seriesFunctions of the query, such as rate | topK(10, avg), into the items of SubFuncsEditor
+export function parseSeriesFunctions(seriesFunctions: string): SeriesFunction[] {
+  return (seriesFunctions || '')
+    .split('|')
+    .map(stage => stage.trim())
+    .filter(stage => stage)
+    .map(stage => {
+      const match = stage.match(/^(\w+)\s*(?:\((.*)\))?$/)
+      if (!match) {
+        return { func: stage }
+      }
+      const [params, op] = (match[2] || '').split(',').map(e => e.trim())
+      return {
+        func: match[1],
+        ...(params ? { params: isNaN(Number(params)) ? params : Number(params) } : {}),
+        ...(op ? { op } : {})
+      }
+    })
+}
+
+export function formatSeriesFunctions(funcs: SeriesFunction[]): string {
+  return funcs
+    .map(({ func, op, params }) => {
+      const args = [params, op].filter(e => e !== undefined && e !== '')
+      return args.length ? `${func}(${args.join(', ')})` : func
+    })
+    .join(' | ')
+}